@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"triple-s/storage"
+)
+
+// runBackup implements "triple-s backup", which archives a data directory
+// into a file, or to the standard output if the file is "-". It returns the
+// exit status, which is 1 if objects whose content does not match were left
+// out of an otherwise complete archive.
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := dataDirFlag(fs)
+	compress := fs.Bool("gzip", false, "Compress the archive with gzip")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: triple-s backup [--dir S] [--gzip] FILE")
+		fmt.Fprintln(os.Stderr, "Archives the data directory while the server is stopped; a running server serves GET /backup on its admin listener.")
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 {
+		return 2
+	}
+
+	store, err := storage.Open(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		return 2
+	}
+	out := os.Stdout
+	if fs.Arg(0) != "-" {
+		out, err = os.Create(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "backup:", err)
+			return 1
+		}
+	}
+	var archive io.Writer = out
+	var zw *gzip.Writer
+	if *compress {
+		zw = gzip.NewWriter(out)
+		archive = zw
+	}
+	skipped, err := store.Backup(archive)
+	for _, name := range skipped {
+		fmt.Fprintln(os.Stderr, "skipped: "+name+": content does not match its metadata")
+	}
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil && out != os.Stdout {
+		err = out.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		if out != os.Stdout {
+			os.Remove(out.Name())
+		}
+		return 1
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "backup: %d objects left out; run triple-s fsck\n", len(skipped))
+		return 1
+	}
+	return 0
+}
+
+// runRestore implements "triple-s restore", which recreates the buckets of
+// an archive made by backup in a data directory. Gzip-compressed archives
+// are recognized. It returns the exit status.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := dataDirFlag(fs)
+	bucketName := fs.String("bucket", "", "Restore only this bucket")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: triple-s restore [--dir S] [--bucket B] FILE")
+		fmt.Fprintln(os.Stderr, "Restores buckets which do not exist in the data directory while the server is stopped.")
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() != 1 {
+		return 2
+	}
+
+	in := os.Stdin
+	if fs.Arg(0) != "-" {
+		in, err = os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "restore:", err)
+			return 1
+		}
+		defer in.Close()
+	}
+	archive := bufio.NewReader(in)
+	var r io.Reader = archive
+	magic, _ := archive.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		r, err = gzip.NewReader(archive)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "restore:", err)
+			return 1
+		}
+	}
+
+	store, err := storage.Open(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 2
+	}
+	restored, err := store.Restore(r, *bucketName)
+	for _, name := range restored {
+		fmt.Println("restored: " + name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	return 0
+}
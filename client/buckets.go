@@ -0,0 +1,271 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TimeLayout is the format of the timestamps in server responses, which
+// are in the server's local time.
+const TimeLayout = "2006-01-02T15-04-05"
+
+func parseTime(value string) time.Time {
+	t, _ := time.ParseInLocation(TimeLayout, value, time.Local)
+	return t
+}
+
+type Bucket struct {
+	Name             string
+	CreationTime     time.Time
+	LastModifiedTime time.Time
+	Status           string
+}
+
+type bucketElement struct {
+	Name             string `xml:"Name"`
+	CreationTime     string `xml:"CreationTime"`
+	LastModifiedTime string `xml:"LastModifiedTime"`
+	Status           string `xml:"Status"`
+}
+
+func (b bucketElement) bucket() Bucket {
+	return Bucket{
+		Name:             b.Name,
+		CreationTime:     parseTime(b.CreationTime),
+		LastModifiedTime: parseTime(b.LastModifiedTime),
+		Status:           b.Status,
+	}
+}
+
+// decode reads the XML document of a successful response into v.
+func decode(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	err := xml.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return &Error{StatusCode: resp.StatusCode, Code: ErrUnexpectedResponse.Code, Message: err.Error(), RequestId: resp.Header.Get("x-amz-request-id")}
+	}
+	return nil
+}
+
+// ListBuckets returns the buckets in the order they were created.
+func (c *Client) ListBuckets(ctx context.Context) ([]Bucket, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet})
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Buckets []bucketElement `xml:"Buckets>Bucket"`
+	}
+	err = decode(resp, &result)
+	if err != nil {
+		return nil, err
+	}
+	bkts := make([]Bucket, 0, len(result.Buckets))
+	for _, b := range result.Buckets {
+		bkts = append(bkts, b.bucket())
+	}
+	return bkts, nil
+}
+
+func (c *Client) CreateBucket(ctx context.Context, bucketName string) (Bucket, error) {
+	return c.createBucket(ctx, bucketName, nil)
+}
+
+func (c *Client) createBucket(ctx context.Context, bucketName string, header http.Header) (Bucket, error) {
+	resp, err := c.do(ctx, request{method: http.MethodPut, bucketName: bucketName, header: header})
+	if err != nil {
+		return Bucket{}, err
+	}
+	var result bucketElement
+	err = decode(resp, &result)
+	if err != nil {
+		return Bucket{}, err
+	}
+	return result.bucket(), nil
+}
+
+// DeleteBucket deletes an empty bucket.
+func (c *Client) DeleteBucket(ctx context.Context, bucketName string) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, bucketName: bucketName})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// LoggingConfig names where server access logs of a bucket are delivered.
+type LoggingConfig struct {
+	TargetBucket string `xml:"TargetBucket"`
+	TargetPrefix string `xml:"TargetPrefix"`
+}
+
+type bucketLoggingStatus struct {
+	XMLName        xml.Name       `xml:"BucketLoggingStatus"`
+	LoggingEnabled *LoggingConfig `xml:"LoggingEnabled"`
+}
+
+// GetBucketLogging returns the access log delivery of a bucket, or nil if
+// it is disabled.
+func (c *Client) GetBucketLogging(ctx context.Context, bucketName string) (*LoggingConfig, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, query: url.Values{"logging": {""}}})
+	if err != nil {
+		return nil, err
+	}
+	var status bucketLoggingStatus
+	err = decode(resp, &status)
+	if err != nil {
+		return nil, err
+	}
+	return status.LoggingEnabled, nil
+}
+
+// PutBucketLogging enables delivery of the bucket's access logs into
+// another bucket, or disables it when cfg is nil.
+func (c *Client) PutBucketLogging(ctx context.Context, bucketName string, cfg *LoggingConfig) error {
+	body, err := xml.Marshal(bucketLoggingStatus{LoggingEnabled: cfg})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, request{
+		method:        http.MethodPut,
+		bucketName:    bucketName,
+		query:         url.Values{"logging": {""}},
+		header:        http.Header{"Content-Type": {"application/xml"}},
+		body:          bytes.NewReader(body),
+		contentLength: int64(len(body)),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type compressionConfiguration struct {
+	XMLName   xml.Name `xml:"CompressionConfiguration"`
+	Algorithm string   `xml:"Algorithm"`
+}
+
+// GetBucketCompression returns the algorithm new objects of a bucket are
+// stored compressed with, or "" if they are stored as they are.
+func (c *Client) GetBucketCompression(ctx context.Context, bucketName string) (string, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, query: url.Values{"compression": {""}}})
+	if err != nil {
+		return "", err
+	}
+	var cfg compressionConfiguration
+	err = decode(resp, &cfg)
+	if err != nil {
+		return "", err
+	}
+	return cfg.Algorithm, nil
+}
+
+// PutBucketCompression makes the server store new objects of a bucket
+// compressed with algorithm ("gzip"), or as they are when it is empty.
+// Objects are always sent and received uncompressed.
+func (c *Client) PutBucketCompression(ctx context.Context, bucketName string, algorithm string) error {
+	body, err := xml.Marshal(compressionConfiguration{Algorithm: algorithm})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, request{
+		method:        http.MethodPut,
+		bucketName:    bucketName,
+		query:         url.Values{"compression": {""}},
+		header:        http.Header{"Content-Type": {"application/xml"}},
+		body:          bytes.NewReader(body),
+		contentLength: int64(len(body)),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// BucketEncryption is the encryption policy of new objects of a bucket.
+type BucketEncryption struct {
+	// Default is the encryption of uploads which ask for none;
+	// EncryptionAES256 or empty.
+	Default string
+	// Require rejects uploads which are not encrypted.
+	Require bool
+}
+
+type encryptionByDefault struct {
+	SSEAlgorithm string `xml:"SSEAlgorithm"`
+}
+
+type serverSideEncryptionConfiguration struct {
+	XMLName xml.Name `xml:"ServerSideEncryptionConfiguration"`
+	Rule    struct {
+		ApplyServerSideEncryptionByDefault *encryptionByDefault `xml:"ApplyServerSideEncryptionByDefault"`
+		RequireEncryption                  bool                 `xml:"RequireEncryption,omitempty"`
+	} `xml:"Rule"`
+}
+
+// GetBucketEncryption returns the encryption policy of a bucket, or nil if
+// it has none.
+func (c *Client) GetBucketEncryption(ctx context.Context, bucketName string) (*BucketEncryption, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, query: url.Values{"encryption": {""}}})
+	if errors.Is(err, ErrNoEncryptionConfig) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc serverSideEncryptionConfiguration
+	err = decode(resp, &doc)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &BucketEncryption{Require: doc.Rule.RequireEncryption}
+	if doc.Rule.ApplyServerSideEncryptionByDefault != nil {
+		cfg.Default = doc.Rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm
+	}
+	return cfg, nil
+}
+
+// PutBucketEncryption sets the encryption policy of new objects of a
+// bucket.
+func (c *Client) PutBucketEncryption(ctx context.Context, bucketName string, cfg BucketEncryption) error {
+	var doc serverSideEncryptionConfiguration
+	if cfg.Default != "" {
+		doc.Rule.ApplyServerSideEncryptionByDefault = &encryptionByDefault{cfg.Default}
+	}
+	doc.Rule.RequireEncryption = cfg.Require
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, request{
+		method:        http.MethodPut,
+		bucketName:    bucketName,
+		query:         url.Values{"encryption": {""}},
+		header:        http.Header{"Content-Type": {"application/xml"}},
+		body:          bytes.NewReader(body),
+		contentLength: int64(len(body)),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeleteBucketEncryption removes the encryption policy of a bucket.
+func (c *Client) DeleteBucketEncryption(ctx context.Context, bucketName string) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, bucketName: bucketName, query: url.Values{"encryption": {""}}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,234 @@
+// Package client is a Go client for the triple-s HTTP API.
+//
+// A Client has a typed method for every server operation. Object content
+// is streamed in both directions; triple-s has no multipart upload API, so
+// an object of any size is uploaded with a single PUT. Requests failing
+// with a network error or a 5xx status are retried with exponential
+// backoff, and error responses are returned as *Error values comparable
+// with errors.Is against ErrBucketNotFound and friends.
+//
+// When credentials are given, requests are signed with AWS Signature
+// Version 4 and presigned URLs can be created for sharing objects.
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// HTTPClient sends the requests; nil means http.DefaultClient.
+	HTTPClient *http.Client
+	// AccessKey and SecretKey sign the requests. Requests are sent
+	// unsigned when they are empty.
+	AccessKey string
+	SecretKey string
+	// Region is part of the signature scope; empty means us-east-1.
+	Region string
+	// MaxRetries is the number of times a failed request is retried;
+	// negative disables retries and 0 means 3.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the wait before a retry, which
+	// doubles with every attempt; 0 means 100ms and 5s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// VirtualHostedStyle addresses buckets as <bucket>.<endpoint host>
+	// rather than in the path, for servers started with a base domain.
+	VirtualHostedStyle bool
+}
+
+// Client is safe for concurrent use by multiple goroutines.
+type Client struct {
+	endpoint      *url.URL
+	httpClient    *http.Client
+	accessKey     string
+	secretKey     string
+	region        string
+	maxRetries    int
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	virtualHosted bool
+}
+
+// New returns a client of the server at endpoint, e.g.
+// "http://localhost:8080".
+func New(endpoint string, opts Options) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("client: endpoint must be an http or https URL")
+	}
+	if (opts.AccessKey == "") != (opts.SecretKey == "") {
+		return nil, errors.New("client: both access key and secret key must be given")
+	}
+	c := &Client{
+		endpoint:      &url.URL{Scheme: u.Scheme, Host: u.Host},
+		httpClient:    opts.HTTPClient,
+		accessKey:     opts.AccessKey,
+		secretKey:     opts.SecretKey,
+		region:        opts.Region,
+		maxRetries:    opts.MaxRetries,
+		minBackoff:    opts.MinBackoff,
+		maxBackoff:    opts.MaxBackoff,
+		virtualHosted: opts.VirtualHostedStyle,
+	}
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = 3
+	}
+	if c.minBackoff <= 0 {
+		c.minBackoff = 100 * time.Millisecond
+	}
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = 5 * time.Second
+	}
+	return c, nil
+}
+
+// objectURL returns the URL of a bucket, or of an object when objectKey is
+// not empty.
+func (c *Client) objectURL(bucketName string, objectKey string, query url.Values) *url.URL {
+	u := *c.endpoint
+	path := bucketName
+	if c.virtualHosted && bucketName != "" {
+		u.Host = bucketName + "." + u.Host
+		path = ""
+	}
+	if objectKey != "" {
+		path = strings.TrimPrefix(path+"/"+objectKey, "/")
+	}
+	u.Path = "/" + path
+	u.RawPath = "/" + escape(path, true)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+type request struct {
+	method     string
+	bucketName string
+	objectKey  string
+	query      url.Values
+	header     http.Header
+	// body is sent with the request. It is rewound before a retry if it
+	// is an io.Seeker; other bodies are not retried.
+	body io.Reader
+	// contentLength is the length of body, or -1 if unknown.
+	contentLength int64
+}
+
+// retryable reports whether a request which failed with err or answered
+// with status may succeed when sent again.
+func retryable(ctx context.Context, err error, status int) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return status >= 500
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << attempt
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	// Full jitter spreads the retries of concurrent clients.
+	return rand.N(d) + 1
+}
+
+// do sends a request and returns the response if its status is 2xx. Other
+// responses are returned as *Error. The caller must close the body of the
+// returned response.
+func (c *Client) do(ctx context.Context, r request) (*http.Response, error) {
+	var start int64
+	seeker, canRewind := r.body.(io.Seeker)
+	if r.body == nil {
+		canRewind = true
+	} else if canRewind {
+		var err error
+		start, err = seeker.Seek(0, io.SeekCurrent)
+		canRewind = err == nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && r.body != nil {
+			_, err := seeker.Seek(start, io.SeekStart)
+			if err != nil {
+				return nil, err
+			}
+		}
+		resp, err := c.send(ctx, r)
+		status := 0
+		if err == nil {
+			if resp.StatusCode < 300 {
+				return resp, nil
+			}
+			status = resp.StatusCode
+			err = responseError(resp)
+			resp.Body.Close()
+		}
+		if attempt >= c.maxRetries || !canRewind || !retryable(ctx, err, status) {
+			return nil, err
+		}
+
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, r request) (*http.Response, error) {
+	var body io.Reader
+	if r.body != nil && r.contentLength == 0 {
+		body = http.NoBody
+	} else if r.body != nil {
+		// Keep the transport from closing a body which is rewound for a
+		// retry.
+		body = io.NopCloser(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, c.objectURL(r.bucketName, r.objectKey, r.query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+	if r.body != nil {
+		req.ContentLength = r.contentLength
+	}
+	if c.accessKey != "" {
+		c.sign(req, time.Now().UTC())
+	}
+	return c.httpClient.Do(req)
+}
+
+// Presign returns a URL which allows anyone holding it to send a request
+// with method to an object until expires has passed, e.g. to download it
+// with GET or upload it with PUT. It requires credentials.
+func (c *Client) Presign(method string, bucketName string, objectKey string, expires time.Duration) (string, error) {
+	if c.accessKey == "" {
+		return "", errors.New("client: presigning requires credentials")
+	}
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", errors.New("client: presigned URLs must expire within 7 days")
+	}
+	return c.presign(method, c.objectURL(bucketName, objectKey, nil), expires, time.Now().UTC()), nil
+}
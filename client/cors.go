@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// CORSRule allows cross-origin requests to a bucket from browsers. Origins
+// and headers may contain one '*' wildcard.
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	// AllowedMethods are GET, PUT, POST, DELETE and HEAD.
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader,omitempty"`
+	ExposeHeaders  []string `xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds  int      `xml:"MaxAgeSeconds,omitempty"`
+}
+
+type corsConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	Rules   []CORSRule `xml:"CORSRule"`
+}
+
+// GetBucketCORS returns the CORS rules of a bucket, or nil if it has none.
+func (c *Client) GetBucketCORS(ctx context.Context, bucketName string) ([]CORSRule, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, query: url.Values{"cors": {""}}})
+	if errors.Is(err, ErrNoCORSConfig) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc corsConfiguration
+	err = decode(resp, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Rules, nil
+}
+
+// PutBucketCORS replaces the CORS rules of a bucket.
+func (c *Client) PutBucketCORS(ctx context.Context, bucketName string, rules []CORSRule) error {
+	return c.putSubresource(ctx, bucketName, "", "cors", corsConfiguration{Rules: rules}, nil)
+}
+
+// DeleteBucketCORS removes the CORS rules of a bucket.
+func (c *Client) DeleteBucketCORS(ctx context.Context, bucketName string) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, bucketName: bucketName, query: url.Values{"cors": {""}}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error is an error answered by the server. Code is the error code of the
+// XML error document; errors.Is matches errors by code, so a returned error
+// can be compared against the Err* values below.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestId  string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Error codes answered by triple-s.
+var (
+	ErrBadRequest          = &Error{Code: "BadRequest"}
+	ErrInvalidBucketName   = &Error{Code: "BucketNameInvalid"}
+	ErrBucketExists        = &Error{Code: "BucketNameUnavailable"}
+	ErrBucketNotFound      = &Error{Code: "BucketNotFound"}
+	ErrBucketNotEmpty      = &Error{Code: "BucketNotEmpty"}
+	ErrInvalidObjectKey    = &Error{Code: "ObjectKeyInvalid"}
+	ErrMetadataAccess      = &Error{Code: "MetadataAccessDenied"}
+	ErrObjectNotFound      = &Error{Code: "ObjectNotFound"}
+	ErrEntityTooLarge      = &Error{Code: "EntityTooLarge"}
+	ErrInvalidArgument     = &Error{Code: "InvalidArgument"}
+	ErrMalformedXML        = &Error{Code: "MalformedXML"}
+	ErrInvalidLogTarget    = &Error{Code: "InvalidTargetBucketForLogging"}
+	ErrInvalidSnapshotName = &Error{Code: "SnapshotNameInvalid"}
+	ErrSnapshotExists      = &Error{Code: "SnapshotNameUnavailable"}
+	ErrSnapshotNotFound    = &Error{Code: "SnapshotNotFound"}
+	ErrMethodNotAllowed    = &Error{Code: "MethodNotAllowed"}
+
+	ErrEncryptionNotConfigured = &Error{Code: "EncryptionNotConfigured"}
+	ErrCustomerKeyRequired     = &Error{Code: "CustomerKeyRequired"}
+	ErrCustomerKeyMismatch     = &Error{Code: "CustomerKeyMismatch"}
+	ErrEncryptionRequired      = &Error{Code: "EncryptionRequired"}
+	ErrNoEncryptionConfig      = &Error{Code: "ServerSideEncryptionConfigurationNotFoundError"}
+
+	ErrObjectLocked       = &Error{Code: "ObjectLocked"}
+	ErrInvalidBucketState = &Error{Code: "InvalidBucketState"}
+	ErrNoObjectLockConfig = &Error{Code: "ObjectLockConfigurationNotFoundError"}
+	ErrNoRetention        = &Error{Code: "NoSuchObjectLockConfiguration"}
+
+	ErrQuotaExceeded = &Error{Code: "QuotaExceeded"}
+
+	ErrNoCORSConfig    = &Error{Code: "NoSuchCORSConfiguration"}
+	ErrNoWebsiteConfig = &Error{Code: "NoSuchWebsiteConfiguration"}
+
+	ErrInternalError      = &Error{Code: "InternalError"}
+	ErrServiceUnavailable = &Error{Code: "ServiceUnavailable"}
+	ErrNotFound           = &Error{Code: "NotFound"}
+	ErrUnexpectedResponse = &Error{Code: "UnexpectedResponse"}
+)
+
+type errorDocument struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// responseError decodes the XML error document of a failed response.
+// Responses without one, such as those to HEAD requests, get a code derived
+// from the status: a missing bucket or object is then reported as NotFound.
+func responseError(resp *http.Response) *Error {
+	e := &Error{StatusCode: resp.StatusCode, RequestId: resp.Header.Get("x-amz-request-id")}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var doc errorDocument
+	if xml.Unmarshal(body, &doc) == nil && doc.Code != "" {
+		e.Code = doc.Code
+		e.Message = strings.TrimSpace(doc.Message)
+		return e
+	}
+	e.Code = strings.ReplaceAll(http.StatusText(resp.StatusCode), " ", "")
+	if e.Code == "" {
+		e.Code = ErrUnexpectedResponse.Code
+	}
+	return e
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Retention modes of Object Lock.
+const (
+	LockGovernance = "GOVERNANCE"
+	LockCompliance = "COMPLIANCE"
+)
+
+// ObjectLock is the retention and legal hold of an object. An object with
+// either cannot be deleted or overwritten; governance-mode retention can
+// be bypassed, compliance-mode retention cannot until RetainUntil.
+type ObjectLock struct {
+	// Mode is LockGovernance or LockCompliance, or empty for no
+	// retention.
+	Mode        string
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+// header returns the request headers asking for lock.
+func (lock ObjectLock) header() http.Header {
+	header := make(http.Header)
+	if lock.Mode != "" {
+		header.Set("x-amz-object-lock-mode", lock.Mode)
+		header.Set("x-amz-object-lock-retain-until-date", lock.RetainUntil.UTC().Format(time.RFC3339))
+	}
+	if lock.LegalHold {
+		header.Set("x-amz-object-lock-legal-hold", "ON")
+	}
+	return header
+}
+
+// bypassGovernance returns the request header which bypasses
+// governance-mode retention.
+func bypassGovernance() http.Header {
+	return http.Header{"x-amz-bypass-governance-retention": {"true"}}
+}
+
+// CreateBucketWithObjectLock creates a bucket with Object Lock enabled,
+// which cannot be done for an existing bucket.
+func (c *Client) CreateBucketWithObjectLock(ctx context.Context, bucketName string) (Bucket, error) {
+	return c.createBucket(ctx, bucketName, http.Header{"x-amz-bucket-object-lock-enabled": {"true"}})
+}
+
+// DefaultRetention is the retention of new objects of a bucket with Object
+// Lock enabled which do not ask for one. It lasts Days or Years.
+type DefaultRetention struct {
+	Mode  string `xml:"Mode"`
+	Days  int    `xml:"Days,omitempty"`
+	Years int    `xml:"Years,omitempty"`
+}
+
+type objectLockRule struct {
+	DefaultRetention DefaultRetention `xml:"DefaultRetention"`
+}
+
+type objectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration"`
+	ObjectLockEnabled string          `xml:"ObjectLockEnabled"`
+	Rule              *objectLockRule `xml:"Rule"`
+}
+
+// GetObjectLockConfiguration returns the default retention of a bucket
+// with Object Lock enabled, or nil if it has none. It fails with
+// ErrNoObjectLockConfig if Object Lock is not enabled.
+func (c *Client) GetObjectLockConfiguration(ctx context.Context, bucketName string) (*DefaultRetention, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, query: url.Values{"object-lock": {""}}})
+	if err != nil {
+		return nil, err
+	}
+	var doc objectLockConfiguration
+	err = decode(resp, &doc)
+	if err != nil || doc.Rule == nil {
+		return nil, err
+	}
+	return &doc.Rule.DefaultRetention, nil
+}
+
+// PutObjectLockConfiguration sets the default retention of a bucket with
+// Object Lock enabled, or removes it when retention is nil.
+func (c *Client) PutObjectLockConfiguration(ctx context.Context, bucketName string, retention *DefaultRetention) error {
+	doc := objectLockConfiguration{ObjectLockEnabled: "Enabled"}
+	if retention != nil {
+		doc.Rule = &objectLockRule{*retention}
+	}
+	return c.putSubresource(ctx, bucketName, "", "object-lock", doc, nil)
+}
+
+// PutLockedObject uploads an object like PutObject with the given
+// retention and legal hold.
+func (c *Client) PutLockedObject(ctx context.Context, bucketName string, objectKey string, r io.Reader, size int64, contentType string, lock ObjectLock) error {
+	return c.putObject(ctx, bucketName, objectKey, r, size, contentType, lock.header())
+}
+
+// DeleteObjectBypassGovernance deletes an object under governance-mode
+// retention.
+func (c *Client) DeleteObjectBypassGovernance(ctx context.Context, bucketName string, objectKey string) error {
+	return c.deleteObject(ctx, bucketName, objectKey, bypassGovernance())
+}
+
+type retention struct {
+	XMLName         xml.Name `xml:"Retention"`
+	Mode            string   `xml:"Mode,omitempty"`
+	RetainUntilDate string   `xml:"RetainUntilDate,omitempty"`
+}
+
+// GetObjectRetention returns the retention mode and retain-until date of
+// an object. The mode is empty for an object without retention.
+func (c *Client) GetObjectRetention(ctx context.Context, bucketName string, objectKey string) (string, time.Time, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, objectKey: objectKey, query: url.Values{"retention": {""}}})
+	if errors.Is(err, ErrNoRetention) {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var doc retention
+	err = decode(resp, &doc)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	retainUntil, err := time.Parse(time.RFC3339, doc.RetainUntilDate)
+	if err != nil {
+		return "", time.Time{}, ErrUnexpectedResponse
+	}
+	return doc.Mode, retainUntil.Local(), nil
+}
+
+// PutObjectRetention sets the retention of an object, or removes it when
+// mode is empty. Shortening or removing governance-mode retention needs
+// bypass.
+func (c *Client) PutObjectRetention(ctx context.Context, bucketName string, objectKey string, mode string, retainUntil time.Time, bypass bool) error {
+	doc := retention{Mode: mode}
+	if mode != "" {
+		doc.RetainUntilDate = retainUntil.UTC().Format(time.RFC3339)
+	}
+	var header http.Header
+	if bypass {
+		header = bypassGovernance()
+	}
+	return c.putSubresource(ctx, bucketName, objectKey, "retention", doc, header)
+}
+
+type legalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string   `xml:"Status"`
+}
+
+// GetObjectLegalHold reports whether an object is under legal hold.
+func (c *Client) GetObjectLegalHold(ctx context.Context, bucketName string, objectKey string) (bool, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, objectKey: objectKey, query: url.Values{"legal-hold": {""}}})
+	if err != nil {
+		return false, err
+	}
+	var doc legalHold
+	err = decode(resp, &doc)
+	return doc.Status == "ON", err
+}
+
+// PutObjectLegalHold places or lifts a legal hold on an object.
+func (c *Client) PutObjectLegalHold(ctx context.Context, bucketName string, objectKey string, on bool) error {
+	doc := legalHold{Status: "OFF"}
+	if on {
+		doc.Status = "ON"
+	}
+	return c.putSubresource(ctx, bucketName, objectKey, "legal-hold", doc, nil)
+}
+
+// putSubresource sends doc as the XML body of a PUT request for a
+// subresource of a bucket or object.
+func (c *Client) putSubresource(ctx context.Context, bucketName string, objectKey string, subresource string, doc any, header http.Header) error {
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Type", "application/xml")
+	resp, err := c.do(ctx, request{
+		method:        http.MethodPut,
+		bucketName:    bucketName,
+		objectKey:     objectKey,
+		query:         url.Values{subresource: {""}},
+		header:        header,
+		body:          bytes.NewReader(body),
+		contentLength: int64(len(body)),
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,336 @@
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	// ETag is the hex-encoded MD5 digest of the content. It is empty for
+	// objects stored before the server recorded digests.
+	ETag string
+	// Encryption is EncryptionAES256 or EncryptionCustomer for objects
+	// encrypted at rest. Listings leave it empty.
+	Encryption string
+	// Lock is the retention and legal hold of the object. Listings leave
+	// it empty.
+	Lock ObjectLock
+}
+
+// Server-side encryption modes of objects.
+const (
+	EncryptionAES256   = "AES256"
+	EncryptionCustomer = "SSE-C"
+)
+
+// Encryption asks the server to encrypt an object at rest. The zero value
+// leaves it to the bucket's configuration.
+type Encryption struct {
+	// AES256 encrypts the object with the server's master key (SSE-S3).
+	AES256 bool
+	// CustomerKey encrypts the object with a 256-bit key the server does
+	// not keep (SSE-C). The key must be given again to read the object.
+	CustomerKey []byte
+}
+
+// header returns the request headers asking for enc.
+func (enc Encryption) header() http.Header {
+	header := make(http.Header)
+	if enc.AES256 {
+		header.Set("x-amz-server-side-encryption", EncryptionAES256)
+	}
+	if enc.CustomerKey != nil {
+		digest := md5.Sum(enc.CustomerKey)
+		header.Set("x-amz-server-side-encryption-customer-algorithm", EncryptionAES256)
+		header.Set("x-amz-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString(enc.CustomerKey))
+		header.Set("x-amz-server-side-encryption-customer-key-MD5", base64.StdEncoding.EncodeToString(digest[:]))
+	}
+	return header
+}
+
+func objectFromHeader(objectKey string, header http.Header) Object {
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	obj := Object{
+		Key:         objectKey,
+		Size:        size,
+		ContentType: header.Get("Content-Type"),
+		ETag:        strings.Trim(header.Get("ETag"), "\""),
+	}
+	switch {
+	case header.Get("x-amz-server-side-encryption") != "":
+		obj.Encryption = EncryptionAES256
+	case header.Get("x-amz-server-side-encryption-customer-algorithm") != "":
+		obj.Encryption = EncryptionCustomer
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err == nil {
+		obj.LastModified = lastModified.Local()
+	}
+	obj.Lock.Mode = header.Get("x-amz-object-lock-mode")
+	retainUntil, err := time.Parse(time.RFC3339, header.Get("x-amz-object-lock-retain-until-date"))
+	if err == nil {
+		obj.Lock.RetainUntil = retainUntil.Local()
+	}
+	obj.Lock.LegalHold = header.Get("x-amz-object-lock-legal-hold") == "ON"
+	return obj
+}
+
+// PutObject uploads the content read from r as objectKey, replacing any
+// existing object with that key. size is the length of the content, or -1
+// to stream content of unknown length. An empty contentType makes the
+// server use text/plain. The upload is retried on failure only if r is an
+// io.Seeker.
+func (c *Client) PutObject(ctx context.Context, bucketName string, objectKey string, r io.Reader, size int64, contentType string) error {
+	return c.PutEncryptedObject(ctx, bucketName, objectKey, r, size, contentType, Encryption{})
+}
+
+// PutEncryptedObject uploads an object like PutObject and asks the server
+// to encrypt it at rest as enc says.
+func (c *Client) PutEncryptedObject(ctx context.Context, bucketName string, objectKey string, r io.Reader, size int64, contentType string, enc Encryption) error {
+	return c.putObject(ctx, bucketName, objectKey, r, size, contentType, enc.header())
+}
+
+func (c *Client) putObject(ctx context.Context, bucketName string, objectKey string, r io.Reader, size int64, contentType string, header http.Header) error {
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if r == nil {
+		r, size = http.NoBody, 0
+	}
+	resp, err := c.do(ctx, request{
+		method:        http.MethodPut,
+		bucketName:    bucketName,
+		objectKey:     objectKey,
+		header:        header,
+		body:          r,
+		contentLength: size,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// identityEncoding asks for object content as it is. The HTTP transport
+// would otherwise accept gzip, and decompressing an object the server
+// stores compressed would hide its size.
+func identityEncoding() http.Header {
+	return http.Header{"Accept-Encoding": {"identity"}}
+}
+
+// GetObject returns the object and its content. The caller must close the
+// returned reader.
+func (c *Client) GetObject(ctx context.Context, bucketName string, objectKey string) (Object, io.ReadCloser, error) {
+	return c.GetEncryptedObject(ctx, bucketName, objectKey, nil)
+}
+
+// GetEncryptedObject reads an object encrypted with a customer-provided
+// key like GetObject.
+func (c *Client) GetEncryptedObject(ctx context.Context, bucketName string, objectKey string, customerKey []byte) (Object, io.ReadCloser, error) {
+	header := Encryption{CustomerKey: customerKey}.header()
+	header.Set("Accept-Encoding", "identity")
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, objectKey: objectKey, header: header})
+	if err != nil {
+		return Object{}, nil, err
+	}
+	return objectFromHeader(objectKey, resp.Header), resp.Body, nil
+}
+
+// HeadObject returns the metadata of an object. Answers to HEAD requests
+// have no error document, so a missing bucket or object is reported as
+// ErrNotFound.
+func (c *Client) HeadObject(ctx context.Context, bucketName string, objectKey string) (Object, error) {
+	resp, err := c.do(ctx, request{method: http.MethodHead, bucketName: bucketName, objectKey: objectKey})
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	return objectFromHeader(objectKey, resp.Header), nil
+}
+
+func (c *Client) DeleteObject(ctx context.Context, bucketName string, objectKey string) error {
+	return c.deleteObject(ctx, bucketName, objectKey, nil)
+}
+
+func (c *Client) deleteObject(ctx context.Context, bucketName string, objectKey string, header http.Header) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, bucketName: bucketName, objectKey: objectKey, header: header})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type ListOptions struct {
+	// Prefix limits the result to keys that begin with it.
+	Prefix string
+	// Delimiter groups keys that contain it after the prefix into
+	// CommonPrefixes instead of listing them.
+	Delimiter string
+	// StartAfter lists only keys that sort after it.
+	StartAfter string
+	// MaxKeys limits the number of keys and common prefixes of a page;
+	// 0 means the server default of 1000.
+	MaxKeys int
+	// ContinuationToken continues a truncated listing.
+	ContinuationToken string
+	// Snapshot lists the objects of the named snapshot of the bucket.
+	Snapshot string
+}
+
+type ListResult struct {
+	Objects        []Object
+	CommonPrefixes []string
+	// IsTruncated is set when more keys follow; the listing continues
+	// with ContinuationToken set to NextContinuationToken.
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string `xml:"Key"`
+		Size         int64  `xml:"Size"`
+		ContentType  string `xml:"ContentType"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+	} `xml:"Contents"`
+	CommonPrefixes []string `xml:"CommonPrefixes>Prefix"`
+}
+
+// ListObjects returns one page of the objects of a bucket sorted by key.
+// Objects iterates over all pages.
+func (c *Client) ListObjects(ctx context.Context, bucketName string, opts ListOptions) (ListResult, error) {
+	query := url.Values{"list-type": {"2"}}
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.Delimiter != "" {
+		query.Set("delimiter", opts.Delimiter)
+	}
+	if opts.StartAfter != "" {
+		query.Set("start-after", opts.StartAfter)
+	}
+	if opts.MaxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(opts.MaxKeys))
+	}
+	if opts.ContinuationToken != "" {
+		query.Set("continuation-token", opts.ContinuationToken)
+	}
+	if opts.Snapshot != "" {
+		query.Set("snapshot", opts.Snapshot)
+	}
+
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, query: query})
+	if err != nil {
+		return ListResult{}, err
+	}
+	var page listBucketResult
+	err = decode(resp, &page)
+	if err != nil {
+		return ListResult{}, err
+	}
+	result := ListResult{
+		CommonPrefixes:        page.CommonPrefixes,
+		IsTruncated:           page.IsTruncated,
+		NextContinuationToken: page.NextContinuationToken,
+	}
+	for _, content := range page.Contents {
+		result.Objects = append(result.Objects, Object{
+			Key:          content.Key,
+			Size:         content.Size,
+			ContentType:  content.ContentType,
+			LastModified: parseTime(content.LastModified),
+			ETag:         strings.Trim(content.ETag, "\""),
+		})
+	}
+	return result, nil
+}
+
+// ObjectIterator walks a listing page by page. Use it like a
+// bufio.Scanner:
+//
+//	it := c.Objects(ctx, "bucket", client.ListOptions{Prefix: "logs/"})
+//	for it.Next() {
+//		fmt.Println(it.Object().Key)
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ObjectIterator struct {
+	ctx        context.Context
+	client     *Client
+	bucketName string
+	opts       ListOptions
+	page       ListResult
+	index      int
+	prefix     string
+	object     Object
+	started    bool
+	err        error
+}
+
+// Objects returns an iterator over all objects and common prefixes of a
+// listing, fetching further pages as needed.
+func (c *Client) Objects(ctx context.Context, bucketName string, opts ListOptions) *ObjectIterator {
+	return &ObjectIterator{ctx: ctx, client: c, bucketName: bucketName, opts: opts}
+}
+
+// Next advances to the next object or common prefix. It returns false at
+// the end of the listing or when a page could not be fetched.
+func (it *ObjectIterator) Next() bool {
+	for it.err == nil {
+		entries := len(it.page.Objects) + len(it.page.CommonPrefixes)
+		if it.index < entries {
+			it.object, it.prefix = Object{}, ""
+			if it.index < len(it.page.Objects) {
+				it.object = it.page.Objects[it.index]
+			} else {
+				it.prefix = it.page.CommonPrefixes[it.index-len(it.page.Objects)]
+			}
+			it.index++
+			return true
+		}
+		if it.started && !it.page.IsTruncated {
+			return false
+		}
+		if it.started {
+			it.opts.ContinuationToken = it.page.NextContinuationToken
+		}
+		it.started = true
+		it.page, it.err = it.client.ListObjects(it.ctx, it.bucketName, it.opts)
+		it.index = 0
+	}
+	return false
+}
+
+// Object returns the current object. Its key is empty when the current
+// entry is a common prefix.
+func (it *ObjectIterator) Object() Object {
+	return it.object
+}
+
+// CommonPrefix returns the current common prefix, or "" when the current
+// entry is an object.
+func (it *ObjectIterator) CommonPrefix() string {
+	return it.prefix
+}
+
+// Err returns the error which ended the iteration, if any.
+func (it *ObjectIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// PostPolicy restricts a browser-based upload with an HTML form.
+type PostPolicy struct {
+	// Key is the key of the uploaded object, in which ${filename} stands
+	// for the name of the uploaded file. With KeyPrefix set instead, the
+	// form may choose any key starting with it.
+	Key       string
+	KeyPrefix string
+	// ContentType is the content type the form must give; empty leaves
+	// it to the form.
+	ContentType string
+	// MinSize and MaxSize bound the size of the file; a zero MaxSize is
+	// no limit.
+	MinSize int64
+	MaxSize int64
+	// SuccessActionRedirect is where the browser is sent after the
+	// upload; empty answers with SuccessActionStatus instead, which is
+	// 200, 201 or, when empty, 204.
+	SuccessActionRedirect string
+	SuccessActionStatus   string
+}
+
+// PresignPost returns the URL and the form fields of an HTML form which
+// allows anyone holding it to upload a file to a bucket as policy permits
+// until expires has passed. The file must be the last field of the form.
+// It requires credentials.
+func (c *Client) PresignPost(bucketName string, policy PostPolicy, expires time.Duration) (string, map[string]string, error) {
+	if c.accessKey == "" {
+		return "", nil, errors.New("client: presigning requires credentials")
+	}
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", nil, errors.New("client: presigned forms must expire within 7 days")
+	}
+	if (policy.Key == "") == (policy.KeyPrefix == "") {
+		return "", nil, errors.New("client: a post policy needs either a key or a key prefix")
+	}
+
+	now := time.Now().UTC()
+	fields := map[string]string{
+		"x-amz-algorithm":  signingAlgorithm,
+		"x-amz-credential": c.accessKey + "/" + c.scope(now),
+		"x-amz-date":       now.Format(amzDateLayout),
+	}
+	conditions := []any{map[string]string{"bucket": bucketName}}
+	if policy.Key != "" {
+		fields["key"] = policy.Key
+	} else {
+		fields["key"] = policy.KeyPrefix + "${filename}"
+		conditions = append(conditions, []string{"starts-with", "$key", policy.KeyPrefix})
+	}
+	if policy.ContentType != "" {
+		fields["Content-Type"] = policy.ContentType
+	}
+	if policy.SuccessActionRedirect != "" {
+		fields["success_action_redirect"] = policy.SuccessActionRedirect
+	}
+	if policy.SuccessActionStatus != "" {
+		fields["success_action_status"] = policy.SuccessActionStatus
+	}
+	for name, value := range fields {
+		if name != "key" || policy.Key != "" {
+			conditions = append(conditions, map[string]string{name: value})
+		}
+	}
+	if policy.MinSize > 0 || policy.MaxSize > 0 {
+		maxSize := policy.MaxSize
+		if maxSize == 0 {
+			maxSize = 1<<63 - 1
+		}
+		conditions = append(conditions, []any{"content-length-range", policy.MinSize, maxSize})
+	}
+	document, err := json.Marshal(map[string]any{
+		"expiration": now.Add(expires).Format(time.RFC3339),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	fields["policy"] = base64.StdEncoding.EncodeToString(document)
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(c.signingKey(now), fields["policy"]))
+	return c.objectURL(bucketName, "", nil).String(), fields, nil
+}
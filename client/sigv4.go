@@ -0,0 +1,133 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateLayout    = "20060102T150405Z"
+)
+
+// escape percent-encodes s as AWS Signature Version 4 requires: everything
+// but unreserved characters is encoded, and so is '/' unless keepSlash is
+// set.
+func escape(s string, keepSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			sb.WriteByte(c)
+		default:
+			sb.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return sb.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, escape(key, false)+"="+escape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Client) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + c.region + "/s3/aws4_request"
+}
+
+// signature returns the signature of a canonical request made at now.
+func (c *Client) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := signingAlgorithm + "\n" + now.Format(amzDateLayout) + "\n" + c.scope(now) + "\n" + sha256Hex(canonicalRequest)
+	return hex.EncodeToString(hmacSHA256(c.signingKey(now), stringToSign))
+}
+
+// signingKey derives the key signing requests made at now.
+func (c *Client) signingKey(now time.Time) []byte {
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req. The
+// payload is not signed, so bodies can be streamed.
+func (c *Client) sign(req *http.Request, now time.Time) {
+	req.Header.Set("X-Amz-Date", now.Format(amzDateLayout))
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	names := []string{"host"}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := req.Method + "\n" +
+		req.URL.EscapedPath() + "\n" +
+		canonicalQuery(req.URL.Query()) + "\n" +
+		headers.String() + "\n" +
+		signedHeaders + "\n" +
+		unsignedPayload
+	req.Header.Set("Authorization", signingAlgorithm+
+		" Credential="+c.accessKey+"/"+c.scope(now)+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+c.signature(now, canonicalRequest))
+}
+
+// presign returns u with AWS Signature Version 4 query authentication
+// valid for expires.
+func (c *Client) presign(method string, u *url.URL, expires time.Duration, now time.Time) string {
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", signingAlgorithm)
+	query.Set("X-Amz-Credential", c.accessKey+"/"+c.scope(now))
+	query.Set("X-Amz-Date", now.Format(amzDateLayout))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := method + "\n" +
+		u.EscapedPath() + "\n" +
+		canonicalQuery(query) + "\n" +
+		"host:" + u.Host + "\n\n" +
+		"host\n" +
+		unsignedPayload
+	u.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + c.signature(now, canonicalRequest)
+	return u.String()
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Snapshot is a read-only copy of the objects of a bucket.
+type Snapshot struct {
+	Name         string
+	CreationTime time.Time
+}
+
+type snapshotElement struct {
+	Name         string `xml:"Name"`
+	CreationTime string `xml:"CreationTime"`
+}
+
+func (s snapshotElement) snapshot() Snapshot {
+	return Snapshot{Name: s.Name, CreationTime: parseTime(s.CreationTime)}
+}
+
+// CreateSnapshot creates a snapshot of the current objects of a bucket. An
+// empty snapshotName lets the server name it after the current time.
+func (c *Client) CreateSnapshot(ctx context.Context, bucketName string, snapshotName string) (Snapshot, error) {
+	resp, err := c.do(ctx, request{method: http.MethodPost, bucketName: bucketName, query: url.Values{"snapshot": {snapshotName}}})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	var result snapshotElement
+	err = decode(resp, &result)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return result.snapshot(), nil
+}
+
+// ListSnapshots returns the snapshots of a bucket in the order they were
+// created.
+func (c *Client) ListSnapshots(ctx context.Context, bucketName string) ([]Snapshot, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, query: url.Values{"snapshots": {""}}})
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Snapshots []snapshotElement `xml:"Snapshot"`
+	}
+	err = decode(resp, &result)
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]Snapshot, 0, len(result.Snapshots))
+	for _, s := range result.Snapshots {
+		snaps = append(snaps, s.snapshot())
+	}
+	return snaps, nil
+}
+
+func (c *Client) DeleteSnapshot(ctx context.Context, bucketName string, snapshotName string) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, bucketName: bucketName, query: url.Values{"snapshot": {snapshotName}}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RollbackBucket replaces the objects of a bucket with those of a
+// snapshot. Objects created after the snapshot are deleted.
+func (c *Client) RollbackBucket(ctx context.Context, bucketName string, snapshotName string) error {
+	resp, err := c.do(ctx, request{method: http.MethodPost, bucketName: bucketName, query: url.Values{"rollback": {snapshotName}}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetSnapshotObject returns an object of a snapshot and its content. The
+// caller must close the returned reader.
+func (c *Client) GetSnapshotObject(ctx context.Context, bucketName string, snapshotName string, objectKey string) (Object, io.ReadCloser, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, objectKey: objectKey, query: url.Values{"snapshot": {snapshotName}}, header: identityEncoding()})
+	if err != nil {
+		return Object{}, nil, err
+	}
+	return objectFromHeader(objectKey, resp.Header), resp.Body, nil
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// Redirect is where a website request is redirected. Empty fields keep the
+// protocol, host and key of the request.
+type Redirect struct {
+	Protocol             string  `xml:"Protocol,omitempty"`
+	HostName             string  `xml:"HostName,omitempty"`
+	ReplaceKeyPrefixWith *string `xml:"ReplaceKeyPrefixWith"`
+	ReplaceKeyWith       string  `xml:"ReplaceKeyWith,omitempty"`
+	HttpRedirectCode     int     `xml:"HttpRedirectCode,omitempty"`
+}
+
+// RoutingRuleCondition selects the requests a routing rule redirects.
+type RoutingRuleCondition struct {
+	KeyPrefixEquals             string `xml:"KeyPrefixEquals,omitempty"`
+	HttpErrorCodeReturnedEquals int    `xml:"HttpErrorCodeReturnedEquals,omitempty"`
+}
+
+// RoutingRule redirects the website requests matching its condition, or
+// all of them without one.
+type RoutingRule struct {
+	Condition *RoutingRuleCondition `xml:"Condition"`
+	Redirect  Redirect              `xml:"Redirect"`
+}
+
+// WebsiteConfig serves a bucket as a static website. Either
+// RedirectAllRequestsTo is set, or IndexDocument is, optionally with the
+// other fields.
+type WebsiteConfig struct {
+	IndexDocument         string
+	ErrorDocument         string
+	RedirectAllRequestsTo *Redirect
+	RoutingRules          []RoutingRule
+}
+
+type websiteDocument struct {
+	Suffix string `xml:"Suffix,omitempty"`
+	Key    string `xml:"Key,omitempty"`
+}
+
+type websiteConfiguration struct {
+	XMLName               xml.Name         `xml:"WebsiteConfiguration"`
+	IndexDocument         *websiteDocument `xml:"IndexDocument"`
+	ErrorDocument         *websiteDocument `xml:"ErrorDocument"`
+	RedirectAllRequestsTo *Redirect        `xml:"RedirectAllRequestsTo"`
+	RoutingRules          []RoutingRule    `xml:"RoutingRules>RoutingRule"`
+}
+
+// GetBucketWebsite returns the website configuration of a bucket, or nil
+// if it is not served as a website.
+func (c *Client) GetBucketWebsite(ctx context.Context, bucketName string) (*WebsiteConfig, error) {
+	resp, err := c.do(ctx, request{method: http.MethodGet, bucketName: bucketName, query: url.Values{"website": {""}}})
+	if errors.Is(err, ErrNoWebsiteConfig) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc websiteConfiguration
+	err = decode(resp, &doc)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &WebsiteConfig{RedirectAllRequestsTo: doc.RedirectAllRequestsTo, RoutingRules: doc.RoutingRules}
+	if doc.IndexDocument != nil {
+		cfg.IndexDocument = doc.IndexDocument.Suffix
+	}
+	if doc.ErrorDocument != nil {
+		cfg.ErrorDocument = doc.ErrorDocument.Key
+	}
+	return cfg, nil
+}
+
+// PutBucketWebsite serves a bucket as a static website.
+func (c *Client) PutBucketWebsite(ctx context.Context, bucketName string, cfg WebsiteConfig) error {
+	doc := websiteConfiguration{RedirectAllRequestsTo: cfg.RedirectAllRequestsTo, RoutingRules: cfg.RoutingRules}
+	if cfg.IndexDocument != "" {
+		doc.IndexDocument = &websiteDocument{Suffix: cfg.IndexDocument}
+	}
+	if cfg.ErrorDocument != "" {
+		doc.ErrorDocument = &websiteDocument{Key: cfg.ErrorDocument}
+	}
+	return c.putSubresource(ctx, bucketName, "", "website", doc, nil)
+}
+
+// DeleteBucketWebsite stops serving a bucket as a website.
+func (c *Client) DeleteBucketWebsite(ctx context.Context, bucketName string) error {
+	resp, err := c.do(ctx, request{method: http.MethodDelete, bucketName: bucketName, query: url.Values{"website": {""}}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,302 @@
+// Command triples is a command-line client of the triple-s HTTP API.
+//
+// Objects are addressed as s3://BUCKET/KEY; anything else given to cp and
+// sync is a local path.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"triple-s/client"
+)
+
+const usage = `Usage: triples [--endpoint URL] <command> [arguments]
+
+Commands:
+  mb s3://BUCKET                  Create a bucket
+  rb [--force] s3://BUCKET        Delete a bucket; --force deletes its objects first
+  ls [-r] [s3://BUCKET[/PREFIX]]  List the buckets, or the objects under a prefix
+  cp [-r] [-j N] SRC DST          Copy files and objects in either direction
+  rm [-r] s3://BUCKET/KEY         Delete an object; -r deletes every key with the prefix
+  cat s3://BUCKET/KEY             Write an object to the standard output
+  stat s3://BUCKET/KEY            Show the metadata of an object
+  sync [-j N] [--delete] SRC DST  Copy only new and changed files or objects
+
+Environment:
+  TRIPLES_ENDPOINT                     Server URL, default http://localhost:8080
+  TRIPLES_ACCESS_KEY, TRIPLES_SECRET_KEY  Credentials signing the requests
+`
+
+// errUsage reports wrong command-line arguments; the usage has been
+// printed already.
+var errUsage = errors.New("invalid arguments")
+
+type command func(ctx context.Context, c *client.Client, args []string) error
+
+var commands = map[string]command{
+	"mb":   makeBucket,
+	"rb":   removeBucket,
+	"ls":   list,
+	"cp":   copyCommand,
+	"rm":   remove,
+	"cat":  cat,
+	"stat": stat,
+	"sync": syncCommand,
+}
+
+func main() {
+	endpoint := os.Getenv("TRIPLES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://localhost:8080"
+	}
+	flag.StringVar(&endpoint, "endpoint", endpoint, "Server URL")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+	c, err := client.New(endpoint, client.Options{
+		AccessKey: os.Getenv("TRIPLES_ACCESS_KEY"),
+		SecretKey: os.Getenv("TRIPLES_SECRET_KEY"),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "triples:", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = cmd(ctx, c, flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "triples:", err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns the flags of a command taking the arguments
+// described by synopsis.
+func newFlagSet(name string, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: triples "+name+" "+synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the flags of a command and checks that n arguments
+// follow them.
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// isRemote reports whether arg addresses a bucket or object.
+func isRemote(arg string) bool {
+	return strings.HasPrefix(arg, "s3://")
+}
+
+// parseRemote splits s3://BUCKET/KEY into the bucket name and key.
+func parseRemote(arg string) (string, string, error) {
+	rest, ok := strings.CutPrefix(arg, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("%q is not of the form s3://BUCKET/KEY", arg)
+	}
+	bucketName, key, _ := strings.Cut(rest, "/")
+	if bucketName == "" {
+		return "", "", fmt.Errorf("%q names no bucket", arg)
+	}
+	return bucketName, key, nil
+}
+
+const displayTimeLayout = "2006-01-02 15:04:05"
+
+func makeBucket(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("mb", "s3://BUCKET")
+	err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	bucketName, _, err := parseRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = c.CreateBucket(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	fmt.Println("make_bucket: " + bucketName)
+	return nil
+}
+
+func removeBucket(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("rb", "[--force] s3://BUCKET")
+	force := fs.Bool("force", false, "Delete the objects of the bucket first")
+	err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	bucketName, _, err := parseRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *force {
+		err = removePrefix(ctx, c, bucketName, "", defaultWorkers)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.DeleteBucket(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	fmt.Println("remove_bucket: " + bucketName)
+	return nil
+}
+
+func list(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("ls", "[-r] [s3://BUCKET[/PREFIX]]")
+	recursive := fs.Bool("r", false, "List all keys under the prefix instead of grouping them by '/'")
+	err := fs.Parse(args)
+	if err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	if fs.NArg() == 0 {
+		bkts, err := c.ListBuckets(ctx)
+		if err != nil {
+			return err
+		}
+		for _, bkt := range bkts {
+			fmt.Println(bkt.CreationTime.Format(displayTimeLayout) + " " + bkt.Name)
+		}
+		return nil
+	}
+
+	bucketName, prefix, err := parseRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	opts := client.ListOptions{Prefix: prefix}
+	if !*recursive {
+		opts.Delimiter = "/"
+	}
+	it := c.Objects(ctx, bucketName, opts)
+	for it.Next() {
+		if p := it.CommonPrefix(); p != "" {
+			fmt.Printf("%19s %10s %s\n", "", "PRE", p)
+			continue
+		}
+		obj := it.Object()
+		fmt.Printf("%s %10d %s\n", obj.LastModified.Format(displayTimeLayout), obj.Size, obj.Key)
+	}
+	return it.Err()
+}
+
+func remove(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("rm", "[-r] [-j N] s3://BUCKET/KEY")
+	recursive := fs.Bool("r", false, "Delete every object whose key begins with KEY")
+	workers := fs.Int("j", defaultWorkers, "Number of parallel requests")
+	err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	bucketName, key, err := parseRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *recursive {
+		return removePrefix(ctx, c, bucketName, key, *workers)
+	}
+	err = c.DeleteObject(ctx, bucketName, key)
+	if err != nil {
+		return err
+	}
+	fmt.Println("delete: " + fs.Arg(0))
+	return nil
+}
+
+// removePrefix deletes every object whose key begins with prefix.
+func removePrefix(ctx context.Context, c *client.Client, bucketName string, prefix string, workers int) error {
+	var keys []string
+	it := c.Objects(ctx, bucketName, client.ListOptions{Prefix: prefix})
+	for it.Next() {
+		keys = append(keys, it.Object().Key)
+	}
+	if it.Err() != nil {
+		return it.Err()
+	}
+	return parallel(len(keys), workers, func(i int) error {
+		err := c.DeleteObject(ctx, bucketName, keys[i])
+		if err == nil {
+			fmt.Println("delete: s3://" + bucketName + "/" + keys[i])
+		}
+		return err
+	})
+}
+
+func cat(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("cat", "s3://BUCKET/KEY")
+	err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	bucketName, key, err := parseRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, content, err := c.GetObject(ctx, bucketName, key)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	_, err = io.Copy(os.Stdout, content)
+	return err
+}
+
+func stat(ctx context.Context, c *client.Client, args []string) error {
+	fs := newFlagSet("stat", "s3://BUCKET/KEY")
+	err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	bucketName, key, err := parseRemote(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	obj, err := c.HeadObject(ctx, bucketName, key)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Key:          " + obj.Key)
+	fmt.Println("Size:         " + strconv.FormatInt(obj.Size, 10))
+	fmt.Println("ContentType:  " + obj.ContentType)
+	fmt.Println("ETag:         " + obj.ETag)
+	fmt.Println("LastModified: " + obj.LastModified.Format(displayTimeLayout))
+	return nil
+}
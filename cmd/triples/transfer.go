@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"triple-s/client"
+)
+
+const defaultWorkers = 4
+
+// location is a local path or, if bucketName is set, an object key.
+type location struct {
+	bucketName string
+	key        string
+	path       string
+}
+
+func parseLocation(arg string) (location, error) {
+	if !isRemote(arg) {
+		return location{path: arg}, nil
+	}
+	bucketName, key, err := parseRemote(arg)
+	return location{bucketName: bucketName, key: key}, err
+}
+
+func (l location) remote() bool {
+	return l.bucketName != ""
+}
+
+func (l location) String() string {
+	if l.remote() {
+		return "s3://" + l.bucketName + "/" + l.key
+	}
+	return l.path
+}
+
+// isDir reports whether l names a directory or a key prefix rather than a
+// single file or object.
+func (l location) isDir() bool {
+	if l.remote() {
+		return l.key == "" || strings.HasSuffix(l.key, "/")
+	}
+	info, err := os.Stat(l.path)
+	return strings.HasSuffix(l.path, string(filepath.Separator)) || (err == nil && info.IsDir())
+}
+
+// child returns the location of the file or object at the slash-separated
+// relative path rel below l.
+func (l location) child(rel string) location {
+	if l.remote() {
+		prefix := l.key
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return location{bucketName: l.bucketName, key: prefix + rel}
+	}
+	return location{path: filepath.Join(l.path, filepath.FromSlash(rel))}
+}
+
+// entry is a file or object found below a location.
+type entry struct {
+	loc  location
+	size int64
+	// etag is the MD5 digest of an object, or "" if unknown.
+	etag string
+}
+
+// listTree returns the files below a directory or the objects below a key
+// prefix, indexed by their slash-separated relative path.
+func listTree(ctx context.Context, c *client.Client, root location) (map[string]entry, error) {
+	entries := make(map[string]entry)
+	if root.remote() {
+		prefix := root.key
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		it := c.Objects(ctx, root.bucketName, client.ListOptions{Prefix: prefix})
+		for it.Next() {
+			obj := it.Object()
+			rel := strings.TrimPrefix(obj.Key, prefix)
+			entries[rel] = entry{loc: root.child(rel), size: obj.Size, etag: obj.ETag}
+		}
+		return entries, it.Err()
+	}
+
+	err := filepath.WalkDir(root.path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root.path, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		entries[rel] = entry{loc: root.child(rel), size: info.Size()}
+		return nil
+	})
+	return entries, err
+}
+
+// digest returns the MD5 digest of an entry, reading local files.
+func digest(e entry) (string, error) {
+	if e.loc.remote() {
+		return e.etag, nil
+	}
+	file, err := os.Open(e.loc.path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := md5.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// changed reports whether dst differs from src. Sizes are compared first;
+// equal sizes are compared by digest, and an unknown digest counts as a
+// change.
+func changed(src entry, dst entry) (bool, error) {
+	if src.size != dst.size {
+		return true, nil
+	}
+	srcDigest, err := digest(src)
+	if err != nil {
+		return false, err
+	}
+	dstDigest, err := digest(dst)
+	if err != nil {
+		return false, err
+	}
+	return srcDigest == "" || srcDigest != dstDigest, nil
+}
+
+// parallel runs fn for 0..n-1 on up to workers goroutines. Failures are
+// printed; the returned error counts them.
+func parallel(n int, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var mu sync.Mutex
+	failures := 0
+	var wg sync.WaitGroup
+	for range min(workers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				err := fn(i)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "triples:", err)
+					mu.Lock()
+					failures++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	if failures > 0 {
+		return fmt.Errorf("%d of %d operations failed", failures, n)
+	}
+	return nil
+}
+
+// copyFile copies one file or object to another location.
+func copyFile(ctx context.Context, c *client.Client, src location, dst location) error {
+	var content io.ReadCloser
+	var size int64
+	var contentType string
+	if src.remote() {
+		obj, body, err := c.GetObject(ctx, src.bucketName, src.key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+		content, size, contentType = body, obj.Size, obj.ContentType
+	} else {
+		file, err := os.Open(src.path)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		content, size = file, info.Size()
+		if !info.Mode().IsRegular() {
+			// Pipes and devices report no useful size.
+			size = -1
+		}
+		contentType = mime.TypeByExtension(filepath.Ext(src.path))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+	defer content.Close()
+
+	if dst.remote() {
+		err := c.PutObject(ctx, dst.bucketName, dst.key, content, size, contentType)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dst, err)
+		}
+	} else {
+		err := writeFile(dst.path, content)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("copy: " + src.String() + " -> " + dst.String())
+	return nil
+}
+
+// writeFile replaces a local file with content. The content is written to
+// a temporary file first, so an interrupted copy leaves no partial file.
+func writeFile(p string, content io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, content)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func copyCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlagSet("cp", "[-r] [-j N] SRC DST")
+	recursive := flags.Bool("r", false, "Copy a directory or key prefix with everything below it")
+	workers := flags.Int("j", defaultWorkers, "Number of parallel transfers")
+	err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	src, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, err := parseLocation(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if !src.remote() && !dst.remote() {
+		return fmt.Errorf("one of SRC and DST must be of the form s3://BUCKET/KEY")
+	}
+
+	if !*recursive {
+		if dst.isDir() {
+			name := path.Base(filepath.ToSlash(src.path))
+			if src.remote() {
+				name = path.Base(src.key)
+			}
+			dst = dst.child(name)
+		}
+		return copyFile(ctx, c, src, dst)
+	}
+
+	entries, err := listTree(ctx, c, src)
+	if err != nil {
+		return err
+	}
+	rels := sortedKeys(entries)
+	return parallel(len(rels), *workers, func(i int) error {
+		return copyFile(ctx, c, entries[rels[i]].loc, dst.child(rels[i]))
+	})
+}
+
+func sortedKeys(entries map[string]entry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func syncCommand(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlagSet("sync", "[-j N] [--delete] SRC DST")
+	workers := flags.Int("j", defaultWorkers, "Number of parallel transfers")
+	del := flags.Bool("delete", false, "Delete files and objects of DST which SRC does not have")
+	err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	src, err := parseLocation(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, err := parseLocation(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if !src.remote() && !dst.remote() {
+		return fmt.Errorf("one of SRC and DST must be of the form s3://BUCKET/KEY")
+	}
+
+	srcEntries, err := listTree(ctx, c, src)
+	if err != nil {
+		return err
+	}
+	dstEntries, err := listTree(ctx, c, dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		// A missing local directory is created by the copies.
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var copies, deletions []string
+	for _, rel := range sortedKeys(srcEntries) {
+		dstEntry, ok := dstEntries[rel]
+		if !ok {
+			copies = append(copies, rel)
+			continue
+		}
+		diff, err := changed(srcEntries[rel], dstEntry)
+		if err != nil {
+			return err
+		}
+		if diff {
+			copies = append(copies, rel)
+		}
+	}
+	if *del {
+		for _, rel := range sortedKeys(dstEntries) {
+			if _, ok := srcEntries[rel]; !ok {
+				deletions = append(deletions, rel)
+			}
+		}
+	}
+
+	err = parallel(len(copies), *workers, func(i int) error {
+		return copyFile(ctx, c, srcEntries[copies[i]].loc, dst.child(copies[i]))
+	})
+	if err != nil {
+		return err
+	}
+	return parallel(len(deletions), *workers, func(i int) error {
+		loc := dstEntries[deletions[i]].loc
+		var err error
+		if loc.remote() {
+			err = c.DeleteObject(ctx, loc.bucketName, loc.key)
+		} else {
+			err = os.Remove(loc.path)
+		}
+		if err == nil {
+			fmt.Println("delete: " + loc.String())
+		}
+		return err
+	})
+}
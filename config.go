@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"triple-s/server"
+	"triple-s/storage"
+)
+
+// duration is a time.Duration written as "30s" or "5m" in the config file.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// rateLimit is a token-bucket rate limit; a zero rate is no limit.
+type rateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+}
+
+func (l rateLimit) rate() server.Rate {
+	return server.Rate{PerSecond: l.RequestsPerSecond, Burst: l.Burst}
+}
+
+type credential struct {
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+// config is the server configuration. Values are taken, in increasing
+// order of precedence, from the defaults, the JSON config file, TRIPLES_*
+// environment variables and command-line flags.
+type config struct {
+	Listen      string `json:"listen"`
+	AdminListen string `json:"adminListen"`
+	Dir         string `json:"dir"`
+	Backend     string `json:"backend"`
+	Dedup       bool   `json:"dedup"`
+	Limits      struct {
+		MaxObjectSize        int64 `json:"maxObjectSize"`
+		MaxConcurrentUploads int   `json:"maxConcurrentUploads"`
+		Rate                 struct {
+			PerAccessKey rateLimit `json:"perAccessKey"`
+			PerIP        rateLimit `json:"perIP"`
+			PerBucket    rateLimit `json:"perBucket"`
+		} `json:"rate"`
+	} `json:"limits"`
+	Auth struct {
+		Credentials []credential `json:"credentials"`
+	} `json:"auth"`
+	Encryption struct {
+		KeyFile string `json:"keyFile"`
+		Key     string `json:"key"`
+	} `json:"encryption"`
+	TLS struct {
+		Cert           string `json:"cert"`
+		Key            string `json:"key"`
+		ClientCA       string `json:"clientCA"`
+		HTTP2          bool   `json:"http2"`
+		RedirectListen string `json:"redirectListen"`
+	} `json:"tls"`
+	// Domain is the base domain of virtual-hosted-style requests.
+	Domain  string `json:"domain"`
+	Website struct {
+		Listen string `json:"listen"`
+		Domain string `json:"domain"`
+	} `json:"website"`
+	Log struct {
+		Level      string `json:"level"`
+		File       string `json:"file"`
+		MaxSizeMB  int    `json:"maxSizeMB"`
+		MaxBackups int    `json:"maxBackups"`
+	} `json:"log"`
+	Intervals struct {
+		LogDelivery     duration `json:"logDelivery"`
+		ShutdownTimeout duration `json:"shutdownTimeout"`
+		BlobGC          duration `json:"blobGC"`
+		// The timeouts of the HTTP servers; 0 disables one.
+		ReadHeaderTimeout duration `json:"readHeaderTimeout"`
+		ReadTimeout       duration `json:"readTimeout"`
+		WriteTimeout      duration `json:"writeTimeout"`
+		IdleTimeout       duration `json:"idleTimeout"`
+	} `json:"intervals"`
+}
+
+func defaultConfig() config {
+	var cfg config
+	cfg.Listen = ":8080"
+	// The admin endpoints include backups and quotas, so they are only
+	// reachable from the host unless configured otherwise.
+	cfg.AdminListen = "127.0.0.1:9090"
+	cfg.Dir = "data"
+	cfg.Backend = "fs"
+	cfg.TLS.HTTP2 = true
+	cfg.Log.Level = "info"
+	cfg.Log.MaxSizeMB = 100
+	cfg.Log.MaxBackups = 5
+	cfg.Intervals.LogDelivery = duration(5 * time.Minute)
+	cfg.Intervals.ShutdownTimeout = duration(30 * time.Second)
+	cfg.Intervals.BlobGC = duration(time.Hour)
+	cfg.Intervals.ReadHeaderTimeout = duration(10 * time.Second)
+	cfg.Intervals.ReadTimeout = duration(15 * time.Minute)
+	cfg.Intervals.WriteTimeout = duration(15 * time.Minute)
+	cfg.Intervals.IdleTimeout = duration(2 * time.Minute)
+	return cfg
+}
+
+// setting is a configuration value that can be given as a flag or as a
+// TRIPLES_* environment variable.
+type setting struct {
+	name   string
+	arg    string
+	usage  string
+	isBool bool
+	set    func(cfg *config, value string) error
+}
+
+func (s setting) envName() string {
+	return "TRIPLES_" + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func setString(field func(cfg *config) *string) func(*config, string) error {
+	return func(cfg *config, value string) error {
+		*field(cfg) = value
+		return nil
+	}
+}
+
+func setInt(field func(cfg *config) *int) func(*config, string) error {
+	return func(cfg *config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+// setRate parses a rate limit given as RATE or RATE:BURST.
+func setRate(field func(cfg *config) *rateLimit) func(*config, string) error {
+	return func(cfg *config, value string) error {
+		rate, burst, hasBurst := strings.Cut(value, ":")
+		perSecond, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not of the form RATE or RATE:BURST", value)
+		}
+		limit := rateLimit{RequestsPerSecond: perSecond}
+		if hasBurst {
+			limit.Burst, err = strconv.Atoi(burst)
+			if err != nil {
+				return fmt.Errorf("%q is not of the form RATE or RATE:BURST", value)
+			}
+		}
+		*field(cfg) = limit
+		return nil
+	}
+}
+
+func setDuration(field func(cfg *config) *duration) func(*config, string) error {
+	return func(cfg *config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		*field(cfg) = duration(d)
+		return nil
+	}
+}
+
+var settings = []setting{
+	{name: "listen", arg: "A", usage: "Address to listen on, e.g. 127.0.0.1:8080", set: setString(func(cfg *config) *string { return &cfg.Listen })},
+	{name: "port", arg: "N", usage: "Port number", set: func(cfg *config, value string) error {
+		host, _, _ := net.SplitHostPort(cfg.Listen)
+		cfg.Listen = net.JoinHostPort(host, value)
+		return nil
+	}},
+	{name: "dir", arg: "S", usage: "Path to the directory", set: setString(func(cfg *config) *string { return &cfg.Dir })},
+	{name: "backend", arg: "S", usage: "Storage backend: fs keeps data in the directory, memory loses it on exit", set: setString(func(cfg *config) *string { return &cfg.Backend })},
+	{name: "dedup", usage: "Store identical object content once (fs backend only)", isBool: true, set: func(cfg *config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		cfg.Dedup = b
+		return nil
+	}},
+	{name: "admin-listen", arg: "A", usage: "Address of the admin endpoints (/metrics, /healthz, /readyz, /backup, /gc, /rotate-keys, /quotas), empty disables them", set: setString(func(cfg *config) *string { return &cfg.AdminListen })},
+	{name: "max-object-size", arg: "N", usage: "Largest accepted object in bytes, 0 for no limit", set: func(cfg *config, value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		cfg.Limits.MaxObjectSize = n
+		return nil
+	}},
+	{name: "max-concurrent-uploads", arg: "N", usage: "Object uploads served at once, 0 for no limit", set: setInt(func(cfg *config) *int { return &cfg.Limits.MaxConcurrentUploads })},
+	{name: "rate-limit-access-key", arg: "R", usage: "Requests per second per access key as RATE or RATE:BURST, 0 for no limit", set: setRate(func(cfg *config) *rateLimit { return &cfg.Limits.Rate.PerAccessKey })},
+	{name: "rate-limit-ip", arg: "R", usage: "Requests per second per client IP as RATE or RATE:BURST, 0 for no limit", set: setRate(func(cfg *config) *rateLimit { return &cfg.Limits.Rate.PerIP })},
+	{name: "rate-limit-bucket", arg: "R", usage: "Requests per second per bucket as RATE or RATE:BURST, 0 for no limit", set: setRate(func(cfg *config) *rateLimit { return &cfg.Limits.Rate.PerBucket })},
+	{name: "auth-credentials", arg: "L", usage: "Comma-separated accessKey:secretKey pairs which must sign requests; none allows anonymous requests", set: func(cfg *config, value string) error {
+		cfg.Auth.Credentials = nil
+		for _, pair := range strings.Split(value, ",") {
+			accessKey, secretKey, ok := strings.Cut(pair, ":")
+			if !ok {
+				return fmt.Errorf("credential %q is not of the form accessKey:secretKey", pair)
+			}
+			cfg.Auth.Credentials = append(cfg.Auth.Credentials, credential{accessKey, secretKey})
+		}
+		return nil
+	}},
+	{name: "encryption-key-file", arg: "F", usage: "Master keys of server-side encryption, one id:base64 per line with the current key last, reloaded on SIGHUP", set: setString(func(cfg *config) *string { return &cfg.Encryption.KeyFile })},
+	{name: "encryption-key", arg: "K", usage: "Master key of server-side encryption as id:base64, instead of a key file", set: setString(func(cfg *config) *string { return &cfg.Encryption.Key })},
+	{name: "tls-cert", arg: "F", usage: "Serve HTTPS with this certificate, reloaded on SIGHUP", set: setString(func(cfg *config) *string { return &cfg.TLS.Cert })},
+	{name: "tls-key", arg: "F", usage: "Private key of the TLS certificate", set: setString(func(cfg *config) *string { return &cfg.TLS.Key })},
+	{name: "tls-client-ca", arg: "F", usage: "Require client certificates signed by these CAs", set: setString(func(cfg *config) *string { return &cfg.TLS.ClientCA })},
+	{name: "http2", usage: "Enable HTTP/2 over TLS (default true)", isBool: true, set: func(cfg *config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		cfg.TLS.HTTP2 = b
+		return nil
+	}},
+	{name: "http-redirect-listen", arg: "A", usage: "Redirect plaintext requests on this address to HTTPS", set: setString(func(cfg *config) *string { return &cfg.TLS.RedirectListen })},
+	{name: "domain", arg: "D", usage: "Accept virtual-hosted-style requests to <bucket>.D besides path-style ones", set: setString(func(cfg *config) *string { return &cfg.Domain })},
+	{name: "website-listen", arg: "A", usage: "Address serving buckets configured as static websites, empty disables it", set: setString(func(cfg *config) *string { return &cfg.Website.Listen })},
+	{name: "website-domain", arg: "D", usage: "Serve websites as <bucket>.D; other hosts name their bucket in full", set: setString(func(cfg *config) *string { return &cfg.Website.Domain })},
+	{name: "log-level", arg: "L", usage: "Access log level: debug, info, warn (client errors) or error (server errors)", set: setString(func(cfg *config) *string { return &cfg.Log.Level })},
+	{name: "log-file", arg: "F", usage: "Write access logs to a file instead of stderr", set: setString(func(cfg *config) *string { return &cfg.Log.File })},
+	{name: "log-max-size", arg: "N", usage: "Rotate the log file after N megabytes, 0 disables rotation", set: setInt(func(cfg *config) *int { return &cfg.Log.MaxSizeMB })},
+	{name: "log-max-backups", arg: "N", usage: "Number of rotated log files to keep", set: setInt(func(cfg *config) *int { return &cfg.Log.MaxBackups })},
+	{name: "log-delivery-interval", arg: "D", usage: "How often bucket access logs are delivered, e.g. 5m", set: setDuration(func(cfg *config) *duration { return &cfg.Intervals.LogDelivery })},
+	{name: "shutdown-timeout", arg: "D", usage: "How long to wait for in-flight requests on shutdown", set: setDuration(func(cfg *config) *duration { return &cfg.Intervals.ShutdownTimeout })},
+	{name: "read-header-timeout", arg: "D", usage: "How long a client may take to send request headers, 0 for no limit", set: setDuration(func(cfg *config) *duration { return &cfg.Intervals.ReadHeaderTimeout })},
+	{name: "read-timeout", arg: "D", usage: "How long a client may take to send a whole request, 0 for no limit", set: setDuration(func(cfg *config) *duration { return &cfg.Intervals.ReadTimeout })},
+	{name: "write-timeout", arg: "D", usage: "How long sending a response may take, 0 for no limit", set: setDuration(func(cfg *config) *duration { return &cfg.Intervals.WriteTimeout })},
+	{name: "idle-timeout", arg: "D", usage: "How long an idle keep-alive connection is kept open", set: setDuration(func(cfg *config) *duration { return &cfg.Intervals.IdleTimeout })},
+	{name: "blob-gc-interval", arg: "D", usage: "How often unreferenced blobs of the fs backend are removed", set: setDuration(func(cfg *config) *duration { return &cfg.Intervals.BlobGC })},
+}
+
+type flagValue struct {
+	setting setting
+	value   string
+}
+
+// registerSettingFlags defines a flag for every setting. The returned
+// slice is filled in by flag.Parse and applied by loadConfig.
+func registerSettingFlags(fs *flag.FlagSet) *[]flagValue {
+	values := &[]flagValue{}
+	for _, s := range settings {
+		s := s
+		record := func(value string) error {
+			*values = append(*values, flagValue{s, value})
+			return nil
+		}
+		if s.isBool {
+			fs.BoolFunc(s.name, s.usage, func(value string) error { return record(value) })
+		} else {
+			fs.Func(s.name, s.usage, record)
+		}
+	}
+	return values
+}
+
+// loadConfig builds the configuration from the config file at path (if
+// any), the environment and the parsed flag values.
+func loadConfig(path string, flagValues []flagValue) (config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		decoder := json.NewDecoder(strings.NewReader(string(content)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.envName())
+		if !ok {
+			continue
+		}
+		err := s.set(&cfg, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.envName(), err))
+		}
+	}
+	for _, fv := range flagValues {
+		err := fv.setting.set(&cfg, fv.value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", fv.setting.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return cfg, errors.Join(errs...)
+	}
+	return cfg, cfg.validate()
+}
+
+func validateAddress(name string, addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a host:port address", name, addr)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%s: %q is not a valid port", name, port)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: port 0 is reserved and cannot be used", name)
+	}
+	return nil
+}
+
+// validDomain reports whether domain, which may be empty, can be the base
+// domain of bucket host names.
+func validDomain(domain string) bool {
+	return !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".") && !strings.ContainsAny(domain, "/: ")
+}
+
+// validate reports every problem with the configuration at once.
+func (cfg config) validate() error {
+	var errs []error
+	err := validateAddress("listen", cfg.Listen)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.AdminListen != "" {
+		err = validateAddress("adminListen", cfg.AdminListen)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.Dir == "" {
+		errs = append(errs, fmt.Errorf("dir: must not be empty"))
+	}
+	if cfg.Backend != "fs" && cfg.Backend != "memory" {
+		errs = append(errs, fmt.Errorf("backend: must be fs or memory, not %q", cfg.Backend))
+	}
+	if cfg.Dedup && cfg.Backend != "fs" {
+		errs = append(errs, fmt.Errorf("dedup: requires the fs backend"))
+	}
+	if cfg.Limits.MaxObjectSize < 0 {
+		errs = append(errs, fmt.Errorf("limits.maxObjectSize: must not be negative"))
+	}
+	if cfg.Limits.MaxConcurrentUploads < 0 {
+		errs = append(errs, fmt.Errorf("limits.maxConcurrentUploads: must not be negative"))
+	}
+	rate := cfg.Limits.Rate
+	for _, limit := range []rateLimit{rate.PerAccessKey, rate.PerIP, rate.PerBucket} {
+		if limit.RequestsPerSecond < 0 || limit.Burst < 0 {
+			errs = append(errs, fmt.Errorf("limits.rate: requestsPerSecond and burst must not be negative"))
+			break
+		}
+	}
+	accessKeys := make(map[string]bool)
+	for i, cred := range cfg.Auth.Credentials {
+		if cred.AccessKey == "" || cred.SecretKey == "" {
+			errs = append(errs, fmt.Errorf("auth.credentials[%d]: access key and secret key must not be empty", i))
+		}
+		if accessKeys[cred.AccessKey] {
+			errs = append(errs, fmt.Errorf("auth.credentials[%d]: duplicate access key %q", i, cred.AccessKey))
+		}
+		accessKeys[cred.AccessKey] = true
+	}
+	if cfg.Encryption.KeyFile != "" && cfg.Encryption.Key != "" {
+		errs = append(errs, fmt.Errorf("encryption: give either keyFile or key"))
+	}
+	if cfg.Encryption.Key != "" {
+		_, err = storage.ParseKeyring(cfg.Encryption.Key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("encryption.key: %w", err))
+		}
+	}
+	if (cfg.TLS.Cert == "") != (cfg.TLS.Key == "") {
+		errs = append(errs, fmt.Errorf("tls: both cert and key must be given to enable TLS"))
+	}
+	if cfg.TLS.Cert == "" && (cfg.TLS.ClientCA != "" || cfg.TLS.RedirectListen != "") {
+		errs = append(errs, fmt.Errorf("tls: clientCA and redirectListen require TLS to be enabled"))
+	}
+	if cfg.TLS.RedirectListen != "" {
+		err = validateAddress("tls.redirectListen", cfg.TLS.RedirectListen)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.Website.Listen != "" {
+		err = validateAddress("website.listen", cfg.Website.Listen)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !validDomain(cfg.Domain) {
+		errs = append(errs, fmt.Errorf("domain: %q is not a domain name", cfg.Domain))
+	}
+	if !validDomain(cfg.Website.Domain) {
+		errs = append(errs, fmt.Errorf("website.domain: %q is not a domain name", cfg.Website.Domain))
+	}
+	_, err = parseLogLevel(cfg.Log.Level)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	if cfg.Log.MaxSizeMB < 0 || cfg.Log.MaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("log: maxSizeMB and maxBackups must not be negative"))
+	}
+	if cfg.Intervals.LogDelivery <= 0 {
+		errs = append(errs, fmt.Errorf("intervals.logDelivery: must be positive"))
+	}
+	if cfg.Intervals.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("intervals.shutdownTimeout: must be positive"))
+	}
+	if cfg.Intervals.BlobGC <= 0 {
+		errs = append(errs, fmt.Errorf("intervals.blobGC: must be positive"))
+	}
+	if cfg.Intervals.ReadHeaderTimeout < 0 || cfg.Intervals.ReadTimeout < 0 || cfg.Intervals.WriteTimeout < 0 || cfg.Intervals.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("intervals: server timeouts must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// credentials returns the secret keys of the configured access keys.
+func (cfg config) credentials() map[string]string {
+	creds := make(map[string]string, len(cfg.Auth.Credentials))
+	for _, cred := range cfg.Auth.Credentials {
+		creds[cred.AccessKey] = cred.SecretKey
+	}
+	return creds
+}
+
+// redacted returns a copy of the configuration safe to print.
+func (cfg config) redacted() config {
+	creds := make([]credential, len(cfg.Auth.Credentials))
+	for i, cred := range cfg.Auth.Credentials {
+		creds[i] = credential{AccessKey: cred.AccessKey, SecretKey: "REDACTED"}
+	}
+	cfg.Auth.Credentials = creds
+	if cfg.Encryption.Key != "" {
+		cfg.Encryption.Key = "REDACTED"
+	}
+	return cfg
+}
+
+// loadKeyring returns the master keys of server-side encryption, or nil if
+// none are configured.
+func (cfg config) loadKeyring() (*storage.Keyring, error) {
+	text := cfg.Encryption.Key
+	if cfg.Encryption.KeyFile != "" {
+		content, err := os.ReadFile(cfg.Encryption.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(content)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return storage.ParseKeyring(text)
+}
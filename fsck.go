@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"triple-s/storage"
+)
+
+// dataDirFlag registers the --dir flag of the subcommands working on a
+// data directory directly.
+func dataDirFlag(fs *flag.FlagSet) *string {
+	dir := os.Getenv("TRIPLES_DIR")
+	if dir == "" {
+		dir = "data"
+	}
+	return fs.String("dir", dir, "Path to the directory (TRIPLES_DIR)")
+}
+
+// runFsck implements "triple-s fsck", which checks a data directory for
+// inconsistencies between its metadata files and bucket directories. It
+// returns the exit status: 0 if no problem remains, 1 if problems remain
+// unrepaired and 2 if the check could not be run.
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	dir := dataDirFlag(fs)
+	repair := fs.Bool("repair", false, "Repair the problems found")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: triple-s fsck [--dir S] [--repair]")
+		fmt.Fprintln(os.Stderr, "Checks the data directory while the server is stopped.")
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if err != nil || fs.NArg() > 0 {
+		return 2
+	}
+
+	problems, err := storage.Fsck(*dir, *repair)
+	remaining := 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if !p.Repaired {
+			remaining++
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsck:", err)
+		return 2
+	}
+	fmt.Printf("problems found: %d, repaired: %d\n", len(problems), len(problems)-remaining)
+	if remaining > 0 {
+		return 1
+	}
+	return 0
+}
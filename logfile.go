@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// rotatingFile is an io.Writer over a log file which is renamed to
+// <path>.1, <path>.2, ... once it grows past maxSize bytes.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	err := rf.file.Close()
+	if err != nil {
+		return err
+	}
+	if rf.maxBackups <= 0 {
+		os.Remove(rf.path)
+		return rf.open()
+	}
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	err = os.Rename(rf.path, rf.path+".1")
+	if err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return lvl, fmt.Errorf("invalid log level %q", level)
+	}
+	return lvl, nil
+}
+
+// nopCloser is the io.Closer of a logger writing to stderr, which is left
+// open.
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// newLogger builds the JSON logger used for access logs. An empty path
+// logs to stderr; maxSizeMB of 0 disables rotation.
+func newLogger(level string, path string, maxSizeMB int, maxBackups int) (*slog.Logger, io.Closer, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+	if path != "" {
+		rf, err := openRotatingFile(path, int64(maxSizeMB)<<20, maxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = rf
+		closer = rf
+	}
+	return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: lvl})), closer, nil
+}
@@ -1,689 +1,286 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
-	"regexp"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
-)
-
-var rootDir string
-
-func writeHttpError(w http.ResponseWriter, code int, errorCode string, message string) {
-	w.WriteHeader(code)
-	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
-	fmt.Fprintln(w, "<Error>")
-	fmt.Fprintln(w, "\t<Code>"+errorCode+"</Code>")
-	fmt.Fprintln(w, "\t<Message>")
-	fmt.Fprintln(w, "\t\t"+message)
-	fmt.Fprintln(w, "\t</Message>")
-	fmt.Fprintln(w, "</Error>")
-}
-
-// TODO: GOFUMPT
-func getBuckets(w http.ResponseWriter, r *http.Request) {
-	bucketMetadata, err := os.Open(filepath.Join(rootDir, "buckets.csv"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access bucket metadata")
-		return
-	}
-	defer bucketMetadata.Close()
 
-	csvReader := csv.NewReader(bucketMetadata)
-	var bkts []map[string]string
-	_, err = csvReader.Read() // header record
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
-	}
+	"triple-s/server"
+	"triple-s/storage"
+)
 
-	fields, err := csvReader.Read()
-	for err == nil && len(fields) == 4 {
-		bkt := make(map[string]string)
-		bkt["Name"] = fields[0]
-		bkt["CreationTime"] = fields[1]
-		bkt["LastModifiedTime"] = fields[2]
-		bkt["Status"] = fields[3]
-		if bkt["Status"] == "Active" {
-			bkts = append(bkts, bkt)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fsck":
+			os.Exit(runFsck(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
 		}
-		fields, err = csvReader.Read()
-	}
-	if err != io.EOF {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Invalid metadata content")
-		return
-	}
-
-	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
-	fmt.Fprintln(w, "<ListAllMyBucketsResult>")
-	fmt.Fprintln(w, "\t<Buckets>")
-	for _, bkt := range bkts {
-		fmt.Fprintln(w, "\t\t<Bucket>")
-		fmt.Fprintln(w, "\t\t\t<Name>"+bkt["Name"]+"</Name>")
-		fmt.Fprintln(w, "\t\t\t<CreationTime>"+bkt["CreationTime"]+"</CreationTime>")
-		fmt.Fprintln(w, "\t\t\t<LastModifiedTime>"+bkt["LastModifiedTime"]+"</LastModifiedTime>")
-		fmt.Fprintln(w, "\t\t\t<Status>"+bkt["Status"]+"</Status>")
-		fmt.Fprintln(w, "\t\t</Bucket>")
-	}
-	fmt.Fprintln(w, "\t</Buckets>")
-	fmt.Fprintln(w, "</ListAllMyBucketsResult>")
-}
-
-func isValidBucketName(bucketName string) (bool, string) {
-	charsetAndLength := regexp.MustCompile("^[-.a-z0-9]{3,63}$")
-	if !charsetAndLength.MatchString(bucketName) {
-		return false, "Contains invalid characters and/or too short/long"
-	}
-	ipFormat := regexp.MustCompile("^[0-9]+[.][0-9]+[.][0-9]+[.][0-9]+$")
-	if ipFormat.MatchString(bucketName) {
-		return false, "Name must not match ip-format"
-	}
-	startHyphen := regexp.MustCompile("^-.*")
-	endHyphen := regexp.MustCompile("^.*-$")
-	if startHyphen.MatchString(bucketName) || endHyphen.MatchString(bucketName) {
-		return false, "Name must not start or end with a hyphen"
-	}
-	consecutiveHyphens := regexp.MustCompile(".*--.*")
-	consecutiveDots := regexp.MustCompile(".*[.][.].*")
-	if consecutiveHyphens.MatchString(bucketName) || consecutiveDots.MatchString(bucketName) {
-		return false, "Name must not contain consecutive hyphens/dots"
-	}
-	return true, ""
-}
-
-func putBucket(w http.ResponseWriter, r *http.Request) {
-	bucketName := r.PathValue("BucketName")
-	isValid, errMsg := isValidBucketName(bucketName)
-	if !isValid {
-		writeHttpError(w, http.StatusBadRequest, "BucketNameInvalid", "Bucket name is invalid - "+errMsg)
-		return
-	}
-
-	bucketPath := filepath.Join(rootDir, bucketName)
-	_, err := os.Stat(bucketPath)
-	if !(err != nil && os.IsNotExist(err)) {
-		writeHttpError(w, http.StatusConflict, "BucketNameUnavailable", "Bucket with this name already exists")
-		return
-	}
-
-	err = os.Mkdir(bucketPath, 0o755)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "BucketCreationError", "Could not create bucket")
-		return
 	}
 
-	info, err := os.Stat(bucketPath)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "BucketAccessError", "Could not access created bucket")
-		return
-	}
-
-	bucketMetadata, err := os.OpenFile(filepath.Join(rootDir, "buckets.csv"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access bucket metadata")
-		return
-	}
-	defer bucketMetadata.Close()
-	modTime := info.ModTime()
-	modTimeToString := fmt.Sprintf("%d-%02d-%02dT%02d-%02d-%02d", modTime.Year(), modTime.Month(), modTime.Day(), modTime.Hour(), modTime.Minute(), modTime.Second())
+	configFlag := flag.String("config", os.Getenv("TRIPLES_CONFIG"), "path to a JSON configuration file")
+	printConfigFlag := flag.Bool("print-config", false, "print the effective configuration and exit")
+	helpFlag := flag.Bool("help", false, "provides usage information")
+	flagValues := registerSettingFlags(flag.CommandLine)
+	flag.Parse()
 
-	csvWriter := csv.NewWriter(bucketMetadata)
-	err = csvWriter.Write([]string{bucketName, modTimeToString, modTimeToString, "Active"})
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update bucket metadata")
-		return
+	if *helpFlag {
+		fmt.Println("Simple Storage Service.")
+		fmt.Println()
+		fmt.Println("**Usage:**")
+		fmt.Println("\ttriple-s [-config <F>] [-port <N>] [-dir <S>] [options]")
+		fmt.Println("\ttriple-s --print-config")
+		fmt.Println("\ttriple-s fsck [--dir <S>] [--repair]")
+		fmt.Println("\ttriple-s backup [--dir <S>] [--gzip] <FILE>")
+		fmt.Println("\ttriple-s restore [--dir <S>] [--bucket <B>] <FILE>")
+		fmt.Println("\ttriple-s --help")
+		fmt.Println()
+		fmt.Println("**Options:**")
+		fmt.Println("- --help\tShow this screen.")
+		fmt.Println("- --config F\tJSON configuration file (TRIPLES_CONFIG)")
+		fmt.Println("- --print-config\tPrint the effective configuration and exit")
+		for _, s := range settings {
+			fmt.Printf("- --%s\t%s (%s)\n", strings.TrimSpace(s.name+" "+s.arg), s.usage, s.envName())
+		}
+		os.Exit(0)
 	}
-	csvWriter.Flush()
 
-	objectMetadata, err := os.OpenFile(filepath.Join(bucketPath, "objects.csv"), os.O_CREATE|os.O_WRONLY, 0o755)
+	cfg, err := loadConfig(*configFlag, *flagValues)
 	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not create object metadata")
-		return
+		log.Fatal("Invalid configuration:\n", err)
 	}
-	defer objectMetadata.Close()
-	_, err = objectMetadata.Write([]byte("ObjectKey,Size,ContentType,LastModified\n"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update object metadata")
-		return
-	}
-
-	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
-	fmt.Fprintln(w, "<CreateBucketResult>")
-	fmt.Fprintln(w, "\t<Name>"+bucketName+"</Name>")
-	fmt.Fprintln(w, "\t<CreationTime>"+modTimeToString+"</CreationTime>")
-	fmt.Fprintln(w, "\t<Status>Active</Status>")
-	fmt.Fprintln(w, "</CreateBucketResult>")
-}
-
-func deleteBucket(w http.ResponseWriter, r *http.Request) {
-	bucketName := r.PathValue("BucketName")
-
-	bucketMetadata, err := os.Open(filepath.Join(rootDir, "buckets.csv"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access bucket metadata")
-		return
+	if *printConfigFlag {
+		output, _ := json.MarshalIndent(cfg.redacted(), "", "\t")
+		fmt.Println(string(output))
+		os.Exit(0)
 	}
-	defer bucketMetadata.Close()
 
-	csvReader := csv.NewReader(bucketMetadata)
-	_, err = csvReader.Read() // header
+	logger, logFile, err := newLogger(cfg.Log.Level, cfg.Log.File, cfg.Log.MaxSizeMB, cfg.Log.MaxBackups)
 	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
-	}
-	fields, err := csvReader.Read()
-	exists := false
-	var bkts [][]string
-	for err == nil && len(fields) == 4 {
-		if fields[0] == bucketName {
-			exists = true
-			fields[3] = "Deleted"
-		}
-		bkts = append(bkts, fields)
-		fields, err = csvReader.Read()
-	}
-	if err != io.EOF {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
+		log.Fatal(err)
 	}
-	if !exists {
-		writeHttpError(w, http.StatusNotFound, "BucketNotFound", "Could not delete - bucket does not exist")
-		return
+	defer logFile.Close()
+	// log.Fatal skips deferred calls, so the log file is closed before
+	// every exit from here on.
+	fatal := func(v ...any) {
+		logFile.Close()
+		log.Fatal(v...)
 	}
 
-	objectMetadata, err := os.Open(filepath.Join(rootDir, bucketName, "objects.csv"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access object metadata")
-		return
+	var backend storage.Backend
+	switch {
+	case cfg.Backend == "memory":
+		backend = storage.NewMemoryBackend()
+	case cfg.Dedup:
+		backend, err = storage.NewDedupBackend(cfg.Dir)
+	default:
+		backend, err = storage.NewFSBackend(cfg.Dir)
 	}
-	defer objectMetadata.Close()
-	csvReader = csv.NewReader(objectMetadata)
-	_, err = csvReader.Read()
 	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access object metadata")
-		return
+		fatal("Could not open data directory: ", err)
 	}
-	_, err = csvReader.Read()
-	if err != io.EOF {
-		if err == nil {
-			writeHttpError(w, http.StatusConflict, "BucketNotEmpty", "Could not delete - bucket not empty")
-		} else {
-			writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read object metadata")
-		}
-		return
-	}
-
-	err = os.Remove(filepath.Join(rootDir, bucketName, "objects.csv"))
+	store, err := storage.New(backend)
 	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not delete object metadata")
-		return
+		fatal(err)
 	}
-	err = os.Remove(filepath.Join(rootDir, bucketName))
-	successfullyDeleted := true
+	keyring, err := cfg.loadKeyring()
 	if err != nil {
-		successfullyDeleted = false
+		fatal("Could not load encryption keys: ", err)
 	}
-	metadataWrite, err := os.OpenFile(filepath.Join(rootDir, "buckets.csv"), os.O_WRONLY|os.O_TRUNC, 0o600)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access bucket metadata")
-		return
-	}
-	defer metadataWrite.Close()
-	csvWriter := csv.NewWriter(metadataWrite)
-	err = csvWriter.Write([]string{"Name", "CreationTime", "LastModifiedTime", "Status"})
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update bucket metadata")
-		return
+	if keyring != nil {
+		store.SetKeyring(keyring)
 	}
-	for _, bkt := range bkts {
-		if bkt[0] != bucketName || !successfullyDeleted {
-			err = csvWriter.Write(bkt)
-			if err != nil {
-				writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update bucket metadata")
-				return
+	if cfg.Encryption.KeyFile != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				keyring, err := cfg.loadKeyring()
+				if err != nil {
+					log.Println("Could not reload encryption keys:", err)
+					continue
+				}
+				store.SetKeyring(keyring)
+				log.Println("Reloaded encryption keys, current key", keyring.Current())
 			}
+		}()
+	}
+	handler := server.NewHandler(store, server.Options{
+		Logger:        logger,
+		MaxObjectSize: cfg.Limits.MaxObjectSize,
+		RateLimits: server.RateLimits{
+			PerAccessKey: cfg.Limits.Rate.PerAccessKey.rate(),
+			PerIP:        cfg.Limits.Rate.PerIP.rate(),
+			PerBucket:    cfg.Limits.Rate.PerBucket.rate(),
+		},
+		MaxConcurrentUploads: cfg.Limits.MaxConcurrentUploads,
+		Credentials:          cfg.credentials(),
+		Domain:               cfg.Domain,
+		WebsiteDomain:        cfg.Website.Domain,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErrs := make(chan error, 3)
+
+	httpServer := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.Intervals.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.Intervals.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.Intervals.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Intervals.IdleTimeout),
+	}
+	var redirectServer *http.Server
+	if cfg.TLS.Cert != "" {
+		certs, err := newCertReloader(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			fatal("Could not load TLS certificate: ", err)
 		}
-	}
-	csvWriter.Flush()
-	w.WriteHeader(http.StatusNoContent)
-}
-
-func getObject(w http.ResponseWriter, r *http.Request) {
-	bucketMetadata, err := os.Open(filepath.Join(rootDir, "buckets.csv"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access bucket metadata")
-		return
-	}
-	defer bucketMetadata.Close()
-
-	bucketName := r.PathValue("BucketName")
-	csvReader := csv.NewReader(bucketMetadata)
-	_, err = csvReader.Read() // header
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
-	}
-	fields, err := csvReader.Read()
-	bucketFound := false
-	for err == nil {
-		if fields[0] == bucketName {
-			bucketFound = true
-			break
-		}
-		fields, err = csvReader.Read()
-	}
-	if err != io.EOF && !bucketFound {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
-	}
-	if !bucketFound {
-		writeHttpError(w, http.StatusNotFound, "BucketNotFound", "Bucket does not exist")
-		return
-	}
-	objectKey := r.PathValue("ObjectKey")
-	if objectKey == "objects.csv" {
-		writeHttpError(w, http.StatusForbidden, "MetadataAccessDenied", "Public metadata access is forbidden")
-		return
-	}
-
-	objectMetadata, err := os.Open(filepath.Join(rootDir, bucketName, "objects.csv"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access object metadata")
-		return
-	}
-	defer objectMetadata.Close()
-	csvReader = csv.NewReader(objectMetadata)
-	_, err = csvReader.Read() // header
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read object metadata")
-		return
-	}
-	fields, err = csvReader.Read()
-	var objectInfo []string
-	for err == nil {
-		if fields[0] == objectKey {
-			objectInfo = fields
-			break
+		httpServer.TLSConfig, err = newTLSConfig(certs, cfg.TLS.ClientCA)
+		if err != nil {
+			fatal("Could not load client CA: ", err)
 		}
-		fields, err = csvReader.Read()
-	}
-	if err != io.EOF && len(objectInfo) == 0 {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read object metadata")
-		return
-	}
-	if len(objectInfo) == 0 {
-		writeHttpError(w, http.StatusNotFound, "ObjectNotFound", "Object does not exist")
-		return
-	}
-
-	content, err := os.ReadFile(filepath.Join(rootDir, bucketName, objectKey))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "ObjectAccessError", "Could not access object")
-		return
-	}
-
-	w.Header().Add("Content-Length", objectInfo[1])
-	w.Write(content)
-}
-
-func putObject(w http.ResponseWriter, r *http.Request) {
-	bucketMetadata, err := os.Open(filepath.Join(rootDir, "buckets.csv"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access bucket metadata")
-		return
-	}
-	defer bucketMetadata.Close()
-
-	bucketName := r.PathValue("BucketName")
-	csvReader := csv.NewReader(bucketMetadata)
-	_, err = csvReader.Read() // header
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
-	}
-	fields, err := csvReader.Read()
-	var bkts [][]string
-	bucketFound := false
-	for err == nil {
-		if fields[0] == bucketName {
-			bucketFound = true
-			now := time.Now()
-			fields[2] = fmt.Sprintf("%d-%02d-%02dT%02d-%02d-%02d", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
+		if !cfg.TLS.HTTP2 {
+			httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
 		}
-		bkts = append(bkts, fields)
-		fields, err = csvReader.Read()
-	}
-	if err != io.EOF {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
-	}
-	if !bucketFound {
-		writeHttpError(w, http.StatusNotFound, "BucketNotFound", "Bucket does not exist")
-		return
-	}
-	objectKey := r.PathValue("ObjectKey")
-	if objectKey == "objects.csv" {
-		writeHttpError(w, http.StatusForbidden, "MetadataAccessDenied", "Metadata editing is forbidden")
-		return
-	}
-	if len(objectKey) > 1024 {
-		writeHttpError(w, http.StatusBadRequest, "ObjectKeyInvalid", "Object key is too long (> 1024)")
-		return
-	}
 
-	objectMetadata, err := os.Open(filepath.Join(rootDir, bucketName, "objects.csv"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access object metadata")
-		return
-	}
-	defer objectMetadata.Close()
-	csvReader = csv.NewReader(objectMetadata)
-	_, err = csvReader.Read() // header
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read object metadata")
-		return
-	}
-	fields, err = csvReader.Read()
-	var objs [][]string
-	objectFound := false
-	for err == nil {
-		if fields[0] == objectKey {
-			objectFound = true
-			fields[1] = r.Header.Get("Content-Length")
-			fields[2] = r.Header.Get("Content-Type")
-			if len(fields[2]) == 0 {
-				fields[2] = "text/plain"
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				err := certs.reload()
+				if err != nil {
+					log.Println("Could not reload TLS certificate:", err)
+				} else {
+					log.Println("Reloaded TLS certificate")
+				}
 			}
-			now := time.Now()
-			fields[3] = fmt.Sprintf("%d-%02d-%02dT%02d-%02d-%02d", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
-		}
-		objs = append(objs, fields)
-		fields, err = csvReader.Read()
-	}
-	if err != io.EOF {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read object metadata")
-		return
-	}
-	if !objectFound {
-		now := time.Now()
-		newRec := []string{objectKey, r.Header.Get("Content-Length"), r.Header.Get("Content-Type"), fmt.Sprintf("%d-%02d-%02dT%02d-%02d-%02d", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())}
-		if len(newRec[2]) == 0 {
-			newRec[2] = "text/plain"
+		}()
+
+		go func() {
+			serveErrs <- httpServer.ListenAndServeTLS("", "")
+		}()
+		if cfg.TLS.RedirectListen != "" {
+			_, httpsPort, _ := net.SplitHostPort(cfg.Listen)
+			redirectServer = &http.Server{
+				Addr:              cfg.TLS.RedirectListen,
+				Handler:           redirectToHTTPS(httpsPort),
+				ReadHeaderTimeout: time.Duration(cfg.Intervals.ReadHeaderTimeout),
+				IdleTimeout:       time.Duration(cfg.Intervals.IdleTimeout),
+			}
+			go func() {
+				serveErrs <- redirectServer.ListenAndServe()
+			}()
 		}
-		objs = append(objs, newRec)
-	}
-
-	object, err := os.OpenFile(filepath.Join(rootDir, bucketName, objectKey), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "ObjectAccessError", "Could not access object")
-		return
-	}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "RequestBodyError", "Could not read request body")
-		return
-	}
-	_, err = object.Write(body)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "ObjectWriteError", "Could not write to object")
-		return
-	}
-
-	objMetadataWrite, err := os.OpenFile(filepath.Join(rootDir, bucketName, "objects.csv"), os.O_WRONLY|os.O_TRUNC, 0o755)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update object metadata")
-		return
-	}
-	defer objMetadataWrite.Close()
-	csvWriter := csv.NewWriter(objMetadataWrite)
-	err = csvWriter.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not write to object metadata")
-		return
-	}
-	for _, obj := range objs {
-		err = csvWriter.Write(obj)
-		if err != nil {
-			writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not write to object metadata")
-			return
+	} else {
+		go func() {
+			serveErrs <- httpServer.ListenAndServe()
+		}()
+	}
+
+	var adminServer *http.Server
+	if cfg.AdminListen != "" {
+		// Backups stream for as long as they take, so the admin
+		// listener has no read or write timeout.
+		adminServer = &http.Server{
+			Addr:              cfg.AdminListen,
+			Handler:           handler.AdminHandler(),
+			ReadHeaderTimeout: time.Duration(cfg.Intervals.ReadHeaderTimeout),
+			IdleTimeout:       time.Duration(cfg.Intervals.IdleTimeout),
 		}
-	}
-	csvWriter.Flush()
-
-	bktMetadataWrite, err := os.OpenFile(filepath.Join(rootDir, "buckets.csv"), os.O_WRONLY|os.O_TRUNC, 0o755)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update bucket metadata")
-		return
-	}
-	defer bktMetadataWrite.Close()
-	csvWriter = csv.NewWriter(bktMetadataWrite)
-	err = csvWriter.Write([]string{"Name", "CreationTime", "LastModifiedTime", "Status"})
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update bucket metadata")
-		return
-	}
-	for _, bkt := range bkts {
-		err = csvWriter.Write(bkt)
-		if err != nil {
-			writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not write to bucket metadata")
-			return
+		go func() {
+			serveErrs <- adminServer.ListenAndServe()
+		}()
+	}
+
+	var websiteServer *http.Server
+	if cfg.Website.Listen != "" {
+		websiteServer = &http.Server{
+			Addr:              cfg.Website.Listen,
+			Handler:           handler.WebsiteHandler(),
+			ReadHeaderTimeout: time.Duration(cfg.Intervals.ReadHeaderTimeout),
+			ReadTimeout:       time.Duration(cfg.Intervals.ReadTimeout),
+			WriteTimeout:      time.Duration(cfg.Intervals.WriteTimeout),
+			IdleTimeout:       time.Duration(cfg.Intervals.IdleTimeout),
 		}
+		go func() {
+			serveErrs <- websiteServer.ListenAndServe()
+		}()
 	}
-	csvWriter.Flush()
-}
 
-func deleteObject(w http.ResponseWriter, r *http.Request) { // PROHIBIT METADATA DELETION
-	bucketMetadata, err := os.Open(filepath.Join(rootDir, "buckets.csv"))
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access bucket metadata")
-		return
+	stopDelivery := make(chan struct{})
+	deliveryDone := make(chan struct{})
+	go func() {
+		handler.RunLogDelivery(time.Duration(cfg.Intervals.LogDelivery), stopDelivery)
+		close(deliveryDone)
+	}()
+	if cfg.Dedup {
+		go collectGarbage(store, time.Duration(cfg.Intervals.BlobGC), ctx.Done())
 	}
-	defer bucketMetadata.Close()
 
-	bucketName := r.PathValue("BucketName")
-	csvReader := csv.NewReader(bucketMetadata)
-	_, err = csvReader.Read() // header
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
-	}
-	fields, err := csvReader.Read()
-	var bkts [][]string
-	bucketFound := false
-	for err == nil {
-		if fields[0] == bucketName {
-			bucketFound = true
-		}
-		bkts = append(bkts, fields)
-		fields, err = csvReader.Read()
-	}
-	if err != io.EOF {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read bucket metadata")
-		return
-	}
-	if !bucketFound {
-		writeHttpError(w, http.StatusNotFound, "BucketNotFound", "Bucket does not exist")
-		return
-	}
-	objectKey := r.PathValue("ObjectKey")
-	if objectKey == "objects.csv" {
-		writeHttpError(w, http.StatusForbidden, "MetadataAccessDenied", "Metadata deleting is forbidden")
-		return
+	select {
+	case err = <-serveErrs:
+		fatal(err)
+	case <-ctx.Done():
 	}
+	stop()
 
-	objectMetadata, err := os.Open(filepath.Join(rootDir, bucketName, "objects.csv"))
+	log.Println("Shutting down, draining in-flight requests")
+	handler.StartShutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Intervals.ShutdownTimeout))
+	defer cancel()
+	err = httpServer.Shutdown(shutdownCtx)
 	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not access object metadata")
-		return
-	}
-	defer objectMetadata.Close()
-	csvReader = csv.NewReader(objectMetadata)
-	_, err = csvReader.Read()
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read object metadata")
-		return
-	}
-	fields, err = csvReader.Read()
-	var objs [][]string
-	objectFound := false
-	for err == nil {
-		if fields[0] == objectKey {
-			objectFound = true
-		} else {
-			objs = append(objs, fields)
-		}
-		fields, err = csvReader.Read()
+		log.Println("Could not drain all requests:", err)
 	}
-	if err != io.EOF {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not read object metadata")
-		return
-	}
-	if !objectFound {
-		writeHttpError(w, http.StatusNotFound, "ObjectNotFound", "Object does not exist")
-		return
+	if websiteServer != nil {
+		websiteServer.Shutdown(shutdownCtx)
 	}
 
-	err = os.Remove(filepath.Join(rootDir, bucketName, objectKey))
+	close(stopDelivery)
+	<-deliveryDone
+	err = store.Sync()
 	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "ObjectDeletionError", "Could not delete object")
-		return
+		log.Println("Could not flush metadata:", err)
 	}
-
-	objMetadataWrite, err := os.OpenFile(filepath.Join(rootDir, bucketName, "objects.csv"), os.O_WRONLY|os.O_TRUNC, 0o755)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update object metadata")
-		return
+	if adminServer != nil {
+		adminServer.Shutdown(shutdownCtx)
 	}
-	defer objMetadataWrite.Close()
-	csvWriter := csv.NewWriter(objMetadataWrite)
-	err = csvWriter.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not write to object metadata")
-		return
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
 	}
-	for _, obj := range objs {
-		err = csvWriter.Write(obj)
-		if err != nil {
-			writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not write to object metadata")
-			return
-		}
-	}
-	csvWriter.Flush()
-
-	bktMetadataWrite, err := os.OpenFile(filepath.Join(rootDir, "buckets.csv"), os.O_WRONLY|os.O_TRUNC, 0o755)
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update bucket metadata")
-		return
-	}
-	defer bktMetadataWrite.Close()
-	csvWriter = csv.NewWriter(bktMetadataWrite)
-	err = csvWriter.Write([]string{"Name", "CreationTime", "LastModifiedTime", "Status"})
-	if err != nil {
-		writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not update bucket metadata")
-		return
-	}
-	for i, bkt := range bkts {
-		if bkt[0] == bucketName {
-			now := time.Now()
-			bkts[i][2] = fmt.Sprintf("%d-%02d-%02dT%02d-%02d-%02d", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
-		}
-		err = csvWriter.Write(bkts[i])
-		if err != nil {
-			writeHttpError(w, http.StatusInternalServerError, "MetadataError", "Could not write to bucket metadata")
-			return
-		}
-	}
-	csvWriter.Flush()
-	w.WriteHeader(http.StatusNoContent)
-}
-
-func badRequest(w http.ResponseWriter, r *http.Request) {
-	writeHttpError(w, http.StatusBadRequest, "BadRequest", "Wrong http-method and/or URL-address of the request")
 }
 
-func main() {
-	http.HandleFunc("/", badRequest)
-
-	http.HandleFunc("GET /{$}", getBuckets)
-
-	http.HandleFunc("PUT /{BucketName}", putBucket)
-	http.HandleFunc("PUT /{BucketName}/{$}", putBucket)
-
-	http.HandleFunc("DELETE /{BucketName}", deleteBucket)
-	http.HandleFunc("DELETE /{BucketName}/{$}", deleteBucket)
-
-	http.HandleFunc("GET /{BucketName}/{ObjectKey}", getObject)
-	http.HandleFunc("GET /{BucketName}/{ObjectKey}/{$}", getObject)
-
-	http.HandleFunc("PUT /{BucketName}/{ObjectKey}", putObject)
-	http.HandleFunc("PUT /{BucketName}/{ObjectKey}/{$}", putObject)
-
-	http.HandleFunc("DELETE /{BucketName}/{ObjectKey}", deleteObject)
-	http.HandleFunc("DELETE /{BucketName}/{ObjectKey}/{$}", deleteObject)
-
-	portFlag := flag.String("port", "8080", "specify port number")
-	dirFlag := flag.String("dir", "data", "specify the root directory for the buckets")
-	helpFlag := flag.Bool("help", false, "provides usage information")
-	flag.Parse()
-
-	if *helpFlag {
-		fmt.Println("Simple Storage Service.")
-		fmt.Println()
-		fmt.Println("**Usage:**")
-		fmt.Println("\ttriple-s [-port <N>] [-dir <S>]")
-		fmt.Println("\ttriple-s --help")
-		fmt.Println()
-		fmt.Println("**Options:**")
-		fmt.Println("- --help\tShow this screen.")
-		fmt.Println("- --port N\tPort number")
-		fmt.Println("- --dir S\tPath to the directory")
-		os.Exit(0)
-	}
-
-	port, err := strconv.Atoi(*portFlag)
-	if err == nil && port == 0 {
-		log.Fatal("Port 0 is reserved and cannot be used")
-	}
-
-	rootDir = *dirFlag
-	_, err = os.Stat(rootDir)
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatal(err)
-	}
-	if err != nil {
-		err = os.Mkdir(rootDir, 0o755)
-		if err != nil {
-			log.Fatal("Could not create directory")
-		}
-		metadata, err := os.OpenFile(filepath.Join(rootDir, "buckets.csv"), os.O_CREATE|os.O_WRONLY, 0o755)
-		if err != nil {
-			log.Fatal("Could not initiate metadata")
-		}
-		_, err = metadata.Write([]byte("Name,CreationTime,LastModifiedTime,Status\n"))
-		if err != nil {
-			log.Fatal("Could not write to metadata")
-		}
-	} else {
-		_, err := os.Stat(filepath.Join(rootDir, "buckets.csv"))
-		if err != nil && os.IsNotExist(err) {
-			metadata, err := os.OpenFile(filepath.Join(rootDir, "buckets.csv"), os.O_CREATE|os.O_WRONLY, 0o755)
+// collectGarbage removes unreferenced blobs every interval until stop is
+// closed.
+func collectGarbage(store *storage.Store, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			removed, freed, err := store.CollectGarbage()
 			if err != nil {
-				log.Fatal("Could not initiate metadata")
+				log.Println("Could not collect blobs:", err)
+			} else if removed > 0 {
+				log.Printf("Collected %d unreferenced blobs, %d bytes", removed, freed)
 			}
-			_, err = metadata.Write([]byte("Name,CreationTime,LastModifiedTime,Status\n"))
-			if err != nil {
-				log.Fatal("Could not write to metadata")
-			}
-		} else if err != nil {
-			log.Fatal("Error with metadata")
+		case <-stop:
+			return
 		}
 	}
-	log.Fatal(http.ListenAndServe(":"+*portFlag, nil))
 }
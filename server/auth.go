@@ -0,0 +1,303 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateLayout    = "20060102T150405Z"
+	// maxClockSkew is how far the date of a signed request may be from
+	// the time it is received.
+	maxClockSkew = 15 * time.Minute
+	// maxPresignExpires is the longest validity of a presigned URL.
+	maxPresignExpires = 7 * 24 * 60 * 60
+	// maxSignedBodySize is the largest body with a signed payload hash
+	// which is read and checked before the request is served; only object
+	// content may be larger.
+	maxSignedBodySize = 1 << 20
+)
+
+type identityKey struct{}
+
+// identityOf returns the access key whose signature of a request was
+// verified, or "" for an anonymous request.
+func identityOf(r *http.Request) string {
+	accessKey, _ := r.Context().Value(identityKey{}).(string)
+	return accessKey
+}
+
+func accessDenied(message string) *requestError {
+	return &requestError{http.StatusForbidden, "AccessDenied", message}
+}
+
+// authenticate verifies the AWS Signature Version 4 of requests when
+// credentials are configured, and refuses requests without a valid one.
+// Without credentials every request is served anonymously. Preflight
+// requests are not signed by browsers, and form uploads are verified by
+// the signature of their policy.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.credentials) == 0 || r.Method == http.MethodOptions || isFormUpload(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		accessKey, rerr := h.verifySignature(r, time.Now())
+		if _, key := splitPath(r.URL.Path); rerr == nil && !isUpload(r, key) {
+			rerr = readSignedBody(r)
+		}
+		if rerr != nil {
+			writeHttpError(w, rerr.status, rerr.code, rerr.message)
+			return
+		}
+		recordIdentity(w, accessKey)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, accessKey)))
+	})
+}
+
+// authenticateAdmin requires the requests of the admin listener to be
+// signed like S3 requests when credentials are configured. Health checks
+// are left open for load balancers.
+func (h *Handler) authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.credentials) == 0 || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		accessKey, rerr := h.verifySignature(r, time.Now())
+		if rerr == nil {
+			rerr = readSignedBody(r)
+		}
+		if rerr != nil {
+			writeHttpError(w, rerr.status, rerr.code, rerr.message)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, accessKey)))
+	})
+}
+
+// verifySignature checks the signature of a request, given in its
+// Authorization header or in the query of a presigned URL, and returns the
+// access key which signed it. The signature must cover the host, and the
+// X-Amz-Date header if the date is given in one. A request whose payload
+// hash is signed has its body checked against the hash as it is read,
+// which readSignedBody does at once.
+func (h *Handler) verifySignature(r *http.Request, now time.Time) (string, *requestError) {
+	query := r.URL.Query()
+	auth := r.Header.Get("Authorization")
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	switch {
+	case strings.HasPrefix(auth, signingAlgorithm+" "):
+		for _, part := range strings.Split(strings.TrimPrefix(auth, signingAlgorithm+" "), ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+		if credential == "" || signedHeaders == "" || signature == "" {
+			return "", &requestError{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header must give Credential, SignedHeaders and Signature"}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		date, err := time.Parse(amzDateLayout, amzDate)
+		if err != nil {
+			return "", accessDenied("AWS authentication requires a valid X-Amz-Date header")
+		}
+		if date.Sub(now) > maxClockSkew || now.Sub(date) > maxClockSkew {
+			return "", &requestError{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+		}
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return "", &requestError{http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256"}
+		}
+		if payloadHash != unsignedPayload {
+			if _, err := hex.DecodeString(payloadHash); err != nil || len(payloadHash) != sha256.Size*2 {
+				return "", &requestError{http.StatusNotImplemented, "NotImplemented", "Only signed and unsigned payloads are supported, not " + payloadHash}
+			}
+		}
+	case query.Get("X-Amz-Algorithm") == signingAlgorithm:
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		date, err := time.Parse(amzDateLayout, amzDate)
+		expires, expiresErr := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if credential == "" || signedHeaders == "" || signature == "" || err != nil || expiresErr != nil || expires <= 0 || expires > maxPresignExpires {
+			return "", &requestError{http.StatusBadRequest, "AuthorizationQueryParametersError", "A presigned URL must give X-Amz-Credential, X-Amz-Date, X-Amz-Expires of at most 604800 seconds, X-Amz-SignedHeaders and X-Amz-Signature"}
+		}
+		if now.After(date.Add(time.Duration(expires) * time.Second)) {
+			return "", accessDenied("Request has expired")
+		}
+		query.Del("X-Amz-Signature")
+		payloadHash = unsignedPayload
+	case auth != "" || query.Has("AWSAccessKeyId"):
+		return "", &requestError{http.StatusBadRequest, "InvalidRequest", "The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256."}
+	default:
+		return "", accessDenied("Access Denied")
+	}
+
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[1] != amzDate[:8] || scope[3] != "s3" || scope[4] != "aws4_request" {
+		return "", &requestError{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The credential must be ACCESSKEY/" + amzDate[:8] + "/REGION/s3/aws4_request"}
+	}
+	secretKey, ok := h.credentials[scope[0]]
+	if !ok {
+		return "", &requestError{http.StatusForbidden, "InvalidAccessKeyId", "The access key " + scope[0] + " is not known"}
+	}
+
+	names := strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(names) {
+		return "", &requestError{http.StatusBadRequest, "AuthorizationHeaderMalformed", "SignedHeaders must be sorted"}
+	}
+	required := []string{"host"}
+	if auth != "" {
+		required = append(required, "x-amz-date")
+	}
+	for _, name := range required {
+		if !slices.Contains(names, name) {
+			return "", accessDenied("There were headers present in the request which were not signed: " + name)
+		}
+	}
+	var headers strings.Builder
+	for _, name := range names {
+		value := r.Host
+		if name != "host" {
+			var values []string
+			for _, v := range r.Header.Values(name) {
+				values = append(values, strings.TrimSpace(v))
+			}
+			value = strings.Join(values, ",")
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	canonicalRequest := r.Method + "\n" +
+		canonicalPath(r) + "\n" +
+		canonicalQuery(query) + "\n" +
+		headers.String() + "\n" +
+		signedHeaders + "\n" +
+		payloadHash
+	stringToSign := signingAlgorithm + "\n" + amzDate + "\n" + strings.Join(scope[1:], "/") + "\n" + sha256Hex(canonicalRequest)
+	expected := hex.EncodeToString(hmacSHA256(signingKey(secretKey, scope[1:]), stringToSign))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", &requestError{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+	}
+
+	if payloadHash != unsignedPayload && r.Body != nil {
+		r.Body = &payloadHashReader{ReadCloser: r.Body, hash: sha256.New(), want: payloadHash}
+	}
+	return scope[0], nil
+}
+
+// readSignedBody reads a body whose payload hash is signed and checks it
+// before the request is served, since handlers decoding a document stop
+// reading at its end. Only object content, which is checked before the
+// object is committed, may be larger than maxSignedBodySize.
+func readSignedBody(r *http.Request) *requestError {
+	hashed, ok := r.Body.(*payloadHashReader)
+	if !ok {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(hashed, maxSignedBodySize+1))
+	var rerr *requestError
+	switch {
+	case errors.As(err, &rerr):
+		return rerr
+	case err != nil:
+		return &requestError{http.StatusBadRequest, "IncompleteBody", "The request body could not be read"}
+	case len(body) > maxSignedBodySize:
+		return &requestError{http.StatusBadRequest, "MaxMessageLengthExceeded", "Your request was too big"}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// signingKey derives the key of an AWS Signature Version 4 from a secret
+// key and the date, region and service of the credential scope.
+func signingKey(secretKey string, scope []string) []byte {
+	key := []byte("AWS4" + secretKey)
+	for _, part := range scope {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalPath returns the path of a request as it was signed. The path
+// is taken from the request line, since virtual-hosted-style requests are
+// readdressed before they are authenticated.
+func canonicalPath(r *http.Request) string {
+	path := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		path = u.Path
+	}
+	return uriEscape(path, true)
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEscape(key, false)+"="+uriEscape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEscape percent-encodes s as AWS Signature Version 4 requires:
+// everything but unreserved characters is encoded, and so is '/' unless
+// keepSlash is set.
+func uriEscape(s string, keepSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			sb.WriteByte(c)
+		default:
+			sb.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return sb.String()
+}
+
+// payloadHashReader reads the body of a request whose SHA-256 was signed,
+// and fails at the end of the body if it does not match.
+type payloadHashReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want string
+}
+
+func (pr *payloadHashReader) Read(p []byte) (int, error) {
+	n, err := pr.ReadCloser.Read(p)
+	pr.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(pr.hash.Sum(nil)) != pr.want {
+		return n, &requestError{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed"}
+	}
+	return n, err
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"triple-s/storage"
+)
+
+const (
+	testAccessKey = "AKIAEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG"
+)
+
+// newTestHandler returns a handler requiring signatures by testAccessKey
+// over a memory store with a bucket photos holding cat.jpg and a snapshot
+// before.
+func newTestHandler(t *testing.T) (*Handler, *storage.Store) {
+	t.Helper()
+	store, err := storage.New(storage.NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.CreateBucket("photos", false)
+	if err == nil {
+		_, err = store.CreateSnapshot("photos", "before")
+	}
+	if err == nil {
+		_, err = store.PutObject("photos", "cat.jpg", strings.NewReader("meow"), storage.PutOptions{})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(store, Options{Credentials: map[string]string{testAccessKey: testSecretKey}}), store
+}
+
+// signRequest signs r with testSecretKey in its Authorization header,
+// covering the headers named in signed and the payload hash.
+func signRequest(r *http.Request, signed []string, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateLayout)
+	scope := []string{amzDate[:8], "us-east-1", "s3", "aws4_request"}
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	canonicalRequest := r.Method + "\n" + uriEscape(r.URL.Path, true) + "\n" + canonicalQuery(r.URL.Query()) + "\n" +
+		headers.String() + "\n" + strings.Join(signed, ";") + "\n" + payloadHash
+	stringToSign := signingAlgorithm + "\n" + amzDate + "\n" + strings.Join(scope, "/") + "\n" + sha256Hex(canonicalRequest)
+	signature := hex.EncodeToString(hmacSHA256(signingKey(testSecretKey, scope), stringToSign))
+	r.Header.Set("Authorization", signingAlgorithm+" Credential="+testAccessKey+"/"+strings.Join(scope, "/")+
+		", SignedHeaders="+strings.Join(signed, ";")+", Signature="+signature)
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestSignedRequest(t *testing.T) {
+	h, _ := newTestHandler(t)
+	r := httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+	signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, unsignedPayload, time.Now())
+	if w := serve(h, r); w.Code != http.StatusOK || w.Body.String() != "meow" {
+		t.Errorf("signed GET = %d %q, want 200 meow", w.Code, w.Body.String())
+	}
+	r = httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+	if w := serve(h, r); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned GET = %d, want 403", w.Code)
+	}
+}
+
+func TestUnsignedFormPostIsOnlyAnUpload(t *testing.T) {
+	h, store := newTestHandler(t)
+	for _, target := range []string{"/photos?rollback=before", "/photos?snapshot=after", "/photos/cat.jpg"} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("key", "dog.jpg")
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, target, &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		if w := serve(h, r); w.Code != http.StatusForbidden {
+			t.Errorf("unsigned multipart POST %s = %d, want 403", target, w.Code)
+		}
+	}
+	if _, err := store.HeadObject("photos", "cat.jpg"); err != nil {
+		t.Errorf("cat.jpg after unsigned rollback: %v", err)
+	}
+	snaps, err := store.Snapshots("photos")
+	if err != nil || len(snaps) != 1 {
+		t.Errorf("snapshots after unsigned snapshot = %v, %v, want only before", snaps, err)
+	}
+}
+
+func TestSignedHeadersMustCoverHostAndDate(t *testing.T) {
+	h, _ := newTestHandler(t)
+	for _, signed := range [][]string{{"x-amz-content-sha256", "x-amz-date"}, {"host", "x-amz-content-sha256"}} {
+		r := httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+		signRequest(r, signed, unsignedPayload, time.Now())
+		if w := serve(h, r); w.Code != http.StatusForbidden {
+			t.Errorf("GET signing only %v = %d, want 403", signed, w.Code)
+		}
+	}
+}
+
+func TestSignedPayloadIsCheckedBeforeUse(t *testing.T) {
+	h, store := newTestHandler(t)
+	config := "<CompressionConfiguration><Algorithm>gzip</Algorithm></CompressionConfiguration>"
+	tests := []struct {
+		target string
+		body   string
+		signed string
+	}{
+		// The XML decoder stops at the closing element and never reads
+		// the trailer.
+		{"/photos?compression", config + "<!-- tampered -->", config},
+		{"/photos?compression", strings.Replace(config, "gzip", "", 1), config},
+		{"/photos/cat.jpg", "woof", "purr"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPut, test.target, strings.NewReader(test.body))
+		signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, sha256Hex(test.signed), time.Now())
+		if w := serve(h, r); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "XAmzContentSHA256Mismatch") {
+			t.Errorf("PUT %s with a tampered body = %d %s, want 400 XAmzContentSHA256Mismatch", test.target, w.Code, w.Body.String())
+		}
+	}
+	cfg, err := store.BucketConfig("photos")
+	if err != nil || cfg.Compression != "" {
+		t.Errorf("compression after tampered PUTs = %q, %v, want none", cfg.Compression, err)
+	}
+	_, content, err := store.GetObject("photos", "cat.jpg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	data, _ := io.ReadAll(content)
+	if string(data) != "meow" {
+		t.Errorf("cat.jpg after a tampered PUT = %q, want meow", data)
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/photos?compression", strings.NewReader(config))
+	signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, sha256Hex(config), time.Now())
+	if w := serve(h, r); w.Code != http.StatusOK {
+		t.Errorf("PUT ?compression with a matching hash = %d %s, want 200", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminRequiresSignature(t *testing.T) {
+	h, _ := newTestHandler(t)
+	admin := h.AdminHandler()
+	r := httptest.NewRequest(http.MethodGet, "/backup", nil)
+	if w := serve(admin, r); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned GET /backup = %d, want 403", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	if w := serve(admin, r); w.Code != http.StatusOK {
+		t.Errorf("unsigned GET /healthz = %d, want 200", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/backup", nil)
+	signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, unsignedPayload, time.Now())
+	if w := serve(admin, r); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-tar" {
+		t.Errorf("signed GET /backup = %d %q, want a tar archive", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestAdminQuotasRequireSignature(t *testing.T) {
+	h, store := newTestHandler(t)
+	admin := h.AdminHandler()
+	quota := `{"maxObjects": 1}`
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		r := httptest.NewRequest(method, "/quotas/users/"+testAccessKey, strings.NewReader(quota))
+		if w := serve(admin, r); w.Code != http.StatusForbidden {
+			t.Errorf("unsigned %s of a user quota = %d, want 403", method, w.Code)
+		}
+	}
+	r := httptest.NewRequest(http.MethodPut, "/quotas/buckets/photos", strings.NewReader(`{"maxObjects": 100}`))
+	signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, sha256Hex(quota), time.Now())
+	if w := serve(admin, r); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT of a bucket quota with a tampered body = %d, want 400", w.Code)
+	}
+	quotas, err := store.BucketQuotas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range quotas {
+		if status.Quota != (storage.Quota{}) {
+			t.Errorf("bucket quota after refused requests = %+v, want none", status)
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodPut, "/quotas/users/"+testAccessKey, strings.NewReader(quota))
+	signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, sha256Hex(quota), time.Now())
+	if w := serve(admin, r); w.Code != http.StatusNoContent {
+		t.Errorf("signed PUT of a user quota = %d %s, want 204", w.Code, w.Body.String())
+	}
+}
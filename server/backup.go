@@ -0,0 +1,70 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// getBackup streams a tar archive of the store made by Store.Backup while
+// requests keep being served. With ?compression=gzip the archive is
+// gzip-compressed. Objects left out because their content does not match
+// are logged, and counted in the X-Backup-Skipped-Objects trailer.
+func (h *Handler) getBackup(w http.ResponseWriter, r *http.Request) {
+	name := "triple-s-" + time.Now().UTC().Format("20060102T150405Z") + ".tar"
+	contentType := "application/x-tar"
+	compression := r.URL.Query().Get("compression")
+	switch compression {
+	case "":
+	case "gzip":
+		name += ".gz"
+		contentType = "application/gzip"
+	default:
+		http.Error(w, "unsupported compression "+compression, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Trailer", "X-Backup-Skipped-Objects")
+
+	out := &writeTracker{w: w}
+	var archive io.Writer = out
+	var zw *gzip.Writer
+	if compression == "gzip" {
+		zw = gzip.NewWriter(out)
+		archive = zw
+	}
+	skipped, err := h.store.Backup(archive)
+	for _, name := range skipped {
+		h.logger.Warn("object left out of backup", "object", name, "reason", "content does not match its metadata")
+	}
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		w.Header().Set("X-Backup-Skipped-Objects", strconv.Itoa(len(skipped)))
+		return
+	}
+	h.logger.Error("backup failed", "error", err)
+	if !out.written {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "backup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The status was sent already; dropping the connection keeps the
+	// client from taking the truncated archive for a complete one.
+	panic(http.ErrAbortHandler)
+}
+
+// writeTracker records whether anything was written to w.
+type writeTracker struct {
+	w       io.Writer
+	written bool
+}
+
+func (t *writeTracker) Write(p []byte) (int, error) {
+	t.written = true
+	return t.w.Write(p)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+)
+
+type compressionConfiguration struct {
+	XMLName   xml.Name `xml:"CompressionConfiguration"`
+	Algorithm string   `xml:"Algorithm"`
+}
+
+// putBucketCompression answers PUT /{BucketName}?compression. An empty
+// Algorithm stores new objects uncompressed again.
+func (h *Handler) putBucketCompression(w http.ResponseWriter, r *http.Request) {
+	var cfg compressionConfiguration
+	err := xml.NewDecoder(r.Body).Decode(&cfg)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "Could not parse CompressionConfiguration")
+		return
+	}
+	err = h.store.SetBucketCompression(r.PathValue("BucketName"), cfg.Algorithm)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func (h *Handler) getBucketCompression(w http.ResponseWriter, r *http.Request) {
+	cfg, err := h.store.BucketConfig(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	output, _ := xml.MarshalIndent(compressionConfiguration{Algorithm: cfg.Compression}, "", "\t")
+	fmt.Fprintln(w, string(output))
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"triple-s/storage"
+)
+
+type corsRule struct {
+	ID            string   `xml:"ID,omitempty"`
+	AllowedOrigin []string `xml:"AllowedOrigin"`
+	AllowedMethod []string `xml:"AllowedMethod"`
+	AllowedHeader []string `xml:"AllowedHeader,omitempty"`
+	ExposeHeader  []string `xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds int      `xml:"MaxAgeSeconds,omitempty"`
+}
+
+type corsConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	Rules   []corsRule `xml:"CORSRule"`
+}
+
+func (h *Handler) putBucketCORS(w http.ResponseWriter, r *http.Request) {
+	var doc corsConfiguration
+	err := xml.NewDecoder(r.Body).Decode(&doc)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "Could not parse CORSConfiguration")
+		return
+	}
+	rules := make([]storage.CORSRule, 0, len(doc.Rules))
+	for _, rule := range doc.Rules {
+		rules = append(rules, storage.CORSRule{
+			ID:             rule.ID,
+			AllowedOrigins: rule.AllowedOrigin,
+			AllowedMethods: rule.AllowedMethod,
+			AllowedHeaders: rule.AllowedHeader,
+			ExposeHeaders:  rule.ExposeHeader,
+			MaxAgeSeconds:  rule.MaxAgeSeconds,
+		})
+	}
+	err = h.store.SetBucketCORS(r.PathValue("BucketName"), rules)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func (h *Handler) getBucketCORS(w http.ResponseWriter, r *http.Request) {
+	cfg, err := h.store.BucketConfig(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if cfg.CORS == nil {
+		writeStoreError(w, storage.ErrNoCORSConfig)
+		return
+	}
+
+	var doc corsConfiguration
+	for _, rule := range cfg.CORS {
+		doc.Rules = append(doc.Rules, corsRule{rule.ID, rule.AllowedOrigins, rule.AllowedMethods, rule.AllowedHeaders, rule.ExposeHeaders, rule.MaxAgeSeconds})
+	}
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	output, _ := xml.MarshalIndent(doc, "", "\t")
+	fmt.Fprintln(w, string(output))
+}
+
+func (h *Handler) deleteBucketCORS(w http.ResponseWriter, r *http.Request) {
+	err := h.store.SetBucketCORS(r.PathValue("BucketName"), nil)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowOrigin sets the Access-Control-Allow-Origin of a response allowed by
+// rule: "*" if the rule allows any origin, otherwise the origin itself,
+// with credentials allowed.
+func allowOrigin(w http.ResponseWriter, rule storage.CORSRule, origin string) {
+	for _, allowed := range rule.AllowedOrigins {
+		if allowed == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			return
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+}
+
+// preflight answers the OPTIONS request a browser sends before a
+// cross-origin request, telling it whether the bucket's CORS rules allow
+// the request.
+func (h *Handler) preflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if origin == "" || method == "" {
+		writeHttpError(w, http.StatusBadRequest, "BadRequest", "Insufficient information. Origin and Access-Control-Request-Method request headers needed.")
+		return
+	}
+	var headers []string
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, strings.ToLower(header))
+		}
+	}
+
+	cfg, err := h.store.BucketConfig(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	if cfg.CORS == nil {
+		writeHttpError(w, http.StatusForbidden, "AccessForbidden", "CORSResponse: CORS is not enabled for this bucket.")
+		return
+	}
+	rule, ok := storage.MatchCORS(cfg.CORS, origin, method, headers)
+	if !ok {
+		writeHttpError(w, http.StatusForbidden, "AccessForbidden", "CORSResponse: This CORS request is not allowed.")
+		return
+	}
+
+	allowOrigin(w, rule, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if rule.MaxAgeSeconds > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAgeSeconds))
+	}
+}
+
+// cors adds the Access-Control headers allowed by the bucket's CORS rules
+// to the responses to cross-origin requests. Requests not allowed are
+// served without them, which makes browsers withhold the response.
+func (h *Handler) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		bucket, _ := splitPath(r.URL.Path)
+		if origin == "" || bucket == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cfg, err := h.store.BucketConfig(bucket)
+		if err != nil || cfg.CORS == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		rule, ok := storage.MatchCORS(cfg.CORS, origin, r.Method, nil)
+		if ok {
+			allowOrigin(w, rule, origin)
+			if len(rule.ExposeHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
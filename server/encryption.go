@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+
+	"triple-s/storage"
+)
+
+const (
+	sseHeader            = "x-amz-server-side-encryption"
+	sseCustomerAlgorithm = "x-amz-server-side-encryption-customer-algorithm"
+	sseCustomerKey       = "x-amz-server-side-encryption-customer-key"
+	sseCustomerKeyMD5    = "x-amz-server-side-encryption-customer-key-MD5"
+)
+
+// requestEncryption reads the SSE-S3 or SSE-C headers of a request. It
+// answers with an error and returns false if they are not valid.
+func requestEncryption(w http.ResponseWriter, r *http.Request) (storage.Encryption, bool) {
+	var enc storage.Encryption
+	mode := r.Header.Get(sseHeader)
+	if mode != "" && mode != storage.EncryptionAES256 {
+		writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "Server-side encryption must be AES256")
+		return enc, false
+	}
+	enc.Mode = mode
+
+	algorithm := r.Header.Get(sseCustomerAlgorithm)
+	if algorithm == "" && r.Header.Get(sseCustomerKey) == "" {
+		return enc, true
+	}
+	if mode != "" {
+		writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "Server-side encryption with a customer-provided key excludes x-amz-server-side-encryption")
+		return enc, false
+	}
+	if algorithm != storage.EncryptionAES256 {
+		writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "The customer-provided key algorithm must be AES256")
+		return enc, false
+	}
+	key, err := base64.StdEncoding.DecodeString(r.Header.Get(sseCustomerKey))
+	if err != nil || len(key) != 32 {
+		writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "The customer-provided key must be 256 bits, base64-encoded")
+		return enc, false
+	}
+	digest := md5.Sum(key)
+	if r.Header.Get(sseCustomerKeyMD5) != base64.StdEncoding.EncodeToString(digest[:]) {
+		writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "The MD5 digest of the customer-provided key does not match")
+		return enc, false
+	}
+	return storage.Encryption{Mode: storage.EncryptionCustomer, CustomerKey: key}, true
+}
+
+// setEncryptionHeaders describes how an object is encrypted at rest.
+func setEncryptionHeaders(w http.ResponseWriter, r *http.Request, obj storage.Object) {
+	switch obj.Encryption {
+	case storage.EncryptionAES256:
+		w.Header().Set(sseHeader, storage.EncryptionAES256)
+	case storage.EncryptionCustomer:
+		w.Header().Set(sseCustomerAlgorithm, storage.EncryptionAES256)
+		w.Header().Set(sseCustomerKeyMD5, r.Header.Get(sseCustomerKeyMD5))
+	}
+}
+
+type encryptionByDefault struct {
+	SSEAlgorithm string `xml:"SSEAlgorithm"`
+}
+
+type serverSideEncryptionConfiguration struct {
+	XMLName xml.Name `xml:"ServerSideEncryptionConfiguration"`
+	Rule    struct {
+		ApplyServerSideEncryptionByDefault *encryptionByDefault `xml:"ApplyServerSideEncryptionByDefault"`
+		// RequireEncryption is not part of the S3 API, which rejects
+		// unencrypted uploads with bucket policies instead.
+		RequireEncryption bool `xml:"RequireEncryption,omitempty"`
+	} `xml:"Rule"`
+}
+
+func (h *Handler) putBucketEncryption(w http.ResponseWriter, r *http.Request) {
+	var doc serverSideEncryptionConfiguration
+	err := xml.NewDecoder(r.Body).Decode(&doc)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "Could not parse ServerSideEncryptionConfiguration")
+		return
+	}
+	cfg := &storage.EncryptionConfig{Require: doc.Rule.RequireEncryption}
+	if doc.Rule.ApplyServerSideEncryptionByDefault != nil {
+		cfg.Default = doc.Rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm
+	}
+	err = h.store.SetBucketEncryption(r.PathValue("BucketName"), cfg)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func (h *Handler) getBucketEncryption(w http.ResponseWriter, r *http.Request) {
+	cfg, err := h.store.BucketConfig(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if cfg.Encryption == nil {
+		writeStoreError(w, storage.ErrNoEncryptionConfig)
+		return
+	}
+
+	var doc serverSideEncryptionConfiguration
+	if cfg.Encryption.Default != "" {
+		doc.Rule.ApplyServerSideEncryptionByDefault = &encryptionByDefault{cfg.Encryption.Default}
+	}
+	doc.Rule.RequireEncryption = cfg.Encryption.Require
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	output, _ := xml.MarshalIndent(doc, "", "\t")
+	fmt.Fprintln(w, string(output))
+}
+
+func (h *Handler) deleteBucketEncryption(w http.ResponseWriter, r *http.Request) {
+	err := h.store.SetBucketEncryption(r.PathValue("BucketName"), nil)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,554 @@
+// Package server exposes a storage.Store over the S3-style HTTP API.
+package server
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"triple-s/storage"
+)
+
+type Options struct {
+	// Logger receives one access log record per request. Nil discards
+	// them.
+	Logger *slog.Logger
+	// MaxObjectSize is the largest accepted object in bytes; 0 means no
+	// limit.
+	MaxObjectSize int64
+	// RateLimits limit the request rate; requests over a limit are
+	// answered with SlowDown.
+	RateLimits RateLimits
+	// MaxConcurrentUploads limits the object uploads served at once; 0
+	// means no limit.
+	MaxConcurrentUploads int
+	// Credentials are the secret keys of the access keys, by access key.
+	// When there are any, requests must be signed by one of them with AWS
+	// Signature Version 4; otherwise requests are served anonymously.
+	Credentials map[string]string
+	// Domain is the base domain of virtual-hosted-style requests, which
+	// address a bucket as <bucket>.<Domain>. Empty allows only path-style
+	// requests.
+	Domain string
+	// WebsiteDomain is the domain under which WebsiteHandler serves
+	// buckets as <bucket>.<WebsiteDomain>.
+	WebsiteDomain string
+}
+
+// Handler serves the S3 API of a store. It also collects the access logs
+// and metrics of the requests it serves.
+type Handler struct {
+	store         *storage.Store
+	logger        *slog.Logger
+	maxObjectSize int64
+	credentials   map[string]string
+	domain        string
+	websiteDomain string
+	handler       http.Handler
+	logs          *logDelivery
+	metrics       *requestMetrics
+	shuttingDown  atomic.Bool
+
+	accessKeyLimiter *rateLimiter
+	ipLimiter        *rateLimiter
+	bucketLimiter    *rateLimiter
+	// uploads holds a token per upload being served.
+	uploads chan struct{}
+}
+
+func NewHandler(store *storage.Store, opts Options) *Handler {
+	h := &Handler{
+		store:         store,
+		logger:        opts.Logger,
+		maxObjectSize: opts.MaxObjectSize,
+		credentials:   opts.Credentials,
+		domain:        strings.ToLower(opts.Domain),
+		websiteDomain: strings.ToLower(opts.WebsiteDomain),
+		logs:          newLogDelivery(),
+		metrics:       newRequestMetrics(),
+
+		accessKeyLimiter: newRateLimiter(opts.RateLimits.PerAccessKey),
+		ipLimiter:        newRateLimiter(opts.RateLimits.PerIP),
+		bucketLimiter:    newRateLimiter(opts.RateLimits.PerBucket),
+	}
+	if opts.MaxConcurrentUploads > 0 {
+		h.uploads = make(chan struct{}, opts.MaxConcurrentUploads)
+	}
+	if h.logger == nil {
+		h.logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.badRequest)
+
+	mux.HandleFunc("GET /{$}", h.getBuckets)
+
+	mux.HandleFunc("GET /{BucketName}", h.getBucket)
+	mux.HandleFunc("GET /{BucketName}/{$}", h.getBucket)
+
+	mux.HandleFunc("PUT /{BucketName}", h.putBucket)
+	mux.HandleFunc("PUT /{BucketName}/{$}", h.putBucket)
+
+	mux.HandleFunc("POST /{BucketName}", h.postBucket)
+	mux.HandleFunc("POST /{BucketName}/{$}", h.postBucket)
+
+	mux.HandleFunc("DELETE /{BucketName}", h.deleteBucket)
+	mux.HandleFunc("DELETE /{BucketName}/{$}", h.deleteBucket)
+
+	mux.HandleFunc("GET /{BucketName}/{ObjectKey...}", h.getObject)
+	mux.HandleFunc("PUT /{BucketName}/{ObjectKey...}", h.putObject)
+	mux.HandleFunc("DELETE /{BucketName}/{ObjectKey...}", h.deleteObject)
+
+	mux.HandleFunc("OPTIONS /{BucketName}", h.preflight)
+	mux.HandleFunc("OPTIONS /{BucketName}/{ObjectKey...}", h.preflight)
+
+	h.handler = h.virtualHost(h.instrument(h.limit(h.authenticate(h.limitAccessKey(h.cors(mux))))))
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+func writeHttpError(w http.ResponseWriter, code int, errorCode string, message string) {
+	if rec, ok := w.(*responseRecorder); ok {
+		rec.errorCode = errorCode
+	}
+	w.WriteHeader(code)
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	fmt.Fprintln(w, "<Error>")
+	fmt.Fprintln(w, "\t<Code>"+errorCode+"</Code>")
+	fmt.Fprintln(w, "\t<Message>")
+	fmt.Fprintln(w, "\t\t"+xmlText(message))
+	fmt.Fprintln(w, "\t</Message>")
+	fmt.Fprintln(w, "</Error>")
+}
+
+// xmlText escapes a value for use as XML character data.
+func xmlText(value string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(value))
+	return sb.String()
+}
+
+// errorStatus maps storage error codes to HTTP status codes. Codes not
+// listed are internal errors.
+var errorStatus = map[string]int{
+	storage.ErrInvalidBucketName.Code: http.StatusBadRequest,
+	storage.ErrBucketExists.Code:      http.StatusConflict,
+	storage.ErrBucketNotFound.Code:    http.StatusNotFound,
+	storage.ErrBucketNotEmpty.Code:    http.StatusConflict,
+	storage.ErrInvalidObjectKey.Code:  http.StatusBadRequest,
+	storage.ErrMetadataAccess.Code:    http.StatusForbidden,
+	storage.ErrObjectNotFound.Code:    http.StatusNotFound,
+	storage.ErrInvalidLogTarget.Code:  http.StatusBadRequest,
+	storage.ErrInvalidArgument.Code:   http.StatusBadRequest,
+
+	storage.ErrInvalidSnapshotName.Code: http.StatusBadRequest,
+	storage.ErrSnapshotExists.Code:      http.StatusConflict,
+	storage.ErrSnapshotNotFound.Code:    http.StatusNotFound,
+
+	storage.ErrEncryptionNotConfigured.Code: http.StatusNotImplemented,
+	storage.ErrInvalidEncryptionKey.Code:    http.StatusBadRequest,
+	storage.ErrCustomerKeyRequired.Code:     http.StatusBadRequest,
+	storage.ErrCustomerKeyMismatch.Code:     http.StatusForbidden,
+	storage.ErrEncryptionRequired.Code:      http.StatusForbidden,
+	storage.ErrNoEncryptionConfig.Code:      http.StatusNotFound,
+
+	storage.ErrObjectLocked.Code:       http.StatusForbidden,
+	storage.ErrInvalidBucketState.Code: http.StatusConflict,
+	storage.ErrNoObjectLockConfig.Code: http.StatusNotFound,
+	storage.ErrNoRetention.Code:        http.StatusNotFound,
+
+	storage.ErrQuotaExceeded.Code: http.StatusForbidden,
+
+	storage.ErrNoCORSConfig.Code:    http.StatusNotFound,
+	storage.ErrNoWebsiteConfig.Code: http.StatusNotFound,
+}
+
+// storeError returns the status, error code and message answering an
+// error returned by a store operation.
+func storeError(err error) (int, string, string) {
+	var serr *storage.Error
+	if !errors.As(err, &serr) {
+		return http.StatusInternalServerError, "InternalError", "Internal error"
+	}
+	code, ok := errorStatus[serr.Code]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	return code, serr.Code, serr.Message
+}
+
+// writeStoreError answers with the error returned by a store operation.
+func writeStoreError(w http.ResponseWriter, err error) {
+	code, errorCode, message := storeError(err)
+	writeHttpError(w, code, errorCode, message)
+}
+
+func (h *Handler) badRequest(w http.ResponseWriter, r *http.Request) {
+	writeHttpError(w, http.StatusBadRequest, "BadRequest", "Wrong http-method and/or URL-address of the request")
+}
+
+func (h *Handler) getBuckets(w http.ResponseWriter, r *http.Request) {
+	bkts, err := h.store.ListBuckets()
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	fmt.Fprintln(w, "<ListAllMyBucketsResult>")
+	fmt.Fprintln(w, "\t<Buckets>")
+	for _, bkt := range bkts {
+		fmt.Fprintln(w, "\t\t<Bucket>")
+		fmt.Fprintln(w, "\t\t\t<Name>"+bkt.Name+"</Name>")
+		fmt.Fprintln(w, "\t\t\t<CreationTime>"+bkt.CreationTime.Format(storage.TimeLayout)+"</CreationTime>")
+		fmt.Fprintln(w, "\t\t\t<LastModifiedTime>"+bkt.LastModifiedTime.Format(storage.TimeLayout)+"</LastModifiedTime>")
+		fmt.Fprintln(w, "\t\t\t<Status>"+bkt.Status+"</Status>")
+		fmt.Fprintln(w, "\t\t</Bucket>")
+	}
+	fmt.Fprintln(w, "\t</Buckets>")
+	fmt.Fprintln(w, "</ListAllMyBucketsResult>")
+}
+
+func (h *Handler) getBucket(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("logging") {
+		h.getBucketLogging(w, r)
+		return
+	}
+	if r.URL.Query().Has("compression") {
+		h.getBucketCompression(w, r)
+		return
+	}
+	if r.URL.Query().Has("encryption") {
+		h.getBucketEncryption(w, r)
+		return
+	}
+	if r.URL.Query().Has("object-lock") {
+		h.getBucketObjectLock(w, r)
+		return
+	}
+	if r.URL.Query().Has("cors") {
+		h.getBucketCORS(w, r)
+		return
+	}
+	if r.URL.Query().Has("website") {
+		h.getBucketWebsite(w, r)
+		return
+	}
+	if r.URL.Query().Has("snapshots") {
+		h.listSnapshots(w, r)
+		return
+	}
+	h.listObjects(w, r)
+}
+
+func (h *Handler) putBucket(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("logging") {
+		h.putBucketLogging(w, r)
+		return
+	}
+	if r.URL.Query().Has("compression") {
+		h.putBucketCompression(w, r)
+		return
+	}
+	if r.URL.Query().Has("encryption") {
+		h.putBucketEncryption(w, r)
+		return
+	}
+	if r.URL.Query().Has("object-lock") {
+		h.putBucketObjectLock(w, r)
+		return
+	}
+	if r.URL.Query().Has("cors") {
+		h.putBucketCORS(w, r)
+		return
+	}
+	if r.URL.Query().Has("website") {
+		h.putBucketWebsite(w, r)
+		return
+	}
+
+	objectLock := strings.EqualFold(r.Header.Get(bucketObjectLockHeader), "true")
+	bkt, err := h.store.CreateBucket(r.PathValue("BucketName"), objectLock)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	fmt.Fprintln(w, "<CreateBucketResult>")
+	fmt.Fprintln(w, "\t<Name>"+bkt.Name+"</Name>")
+	fmt.Fprintln(w, "\t<CreationTime>"+bkt.CreationTime.Format(storage.TimeLayout)+"</CreationTime>")
+	fmt.Fprintln(w, "\t<Status>"+bkt.Status+"</Status>")
+	fmt.Fprintln(w, "</CreateBucketResult>")
+}
+
+func (h *Handler) deleteBucket(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("snapshot") {
+		h.deleteSnapshot(w, r)
+		return
+	}
+	if r.URL.Query().Has("encryption") {
+		h.deleteBucketEncryption(w, r)
+		return
+	}
+	if r.URL.Query().Has("cors") {
+		h.deleteBucketCORS(w, r)
+		return
+	}
+	if r.URL.Query().Has("website") {
+		h.deleteBucketWebsite(w, r)
+		return
+	}
+	err := h.store.DeleteBucket(r.PathValue("BucketName"))
+	h.logs.invalidate(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listObjects answers GET /{BucketName} with a ListObjectsV2 result. With
+// the snapshot parameter it lists the objects of that snapshot.
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := storage.ListOptions{
+		Prefix:     query.Get("prefix"),
+		Delimiter:  query.Get("delimiter"),
+		StartAfter: query.Get("start-after"),
+		Snapshot:   query.Get("snapshot"),
+	}
+	if query.Has("max-keys") {
+		maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+		if err != nil || maxKeys < 0 {
+			writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer")
+			return
+		}
+		opts.MaxKeys = min(maxKeys, 1000)
+	}
+	if token := query.Get("continuation-token"); token != "" {
+		startAfter, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "The continuation token is not valid")
+			return
+		}
+		opts.StartAfter = string(startAfter)
+	}
+
+	result, err := h.store.ListObjects(r.PathValue("BucketName"), opts)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	fmt.Fprintln(w, "<ListBucketResult>")
+	fmt.Fprintln(w, "\t<Name>"+r.PathValue("BucketName")+"</Name>")
+	fmt.Fprintln(w, "\t<Prefix>"+xmlText(opts.Prefix)+"</Prefix>")
+	if opts.Delimiter != "" {
+		fmt.Fprintln(w, "\t<Delimiter>"+xmlText(opts.Delimiter)+"</Delimiter>")
+	}
+	fmt.Fprintln(w, "\t<KeyCount>"+strconv.Itoa(len(result.Objects)+len(result.CommonPrefixes))+"</KeyCount>")
+	fmt.Fprintln(w, "\t<IsTruncated>"+strconv.FormatBool(result.IsTruncated)+"</IsTruncated>")
+	if token := query.Get("continuation-token"); token != "" {
+		fmt.Fprintln(w, "\t<ContinuationToken>"+xmlText(token)+"</ContinuationToken>")
+	}
+	if result.IsTruncated {
+		fmt.Fprintln(w, "\t<NextContinuationToken>"+base64.RawURLEncoding.EncodeToString([]byte(result.NextStartAfter))+"</NextContinuationToken>")
+	}
+	for _, obj := range result.Objects {
+		fmt.Fprintln(w, "\t<Contents>")
+		fmt.Fprintln(w, "\t\t<Key>"+xmlText(obj.Key)+"</Key>")
+		fmt.Fprintln(w, "\t\t<Size>"+strconv.FormatInt(obj.Size, 10)+"</Size>")
+		fmt.Fprintln(w, "\t\t<ContentType>"+xmlText(obj.ContentType)+"</ContentType>")
+		fmt.Fprintln(w, "\t\t<LastModified>"+obj.LastModified.Format(storage.TimeLayout)+"</LastModified>")
+		if obj.ETag != "" {
+			fmt.Fprintln(w, "\t\t<ETag>"+xmlText("\""+obj.ETag+"\"")+"</ETag>")
+		}
+		fmt.Fprintln(w, "\t</Contents>")
+	}
+	for _, prefix := range result.CommonPrefixes {
+		fmt.Fprintln(w, "\t<CommonPrefixes>")
+		fmt.Fprintln(w, "\t\t<Prefix>"+xmlText(prefix)+"</Prefix>")
+		fmt.Fprintln(w, "\t</CommonPrefixes>")
+	}
+	fmt.Fprintln(w, "</ListBucketResult>")
+}
+
+// objectKey returns the object key addressed by a request. A trailing
+// slash is ignored, so /bucket/key/ addresses the same object as
+// /bucket/key.
+func objectKey(r *http.Request) string {
+	return strings.TrimSuffix(r.PathValue("ObjectKey"), "/")
+}
+
+// setObjectHeaders describes an object in the response headers.
+func setObjectHeaders(w http.ResponseWriter, obj storage.Object) {
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+	if obj.ETag != "" {
+		w.Header().Set("ETag", "\""+obj.ETag+"\"")
+	}
+}
+
+// getObject answers GET /{BucketName}/{ObjectKey}, reading the object from
+// a snapshot if the snapshot parameter names one.
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("retention") {
+		h.getObjectRetention(w, r)
+		return
+	}
+	if r.URL.Query().Has("legal-hold") {
+		h.getObjectLegalHold(w, r)
+		return
+	}
+	enc, ok := requestEncryption(w, r)
+	if !ok {
+		return
+	}
+	var obj storage.Object
+	var content io.ReadSeekCloser
+	var err error
+	if snapshotName := r.URL.Query().Get("snapshot"); snapshotName != "" {
+		obj, content, err = h.store.GetSnapshotObject(r.PathValue("BucketName"), snapshotName, objectKey(r), enc.CustomerKey)
+	} else {
+		obj, content, err = h.store.GetObject(r.PathValue("BucketName"), objectKey(r), enc.CustomerKey)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	defer content.Close()
+	serveObject(w, r, obj, content)
+}
+
+// serveObject sends the content of an object. A compressed object is sent
+// as it is stored to clients accepting its encoding and decompressed for
+// others and for range requests.
+func serveObject(w http.ResponseWriter, r *http.Request, obj storage.Object, content io.ReadSeeker) {
+	setObjectHeaders(w, obj)
+	setEncryptionHeaders(w, r, obj)
+	setLockHeaders(w, obj)
+	var body io.ReadSeeker = content
+	if encoded, ok := content.(storage.EncodedContent); ok {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Header.Get("Range") == "" && acceptsEncoding(r, encoded.Encoding()) {
+			// ServeContent leaves Content-Length alone for encoded
+			// responses.
+			w.Header().Set("Content-Encoding", encoded.Encoding())
+			w.Header().Set("Content-Length", strconv.FormatInt(encoded.EncodedSize(), 10))
+			body = encoded.Encoded()
+		}
+	}
+	// ServeContent sets Content-Length and answers range and conditional
+	// requests.
+	http.ServeContent(w, r, "", obj.LastModified, body)
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of a request
+// allows a response in the given content coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if !strings.EqualFold(strings.TrimSpace(name), coding) {
+				continue
+			}
+			q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !found {
+				return true
+			}
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+	}
+	return false
+}
+
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request) {
+	if rejectSnapshotWrite(w, r) {
+		return
+	}
+	if r.URL.Query().Has("retention") {
+		h.putObjectRetention(w, r)
+		return
+	}
+	if r.URL.Query().Has("legal-hold") {
+		h.putObjectLegalHold(w, r)
+		return
+	}
+	if h.maxObjectSize > 0 {
+		if r.ContentLength > h.maxObjectSize {
+			writeHttpError(w, http.StatusBadRequest, "EntityTooLarge", "Object is larger than the maximum allowed size")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxObjectSize)
+	}
+	// Only a verified access key is charged, so that a request cannot use
+	// up the quota of another user by naming its key.
+	opts := storage.PutOptions{
+		ContentType: r.Header.Get("Content-Type"),
+		Owner:       identityOf(r),
+		Size:        max(r.ContentLength, 0),
+	}
+	obj, ok := h.storeObject(w, r, r.PathValue("BucketName"), objectKey(r), r.Body, opts)
+	if !ok {
+		return
+	}
+	setEncryptionHeaders(w, r, obj)
+	setLockHeaders(w, obj)
+	w.Header().Set("ETag", "\""+obj.ETag+"\"")
+}
+
+// storeObject uploads an object with opts completed by the encryption and
+// Object Lock headers of r. It answers with an error and returns false if
+// the upload fails.
+func (h *Handler) storeObject(w http.ResponseWriter, r *http.Request, bucketName string, objectKey string, body io.Reader, opts storage.PutOptions) (storage.Object, bool) {
+	enc, ok := requestEncryption(w, r)
+	if !ok {
+		return storage.Object{}, false
+	}
+	opts.Encryption = enc
+	if !requestLock(w, r, &opts) {
+		return storage.Object{}, false
+	}
+
+	obj, err := h.store.PutObject(bucketName, objectKey, body, opts)
+	var tooLarge *http.MaxBytesError
+	var rerr *requestError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeHttpError(w, http.StatusBadRequest, "EntityTooLarge", "Object is larger than the maximum allowed size")
+		return storage.Object{}, false
+	case errors.As(err, &rerr):
+		writeHttpError(w, rerr.status, rerr.code, rerr.message)
+		return storage.Object{}, false
+	case err != nil:
+		writeStoreError(w, err)
+		return storage.Object{}, false
+	}
+	return obj, true
+}
+
+func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request) {
+	if rejectSnapshotWrite(w, r) {
+		return
+	}
+	err := h.store.DeleteObject(r.PathValue("BucketName"), objectKey(r), bypassGovernance(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// AdminHandler serves the endpoints meant for operators rather than S3
+// clients: /metrics, /healthz, /readyz, /backup, /gc, /rotate-keys and
+// /quotas. It is served on
+// its own listener so that it cannot collide with bucket names. When
+// credentials are configured, every endpoint but the health checks needs
+// a signed request.
+func (h *Handler) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", h.getMetrics)
+	mux.HandleFunc("GET /healthz", h.getHealthz)
+	mux.HandleFunc("GET /readyz", h.getReadyz)
+	mux.HandleFunc("GET /backup", h.getBackup)
+	mux.HandleFunc("POST /gc", h.postGC)
+	mux.HandleFunc("POST /rotate-keys", h.postRotateKeys)
+	mux.HandleFunc("GET /quotas", h.getQuotas)
+	mux.HandleFunc("PUT /quotas/{Kind}/{Name}", h.putQuota)
+	mux.HandleFunc("DELETE /quotas/{Kind}/{Name}", h.deleteQuota)
+	return h.authenticateAdmin(mux)
+}
+
+// StartShutdown makes readiness checks fail, so that load balancers stop
+// routing requests while in-flight ones are drained.
+func (h *Handler) StartShutdown() {
+	h.shuttingDown.Store(true)
+}
+
+func (h *Handler) getHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// getReadyz checks that the server is not shutting down, that the bucket
+// metadata is readable and that the data directory is writable.
+func (h *Handler) getReadyz(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		http.Error(w, "not ready: shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	err := h.store.Check()
+	if err != nil {
+		http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// postGC removes unreferenced blobs now rather than at the next scheduled
+// collection.
+func (h *Handler) postGC(w http.ResponseWriter, r *http.Request) {
+	removed, freed, err := h.store.CollectGarbage()
+	if err != nil {
+		http.Error(w, "could not collect blobs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "removed %d blobs, %d bytes\n", removed, freed)
+}
+
+// postRotateKeys wraps the data keys of SSE-S3 objects with the current
+// master key, after which older keys can be removed from the keyring.
+func (h *Handler) postRotateKeys(w http.ResponseWriter, r *http.Request) {
+	rotated, err := h.store.RotateKeys()
+	if err != nil {
+		http.Error(w, "could not rotate keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "rewrapped %d data keys\n", rotated)
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"triple-s/storage"
+)
+
+type loggingEnabled struct {
+	TargetBucket string `xml:"TargetBucket"`
+	TargetPrefix string `xml:"TargetPrefix"`
+}
+
+type bucketLoggingStatus struct {
+	XMLName        xml.Name        `xml:"BucketLoggingStatus"`
+	LoggingEnabled *loggingEnabled `xml:"LoggingEnabled"`
+}
+
+// logDelivery batches S3 server access log records until they are written
+// into their target buckets.
+type logDelivery struct {
+	mu      sync.Mutex
+	pending map[storage.LoggingConfig][]string
+	// configs caches the logging configuration of existing buckets, nil
+	// where logging is disabled, so that requests need not read it. It is
+	// invalidated whenever a bucket's logging configuration changes or
+	// the bucket is deleted; generation counts the invalidations, so that
+	// a configuration read before one is not cached after it.
+	configs    map[string]*storage.LoggingConfig
+	generation uint64
+}
+
+func newLogDelivery() *logDelivery {
+	return &logDelivery{
+		pending: make(map[storage.LoggingConfig][]string),
+		configs: make(map[string]*storage.LoggingConfig),
+	}
+}
+
+// add queues a record if the bucket it concerns has logging enabled.
+func (d *logDelivery) add(store *storage.Store, rec *accessRecord) {
+	if rec.bucket == "" {
+		return
+	}
+	cfg, ok := d.config(store, rec.bucket)
+	if !ok || cfg == nil {
+		return
+	}
+	line := rec.serverLogLine()
+	d.mu.Lock()
+	d.pending[*cfg] = append(d.pending[*cfg], line)
+	d.mu.Unlock()
+}
+
+// config returns the logging configuration of a bucket, and false if the
+// bucket cannot be read. Missing buckets are not cached, so that requests
+// for made-up names take no memory.
+func (d *logDelivery) config(store *storage.Store, bucketName string) (*storage.LoggingConfig, bool) {
+	d.mu.Lock()
+	cfg, ok := d.configs[bucketName]
+	generation := d.generation
+	d.mu.Unlock()
+	if ok {
+		return cfg, true
+	}
+
+	bc, err := store.BucketConfig(bucketName)
+	if err != nil {
+		return nil, false
+	}
+	d.mu.Lock()
+	if d.generation == generation {
+		d.configs[bucketName] = bc.Logging
+	}
+	d.mu.Unlock()
+	return bc.Logging, true
+}
+
+// invalidate drops the cached logging configuration of a bucket.
+func (d *logDelivery) invalidate(bucketName string) {
+	d.mu.Lock()
+	delete(d.configs, bucketName)
+	d.generation++
+	d.mu.Unlock()
+}
+
+// flush writes every queued batch as a new object in its target bucket.
+// Batches that cannot be written are dropped and reported to the logger.
+func (d *logDelivery) flush(store *storage.Store, logger *slog.Logger) {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = make(map[storage.LoggingConfig][]string)
+	d.mu.Unlock()
+
+	for target, lines := range pending {
+		body := strings.Join(lines, "\n") + "\n"
+		objectKey := target.TargetPrefix + time.Now().UTC().Format("2006-01-02-15-04-05") + "-" + newRequestId()
+		_, err := store.PutObject(target.TargetBucket, objectKey, strings.NewReader(body), storage.PutOptions{ContentType: "text/plain"})
+		if err != nil {
+			logger.Error("server access log delivery failed",
+				slog.String("target_bucket", target.TargetBucket),
+				slog.String("key", objectKey),
+				slog.String("error", err.Error()),
+				slog.Int("records", len(lines)),
+			)
+		}
+	}
+}
+
+// RunLogDelivery writes the queued server access logs into their target
+// buckets every interval. When stop is closed it delivers what is left and
+// returns.
+func (h *Handler) RunLogDelivery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.logs.flush(h.store, h.logger)
+		case <-stop:
+			h.logs.flush(h.store, h.logger)
+			return
+		}
+	}
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// operation names the request in the REST.<method>.<resource> form used by
+// S3 server access logs.
+func (rec *accessRecord) operation() string {
+	resource := "BUCKET"
+	switch {
+	case rec.bucket == "":
+		resource = "SERVICE"
+	case rec.method == http.MethodOptions:
+		resource = "PREFLIGHT"
+	case rec.method == http.MethodPost && len(rec.query) == 0:
+		resource = "OBJECT"
+	case rec.key != "" && rec.query.Has("retention"):
+		resource = "RETENTION"
+	case rec.key != "" && rec.query.Has("legal-hold"):
+		resource = "LEGAL_HOLD"
+	case rec.key != "":
+		resource = "OBJECT"
+	case rec.query.Has("logging"):
+		resource = "LOGGING_STATUS"
+	case rec.query.Has("compression"):
+		resource = "COMPRESSION"
+	case rec.query.Has("encryption"):
+		resource = "ENCRYPTION"
+	case rec.query.Has("object-lock"):
+		resource = "OBJECT_LOCK_CONFIGURATION"
+	case rec.query.Has("cors"):
+		resource = "CORS"
+	case rec.query.Has("website"):
+		resource = "WEBSITE"
+	case rec.query.Has("snapshot") || rec.query.Has("snapshots") || rec.query.Has("rollback"):
+		resource = "SNAPSHOT"
+	}
+	return "REST." + rec.method + "." + resource
+}
+
+// serverLogLine formats the record in the S3 server access log format.
+func (rec *accessRecord) serverLogLine() string {
+	remoteIp, _, err := net.SplitHostPort(rec.remoteAddr)
+	if err != nil {
+		remoteIp = rec.remoteAddr
+	}
+	objectSize := "-"
+	if rec.key != "" && rec.errorCode == "" {
+		objectSize = strconv.FormatInt(rec.bytesOut, 10)
+		if rec.method == http.MethodPut || rec.method == http.MethodPost {
+			objectSize = strconv.FormatInt(rec.bytesIn, 10)
+		}
+	}
+	fields := []string{
+		"-", // bucket owner
+		rec.bucket,
+		"[" + rec.start.Format("02/Jan/2006:15:04:05 -0700") + "]",
+		dashIfEmpty(remoteIp),
+		dashIfEmpty(rec.accessKey),
+		rec.requestId,
+		rec.operation(),
+		dashIfEmpty(rec.key),
+		strconv.Quote(rec.method + " " + rec.requestUri + " " + rec.proto),
+		strconv.Itoa(rec.status),
+		dashIfEmpty(rec.errorCode),
+		strconv.FormatInt(rec.bytesOut, 10),
+		objectSize,
+		strconv.FormatInt(rec.latency.Milliseconds(), 10),
+		"-", // turn-around time
+		strconv.Quote(dashIfEmpty(rec.referer)),
+		strconv.Quote(dashIfEmpty(rec.userAgent)),
+		"-", // version id
+		"-", // host id
+		dashIfEmpty(rec.signatureVersion()),
+		dashIfEmpty(rec.cipherSuite),
+		dashIfEmpty(rec.authType()),
+		dashIfEmpty(rec.host),
+		dashIfEmpty(rec.tlsVersion),
+	}
+	return strings.Join(fields, " ")
+}
+
+func (rec *accessRecord) signatureVersion() string {
+	switch {
+	case strings.HasPrefix(rec.authorization, "AWS4-HMAC-SHA256"), rec.query.Has("X-Amz-Signature"):
+		return "SigV4"
+	case strings.HasPrefix(rec.authorization, "AWS "), rec.query.Has("Signature"):
+		return "SigV2"
+	}
+	return ""
+}
+
+func (rec *accessRecord) authType() string {
+	switch {
+	case rec.authorization != "":
+		return "AuthHeader"
+	case rec.query.Has("X-Amz-Signature"), rec.query.Has("Signature"):
+		return "QueryString"
+	}
+	return ""
+}
+
+func (h *Handler) putBucketLogging(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("BucketName")
+	_, err := h.store.Bucket(bucketName)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var status bucketLoggingStatus
+	err = xml.NewDecoder(r.Body).Decode(&status)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "Could not parse BucketLoggingStatus")
+		return
+	}
+	var cfg *storage.LoggingConfig
+	if status.LoggingEnabled != nil {
+		cfg = &storage.LoggingConfig{
+			TargetBucket: status.LoggingEnabled.TargetBucket,
+			TargetPrefix: status.LoggingEnabled.TargetPrefix,
+		}
+	}
+	err = h.store.SetBucketLogging(bucketName, cfg)
+	h.logs.invalidate(bucketName)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func (h *Handler) getBucketLogging(w http.ResponseWriter, r *http.Request) {
+	cfg, err := h.store.BucketConfig(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var status bucketLoggingStatus
+	if cfg.Logging != nil {
+		status.LoggingEnabled = &loggingEnabled{
+			TargetBucket: cfg.Logging.TargetBucket,
+			TargetPrefix: cfg.Logging.TargetPrefix,
+		}
+	}
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	output, _ := xml.MarshalIndent(status, "", "\t")
+	fmt.Fprintln(w, string(output))
+}
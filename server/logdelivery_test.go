@@ -0,0 +1,64 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"triple-s/storage"
+)
+
+func TestLogDeliveryFollowsConfiguration(t *testing.T) {
+	_, store := newTestHandler(t)
+	_, err := store.CreateBucket("logs", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(store, Options{})
+	request := func(method string, target string, body string) {
+		t.Helper()
+		w := serve(h, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if w.Code >= 300 {
+			t.Fatalf("%s %s = %d %s", method, target, w.Code, w.Body.String())
+		}
+	}
+	delivered := func() int {
+		t.Helper()
+		h.logs.flush(store, slog.Default())
+		result, err := store.ListObjects("logs", storage.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(result.Objects)
+	}
+
+	request(http.MethodGet, "/photos/cat.jpg", "")
+	if n := delivered(); n != 0 {
+		t.Fatalf("%d logs delivered before logging was enabled", n)
+	}
+	request(http.MethodPut, "/photos?logging", "<BucketLoggingStatus><LoggingEnabled><TargetBucket>logs</TargetBucket><TargetPrefix>photos-</TargetPrefix></LoggingEnabled></BucketLoggingStatus>")
+	request(http.MethodGet, "/photos/cat.jpg", "")
+	if n := delivered(); n != 1 {
+		t.Fatalf("%d logs delivered after logging was enabled, want 1", n)
+	}
+	request(http.MethodPut, "/photos?logging", "<BucketLoggingStatus></BucketLoggingStatus>")
+	request(http.MethodGet, "/photos/cat.jpg", "")
+	if n := delivered(); n != 1 {
+		t.Fatalf("%d logs delivered after logging was disabled, want 1", n)
+	}
+
+	// A bucket created again after its deletion starts without logging.
+	request(http.MethodPut, "/music", "")
+	request(http.MethodPut, "/music?logging", "<BucketLoggingStatus><LoggingEnabled><TargetBucket>logs</TargetBucket></LoggingEnabled></BucketLoggingStatus>")
+	if n := delivered(); n != 2 {
+		t.Fatalf("%d logs delivered after logging of music was enabled, want 2", n)
+	}
+	request(http.MethodDelete, "/music", "")
+	request(http.MethodPut, "/music", "")
+	request(http.MethodGet, "/music", "")
+	if n := delivered(); n != 2 {
+		t.Fatalf("%d logs delivered after music was created again, want 2", n)
+	}
+}
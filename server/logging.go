@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// responseRecorder captures what a handler sent so it can be logged
+// after the request is served.
+type responseRecorder struct {
+	http.ResponseWriter
+	status    int
+	bytesOut  int64
+	errorCode string
+	// accessKey is the verified access key of the request, set by
+	// recordIdentity.
+	accessKey string
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytesOut += int64(n)
+	return n, err
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func newRequestId() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return strings.ToUpper(hex.EncodeToString(buf))
+}
+
+// splitPath returns the bucket name and object key addressed by a
+// path-style URL.
+func splitPath(path string) (string, string) {
+	path = strings.Trim(path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	return bucket, key
+}
+
+// recordIdentity records the verified access key of a request for its
+// access log record, which is written outside of authentication.
+func recordIdentity(w http.ResponseWriter, accessKey string) {
+	if rec, ok := w.(*responseRecorder); ok {
+		rec.accessKey = accessKey
+	}
+}
+
+// accessRecord describes one served request.
+type accessRecord struct {
+	start         time.Time
+	method        string
+	bucket        string
+	key           string
+	query         url.Values
+	requestUri    string
+	proto         string
+	status        int
+	errorCode     string
+	bytesIn       int64
+	bytesOut      int64
+	latency       time.Duration
+	remoteAddr    string
+	requestId     string
+	accessKey     string
+	authorization string
+	referer       string
+	userAgent     string
+	host          string
+	tlsVersion    string
+	cipherSuite   string
+}
+
+// instrument wraps the S3 handlers so that every request is written to the
+// access log, counted in the metrics and queued for server access log
+// delivery.
+func (h *Handler) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.metrics.inFlight.Add(1)
+		defer h.metrics.inFlight.Add(-1)
+		start := time.Now()
+		requestId := newRequestId()
+		w.Header().Set("x-amz-request-id", requestId)
+
+		rec := &responseRecorder{ResponseWriter: w}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		bucket, key := splitPath(r.URL.Path)
+		record := &accessRecord{
+			start:         start,
+			method:        r.Method,
+			bucket:        bucket,
+			key:           key,
+			query:         r.URL.Query(),
+			requestUri:    r.RequestURI,
+			proto:         r.Proto,
+			status:        rec.status,
+			errorCode:     rec.errorCode,
+			bytesIn:       body.n,
+			bytesOut:      rec.bytesOut,
+			latency:       time.Since(start),
+			remoteAddr:    r.RemoteAddr,
+			requestId:     requestId,
+			accessKey:     rec.accessKey,
+			authorization: r.Header.Get("Authorization"),
+			referer:       r.Referer(),
+			userAgent:     r.UserAgent(),
+			host:          r.Host,
+		}
+		if r.TLS != nil {
+			record.tlsVersion = tls.VersionName(r.TLS.Version)
+			record.cipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case record.status >= 500:
+			level = slog.LevelError
+		case record.status >= 400:
+			level = slog.LevelWarn
+		}
+		h.logger.LogAttrs(r.Context(), level, "request",
+			slog.String("method", record.method),
+			slog.String("bucket", record.bucket),
+			slog.String("key", record.key),
+			slog.Int("status", record.status),
+			slog.String("error_code", record.errorCode),
+			slog.Int64("bytes_in", record.bytesIn),
+			slog.Int64("bytes_out", record.bytesOut),
+			slog.Duration("latency", record.latency),
+			slog.String("remote_addr", record.remoteAddr),
+			slog.String("request_id", record.requestId),
+			slog.String("access_key", record.accessKey),
+		)
+		h.metrics.observe(record)
+		h.logs.add(h.store, record)
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	_, store := newTestHandler(t)
+	var log bytes.Buffer
+	h := NewHandler(store, Options{
+		Credentials: map[string]string{testAccessKey: testSecretKey},
+		Logger:      slog.New(slog.NewJSONHandler(&log, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+	lastRecord := func() map[string]any {
+		var record map[string]any
+		lines := bytes.Split(bytes.TrimSpace(log.Bytes()), []byte("\n"))
+		if err := json.Unmarshal(lines[len(lines)-1], &record); err != nil {
+			t.Fatalf("access log %q: %v", log.String(), err)
+		}
+		return record
+	}
+
+	// A request claiming a key with a wrong signature is logged as a
+	// client error, without the claimed key.
+	r := httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+	signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, unsignedPayload, time.Now())
+	r.Header.Set("Authorization", r.Header.Get("Authorization")+"0")
+	serve(h, r)
+	record := lastRecord()
+	if record["level"] != "WARN" || record["status"] != float64(http.StatusForbidden) || record["access_key"] != "" {
+		t.Errorf("record of a request with a bad signature = %v, want a WARN without access key", record)
+	}
+
+	// Successful requests are logged at Info, below the level.
+	log.Reset()
+	r = httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+	signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, unsignedPayload, time.Now())
+	serve(h, r)
+	if log.Len() != 0 {
+		t.Errorf("successful request logged at level warn: %s", log.String())
+	}
+
+	// The verified key of a failed request is logged.
+	r = httptest.NewRequest(http.MethodGet, "/photos/dog.jpg", nil)
+	signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, unsignedPayload, time.Now())
+	serve(h, r)
+	record = lastRecord()
+	if record["status"] != float64(http.StatusNotFound) || record["access_key"] != testAccessKey {
+		t.Errorf("record of a signed request = %v, want 404 by %s", record, testAccessKey)
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"triple-s/storage"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram buckets.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range latencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+type operationStatus struct {
+	operation string
+	status    int
+}
+
+// requestMetrics accumulates request statistics exposed on /metrics.
+type requestMetrics struct {
+	mu        sync.Mutex
+	latencies map[operationStatus]*histogram
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+	inFlight  atomic.Int64
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{latencies: make(map[operationStatus]*histogram)}
+}
+
+func (m *requestMetrics) observe(rec *accessRecord) {
+	m.bytesIn.Add(rec.bytesIn)
+	m.bytesOut.Add(rec.bytesOut)
+
+	key := operationStatus{rec.apiName(), rec.status}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.latencies[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[key] = h
+	}
+	h.observe(rec.latency.Seconds())
+}
+
+// apiName names the request after the S3 API operation it performs.
+func (rec *accessRecord) apiName() string {
+	subresource := ""
+	switch {
+	case rec.query.Has("logging"):
+		subresource = "Logging"
+	case rec.query.Has("compression"):
+		subresource = "Compression"
+	case rec.query.Has("encryption"):
+		subresource = "Encryption"
+	case rec.query.Has("object-lock"):
+		subresource = "ObjectLock"
+	case rec.query.Has("cors"):
+		subresource = "Cors"
+	case rec.query.Has("website"):
+		subresource = "Website"
+	}
+	switch {
+	case rec.bucket == "" && rec.method == http.MethodGet:
+		return "ListBuckets"
+	case rec.bucket == "":
+		return "Other"
+	case rec.method == http.MethodOptions:
+		return "PreflightRequest"
+	case rec.key != "":
+		object := "Object"
+		switch {
+		case rec.query.Has("retention"):
+			object = "ObjectRetention"
+		case rec.query.Has("legal-hold"):
+			object = "ObjectLegalHold"
+		}
+		switch rec.method {
+		case http.MethodGet:
+			return "Get" + object
+		case http.MethodPut:
+			return "Put" + object
+		case http.MethodDelete:
+			return "DeleteObject"
+		}
+	case rec.method == http.MethodGet && rec.query.Has("snapshots"):
+		return "ListBucketSnapshots"
+	case rec.method == http.MethodPost && rec.query.Has("rollback"):
+		return "RollbackBucket"
+	case rec.method == http.MethodPost && rec.query.Has("snapshot"):
+		return "CreateBucketSnapshot"
+	case rec.method == http.MethodDelete && rec.query.Has("snapshot"):
+		return "DeleteBucketSnapshot"
+	case subresource != "":
+		switch rec.method {
+		case http.MethodGet:
+			return "GetBucket" + subresource
+		case http.MethodPut:
+			return "PutBucket" + subresource
+		case http.MethodDelete:
+			return "DeleteBucket" + subresource
+		}
+	default:
+		switch rec.method {
+		case http.MethodPut:
+			return "CreateBucket"
+		case http.MethodDelete:
+			return "DeleteBucket"
+		case http.MethodPost:
+			return "PostObject"
+		}
+	}
+	return "Other"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// getMetrics serves the metrics in the Prometheus text exposition format.
+func (h *Handler) getMetrics(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.store.Stats()
+	if err != nil {
+		http.Error(w, "could not read storage metadata", http.StatusInternalServerError)
+		return
+	}
+	blobs, err := h.store.BlobStats()
+	if err != nil {
+		http.Error(w, "could not read blob statistics", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.metrics.write(w, stats, blobs)
+}
+
+// write writes the metrics; blobs is nil unless the backend stores blobs.
+func (m *requestMetrics) write(w io.Writer, stats []storage.BucketStats, blobs *storage.BlobStats) {
+	m.mu.Lock()
+	keys := make([]operationStatus, 0, len(m.latencies))
+	for key := range m.latencies {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	fmt.Fprintln(w, "# HELP triples_requests_total Number of served requests by operation and status.")
+	fmt.Fprintln(w, "# TYPE triples_requests_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "triples_requests_total{operation=\"%s\",status=\"%d\"} %d\n", escapeLabel(key.operation), key.status, m.latencies[key].count)
+	}
+
+	fmt.Fprintln(w, "# HELP triples_request_duration_seconds Request latency by operation and status.")
+	fmt.Fprintln(w, "# TYPE triples_request_duration_seconds histogram")
+	for _, key := range keys {
+		h := m.latencies[key]
+		labels := fmt.Sprintf("operation=\"%s\",status=\"%d\"", escapeLabel(key.operation), key.status)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "triples_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "triples_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "triples_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "triples_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+	m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP triples_uploaded_bytes_total Bytes received in request bodies.")
+	fmt.Fprintln(w, "# TYPE triples_uploaded_bytes_total counter")
+	fmt.Fprintf(w, "triples_uploaded_bytes_total %d\n", m.bytesIn.Load())
+	fmt.Fprintln(w, "# HELP triples_downloaded_bytes_total Bytes sent in response bodies.")
+	fmt.Fprintln(w, "# TYPE triples_downloaded_bytes_total counter")
+	fmt.Fprintf(w, "triples_downloaded_bytes_total %d\n", m.bytesOut.Load())
+	fmt.Fprintln(w, "# HELP triples_inflight_requests Requests currently being served.")
+	fmt.Fprintln(w, "# TYPE triples_inflight_requests gauge")
+	fmt.Fprintf(w, "triples_inflight_requests %d\n", m.inFlight.Load())
+
+	fmt.Fprintln(w, "# HELP triples_buckets Number of buckets.")
+	fmt.Fprintln(w, "# TYPE triples_buckets gauge")
+	fmt.Fprintf(w, "triples_buckets %d\n", len(stats))
+	fmt.Fprintln(w, "# HELP triples_objects Number of objects per bucket.")
+	fmt.Fprintln(w, "# TYPE triples_objects gauge")
+	for _, bkt := range stats {
+		fmt.Fprintf(w, "triples_objects{bucket=\"%s\"} %d\n", escapeLabel(bkt.Name), bkt.Objects)
+	}
+	fmt.Fprintln(w, "# HELP triples_stored_bytes Total size of the objects stored per bucket.")
+	fmt.Fprintln(w, "# TYPE triples_stored_bytes gauge")
+	for _, bkt := range stats {
+		fmt.Fprintf(w, "triples_stored_bytes{bucket=\"%s\"} %d\n", escapeLabel(bkt.Name), bkt.Bytes)
+	}
+	fmt.Fprintln(w, "# HELP triples_disk_bytes Size of the object content as stored per bucket, after compression.")
+	fmt.Fprintln(w, "# TYPE triples_disk_bytes gauge")
+	for _, bkt := range stats {
+		fmt.Fprintf(w, "triples_disk_bytes{bucket=\"%s\"} %d\n", escapeLabel(bkt.Name), bkt.StoredBytes)
+	}
+	fmt.Fprintln(w, "# HELP triples_compression_ratio Object bytes per stored byte per bucket.")
+	fmt.Fprintln(w, "# TYPE triples_compression_ratio gauge")
+	for _, bkt := range stats {
+		fmt.Fprintf(w, "triples_compression_ratio{bucket=\"%s\"} %s\n", escapeLabel(bkt.Name), formatFloat(bkt.CompressionRatio()))
+	}
+
+	if blobs == nil {
+		return
+	}
+	fmt.Fprintln(w, "# HELP triples_blobs Number of referenced content blobs.")
+	fmt.Fprintln(w, "# TYPE triples_blobs gauge")
+	fmt.Fprintf(w, "triples_blobs %d\n", blobs.Blobs)
+	fmt.Fprintln(w, "# HELP triples_blob_stored_bytes Size of the referenced content blobs.")
+	fmt.Fprintln(w, "# TYPE triples_blob_stored_bytes gauge")
+	fmt.Fprintf(w, "triples_blob_stored_bytes %d\n", blobs.StoredBytes)
+	fmt.Fprintln(w, "# HELP triples_blob_logical_bytes Size of the object and snapshot content stored in blobs.")
+	fmt.Fprintln(w, "# TYPE triples_blob_logical_bytes gauge")
+	fmt.Fprintf(w, "triples_blob_logical_bytes %d\n", blobs.LogicalBytes)
+	fmt.Fprintln(w, "# HELP triples_dedup_ratio Logical bytes per stored byte of blob content.")
+	fmt.Fprintln(w, "# TYPE triples_dedup_ratio gauge")
+	fmt.Fprintf(w, "triples_dedup_ratio %s\n", formatFloat(blobs.Ratio()))
+}
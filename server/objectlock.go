@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"triple-s/storage"
+)
+
+const (
+	bucketObjectLockHeader = "x-amz-bucket-object-lock-enabled"
+	lockModeHeader         = "x-amz-object-lock-mode"
+	retainUntilHeader      = "x-amz-object-lock-retain-until-date"
+	legalHoldHeader        = "x-amz-object-lock-legal-hold"
+	bypassGovernanceHeader = "x-amz-bypass-governance-retention"
+)
+
+// bypassGovernance reports whether a request asks to bypass
+// governance-mode retention.
+func bypassGovernance(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(bypassGovernanceHeader), "true")
+}
+
+// requestLock reads the Object Lock headers of an upload into opts. It
+// answers with an error and returns false if they are not valid.
+func requestLock(w http.ResponseWriter, r *http.Request, opts *storage.PutOptions) bool {
+	mode, until := r.Header.Get(lockModeHeader), r.Header.Get(retainUntilHeader)
+	if (mode == "") != (until == "") {
+		writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "x-amz-object-lock-mode and x-amz-object-lock-retain-until-date must be given together")
+		return false
+	}
+	if mode != "" {
+		retainUntil, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "The retain-until date must be an ISO 8601 timestamp")
+			return false
+		}
+		opts.Retention = &storage.Retention{Mode: mode, RetainUntil: retainUntil}
+	}
+	switch r.Header.Get(legalHoldHeader) {
+	case "", "OFF":
+	case "ON":
+		opts.LegalHold = true
+	default:
+		writeHttpError(w, http.StatusBadRequest, "InvalidArgument", "Legal hold status must be ON or OFF")
+		return false
+	}
+	opts.BypassGovernance = bypassGovernance(r)
+	return true
+}
+
+// setLockHeaders describes the retention and legal hold of an object.
+func setLockHeaders(w http.ResponseWriter, obj storage.Object) {
+	if obj.LockMode != "" {
+		w.Header().Set(lockModeHeader, obj.LockMode)
+		w.Header().Set(retainUntilHeader, obj.RetainUntil.UTC().Format(time.RFC3339))
+	}
+	if obj.LegalHold {
+		w.Header().Set(legalHoldHeader, "ON")
+	}
+}
+
+type defaultRetention struct {
+	Mode  string `xml:"Mode"`
+	Days  int    `xml:"Days,omitempty"`
+	Years int    `xml:"Years,omitempty"`
+}
+
+type objectLockRule struct {
+	DefaultRetention defaultRetention `xml:"DefaultRetention"`
+}
+
+type objectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration"`
+	ObjectLockEnabled string          `xml:"ObjectLockEnabled"`
+	Rule              *objectLockRule `xml:"Rule"`
+}
+
+// putBucketObjectLock answers PUT /{BucketName}?object-lock, which sets
+// the default retention of a bucket created with Object Lock enabled. A
+// configuration without a Rule removes the default retention.
+func (h *Handler) putBucketObjectLock(w http.ResponseWriter, r *http.Request) {
+	var doc objectLockConfiguration
+	err := xml.NewDecoder(r.Body).Decode(&doc)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "Could not parse ObjectLockConfiguration")
+		return
+	}
+	if doc.ObjectLockEnabled != "Enabled" {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "ObjectLockEnabled must be Enabled")
+		return
+	}
+	var cfg storage.ObjectLockConfig
+	if doc.Rule != nil {
+		cfg.DefaultMode = doc.Rule.DefaultRetention.Mode
+		cfg.DefaultDays = doc.Rule.DefaultRetention.Days
+		cfg.DefaultYears = doc.Rule.DefaultRetention.Years
+	}
+	err = h.store.SetObjectLockConfig(r.PathValue("BucketName"), cfg)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func (h *Handler) getBucketObjectLock(w http.ResponseWriter, r *http.Request) {
+	cfg, err := h.store.BucketConfig(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if cfg.ObjectLock == nil || !cfg.ObjectLock.Enabled {
+		writeStoreError(w, storage.ErrNoObjectLockConfig)
+		return
+	}
+
+	doc := objectLockConfiguration{ObjectLockEnabled: "Enabled"}
+	if cfg.ObjectLock.DefaultMode != "" {
+		doc.Rule = &objectLockRule{defaultRetention{cfg.ObjectLock.DefaultMode, cfg.ObjectLock.DefaultDays, cfg.ObjectLock.DefaultYears}}
+	}
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	output, _ := xml.MarshalIndent(doc, "", "\t")
+	fmt.Fprintln(w, string(output))
+}
+
+type retention struct {
+	XMLName         xml.Name `xml:"Retention"`
+	Mode            string   `xml:"Mode,omitempty"`
+	RetainUntilDate string   `xml:"RetainUntilDate,omitempty"`
+}
+
+// putObjectRetention answers PUT /{BucketName}/{ObjectKey}?retention. An
+// empty Retention removes the retention of the object.
+func (h *Handler) putObjectRetention(w http.ResponseWriter, r *http.Request) {
+	var doc retention
+	err := xml.NewDecoder(r.Body).Decode(&doc)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "Could not parse Retention")
+		return
+	}
+	var ret *storage.Retention
+	if doc.Mode != "" || doc.RetainUntilDate != "" {
+		retainUntil, err := time.Parse(time.RFC3339, doc.RetainUntilDate)
+		if err != nil {
+			writeHttpError(w, http.StatusBadRequest, "MalformedXML", "RetainUntilDate must be an ISO 8601 timestamp")
+			return
+		}
+		ret = &storage.Retention{Mode: doc.Mode, RetainUntil: retainUntil}
+	}
+	_, err = h.store.PutObjectRetention(r.PathValue("BucketName"), objectKey(r), ret, bypassGovernance(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func (h *Handler) getObjectRetention(w http.ResponseWriter, r *http.Request) {
+	obj, err := h.store.HeadObject(r.PathValue("BucketName"), objectKey(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if obj.LockMode == "" {
+		writeStoreError(w, storage.ErrNoRetention)
+		return
+	}
+
+	doc := retention{Mode: obj.LockMode, RetainUntilDate: obj.RetainUntil.UTC().Format(time.RFC3339)}
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	output, _ := xml.MarshalIndent(doc, "", "\t")
+	fmt.Fprintln(w, string(output))
+}
+
+type legalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string   `xml:"Status"`
+}
+
+func (h *Handler) putObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	var doc legalHold
+	err := xml.NewDecoder(r.Body).Decode(&doc)
+	if err != nil || (doc.Status != "ON" && doc.Status != "OFF") {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "Could not parse LegalHold; Status must be ON or OFF")
+		return
+	}
+	_, err = h.store.PutObjectLegalHold(r.PathValue("BucketName"), objectKey(r), doc.Status == "ON")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func (h *Handler) getObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	obj, err := h.store.HeadObject(r.PathValue("BucketName"), objectKey(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	doc := legalHold{Status: "OFF"}
+	if obj.LegalHold {
+		doc.Status = "ON"
+	}
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	output, _ := xml.MarshalIndent(doc, "", "\t")
+	fmt.Fprintln(w, string(output))
+}
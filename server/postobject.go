@@ -0,0 +1,423 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"triple-s/storage"
+)
+
+// maxPostFormSize limits the size of the form fields preceding the file
+// of a POST upload.
+const maxPostFormSize = 20 << 10
+
+// postHeaderFields are the form fields of a POST upload which stand in for
+// the headers of a PUT request.
+var postHeaderFields = []string{
+	"content-type",
+	sseHeader, sseCustomerAlgorithm, sseCustomerKey, strings.ToLower(sseCustomerKeyMD5),
+	lockModeHeader, retainUntilHeader, legalHoldHeader, bypassGovernanceHeader,
+}
+
+// lockFields are the form fields which only a signed form may give, since
+// they keep objects from being deleted or override such protection.
+var lockFields = []string{lockModeHeader, retainUntilHeader, legalHoldHeader, bypassGovernanceHeader}
+
+// requestError is a problem with a request found while it is served,
+// answered with status and code.
+type requestError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.code + ": " + e.message
+}
+
+func invalidPost(message string) *requestError {
+	return &requestError{http.StatusBadRequest, "InvalidArgument", message}
+}
+
+func policyDenied(message string) *requestError {
+	return &requestError{http.StatusForbidden, "AccessDenied", "Invalid according to Policy: " + message}
+}
+
+// isFormUpload reports whether a request is a browser-based upload with
+// an HTML form, which postBucket routes to postObject: a multipart POST
+// to a bucket which neither creates a snapshot nor rolls back to one.
+func isFormUpload(r *http.Request) bool {
+	bucket, key := splitPath(r.URL.Path)
+	query := r.URL.Query()
+	if r.Method != http.MethodPost || bucket == "" || key != "" || query.Has("rollback") || query.Has("snapshot") {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// postObject answers POST /{BucketName} with a multipart/form-data body,
+// which uploads the file of an HTML form under the key of its key field.
+// A form with a policy field must be signed, and is only accepted if its
+// fields and file meet the conditions of the policy. When credentials are
+// configured, every form must have a policy, and Object Lock fields need
+// one in any case.
+func (h *Handler) postObject(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.PathValue("BucketName")
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "MalformedPOSTRequest", "The body of the POST request is not well-formed multipart/form-data")
+		return
+	}
+	fields, file, rerr := readPostForm(reader)
+	if rerr == nil && fields["key"] == "" {
+		rerr = invalidPost("Bucket POST must contain a field named 'key'")
+	}
+	var owner string
+	var minSize, maxSize int64
+	if rerr == nil {
+		owner, minSize, maxSize, rerr = h.checkPostPolicy(bucketName, fields, time.Now())
+	}
+	if rerr != nil {
+		writeHttpError(w, rerr.status, rerr.code, rerr.message)
+		return
+	}
+	recordIdentity(w, owner)
+
+	body := &sizeRangeReader{r: file, min: minSize, max: maxSize}
+	if maxSize >= 0 {
+		body.tooLarge = &requestError{http.StatusBadRequest, "EntityTooLarge", "The upload exceeds the maximum allowed by the policy"}
+	}
+	if h.maxObjectSize > 0 && (body.max < 0 || h.maxObjectSize < body.max) {
+		body.max, body.tooLarge = h.maxObjectSize, &http.MaxBytesError{Limit: h.maxObjectSize}
+	}
+	// The form fields stand in for the headers of a PUT request.
+	header := make(http.Header)
+	for _, name := range postHeaderFields {
+		if value, ok := fields[name]; ok {
+			header.Set(name, value)
+		}
+	}
+	formRequest := *r
+	formRequest.Header = header
+	// The policy applies to the key as given, in which ${filename} stands
+	// for the name of the uploaded file.
+	key := strings.ReplaceAll(fields["key"], "${filename}", file.FileName())
+	opts := storage.PutOptions{ContentType: fields["content-type"], Owner: owner}
+	obj, ok := h.storeObject(w, &formRequest, bucketName, key, body, opts)
+	if !ok {
+		return
+	}
+
+	etag := "\"" + obj.ETag + "\""
+	redirect := fields["success_action_redirect"]
+	if redirect == "" {
+		redirect = fields["redirect"]
+	}
+	if u, err := url.Parse(redirect); redirect != "" && err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		query := u.Query()
+		query.Set("bucket", bucketName)
+		query.Set("key", key)
+		query.Set("etag", etag)
+		u.RawQuery = query.Encode()
+		w.Header().Set("Location", u.String())
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	location := "/" + bucketName + "/" + key
+	if _, ok := hostBucket(hostName(r), h.domain); ok {
+		location = "/" + key
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	location = scheme + "://" + r.Host + (&url.URL{Path: location}).EscapedPath()
+	setEncryptionHeaders(w, &formRequest, obj)
+	setLockHeaders(w, obj)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Location", location)
+	switch fields["success_action_status"] {
+	case "200":
+		w.WriteHeader(http.StatusOK)
+	case "201":
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+		fmt.Fprintln(w, "<PostResponse>")
+		fmt.Fprintln(w, "\t<Location>"+xmlText(location)+"</Location>")
+		fmt.Fprintln(w, "\t<Bucket>"+bucketName+"</Bucket>")
+		fmt.Fprintln(w, "\t<Key>"+xmlText(key)+"</Key>")
+		fmt.Fprintln(w, "\t<ETag>"+xmlText(etag)+"</ETag>")
+		fmt.Fprintln(w, "</PostResponse>")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// readPostForm reads the fields of a POST upload up to its file, which is
+// left to be read from the returned part. Field names are in lower case.
+func readPostForm(reader *multipart.Reader) (map[string]string, *multipart.Part, *requestError) {
+	fields := make(map[string]string)
+	size := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, invalidPost("POST requires exactly one file upload per request")
+		}
+		if err != nil {
+			return nil, nil, &requestError{http.StatusBadRequest, "MalformedPOSTRequest", "The body of the POST request is not well-formed multipart/form-data"}
+		}
+		name := strings.ToLower(part.FormName())
+		if name == "file" {
+			return fields, part, nil
+		}
+		value, err := io.ReadAll(io.LimitReader(part, int64(maxPostFormSize-size+1)))
+		if err != nil {
+			return nil, nil, &requestError{http.StatusBadRequest, "MalformedPOSTRequest", "The body of the POST request is not well-formed multipart/form-data"}
+		}
+		size += len(value)
+		if size > maxPostFormSize {
+			return nil, nil, &requestError{http.StatusBadRequest, "MaxPostPreDataLengthExceeded", "The POST request fields preceding the upload file are too large"}
+		}
+		if _, ok := fields[name]; ok {
+			return nil, nil, invalidPost("POST field " + name + " is given more than once")
+		}
+		if name != "" {
+			fields[name] = string(value)
+		}
+	}
+}
+
+type postPolicy struct {
+	Expiration string            `json:"expiration"`
+	Conditions []json.RawMessage `json:"conditions"`
+}
+
+// policyCondition is a condition of a POST policy: the form field named
+// field is equal to value ("eq") or starts with it ("starts-with"), or the
+// size of the file is within min and max ("content-length-range").
+type policyCondition struct {
+	op       string
+	field    string
+	value    string
+	min, max int64
+}
+
+func (c policyCondition) String() string {
+	return fmt.Sprintf("[%q, %q, %q]", c.op, "$"+c.field, c.value)
+}
+
+func parseCondition(raw json.RawMessage) (policyCondition, error) {
+	var exact map[string]string
+	if json.Unmarshal(raw, &exact) == nil && len(exact) == 1 {
+		for field, value := range exact {
+			return policyCondition{op: "eq", field: strings.ToLower(strings.TrimPrefix(field, "$")), value: value}, nil
+		}
+	}
+	var list []any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err := decoder.Decode(&list)
+	if err != nil || len(list) != 3 {
+		return policyCondition{}, fmt.Errorf("condition %s is not valid", raw)
+	}
+	op, _ := list[0].(string)
+	cond := policyCondition{op: strings.ToLower(op)}
+	switch cond.op {
+	case "content-length-range":
+		cond.min, err = policyInt(list[1])
+		if err == nil {
+			cond.max, err = policyInt(list[2])
+		}
+		if err != nil || cond.min < 0 || cond.min > cond.max {
+			return cond, fmt.Errorf("condition %s is not a valid range", raw)
+		}
+		return cond, nil
+	case "eq", "starts-with":
+		field, ok := list[1].(string)
+		cond.value, _ = list[2].(string)
+		if !ok || !strings.HasPrefix(field, "$") {
+			return cond, fmt.Errorf("condition %s does not name a $field", raw)
+		}
+		cond.field = strings.ToLower(field[1:])
+		return cond, nil
+	}
+	return cond, fmt.Errorf("condition %s has an unknown operator", raw)
+}
+
+func policyInt(v any) (int64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Int64()
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("%v is not an integer", v)
+}
+
+// policyExempt reports whether a form field need not be covered by the
+// conditions of the policy.
+func policyExempt(field string) bool {
+	switch field {
+	case "policy", "x-amz-signature", "signature", "awsaccesskeyid", "file":
+		return true
+	}
+	return strings.HasPrefix(field, "x-ignore-")
+}
+
+// checkPostPolicy verifies the policy of a POST upload, if it has one,
+// against the form fields. It returns the access key which signed the
+// policy and the range of file sizes it allows; max is -1 for no limit.
+func (h *Handler) checkPostPolicy(bucketName string, fields map[string]string, now time.Time) (owner string, min int64, max int64, rerr *requestError) {
+	encoded, ok := fields["policy"]
+	if !ok {
+		if fields["x-amz-signature"] != "" || fields["signature"] != "" {
+			return "", 0, -1, invalidPost("Bucket POST must contain a field named 'policy'")
+		}
+		if len(h.credentials) > 0 {
+			return "", 0, -1, accessDenied("Anonymous POST uploads are not allowed")
+		}
+		for _, name := range lockFields {
+			if _, ok := fields[name]; ok {
+				return "", 0, -1, accessDenied("The " + name + " field needs a signed policy")
+			}
+		}
+		return "", 0, -1, nil
+	}
+	owner, rerr = h.verifyPostSignature(fields)
+	if rerr != nil {
+		return "", 0, -1, rerr
+	}
+	var policy postPolicy
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(content, &policy)
+	}
+	if err != nil {
+		return "", 0, -1, &requestError{http.StatusBadRequest, "InvalidPolicyDocument", "The policy is not base64-encoded JSON"}
+	}
+	expiration, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil {
+		return "", 0, -1, &requestError{http.StatusBadRequest, "InvalidPolicyDocument", "The policy expiration must be an ISO 8601 timestamp"}
+	}
+	if !now.Before(expiration) {
+		return "", 0, -1, policyDenied("Policy expired")
+	}
+
+	min, max = 0, -1
+	covered := make(map[string]bool)
+	for _, raw := range policy.Conditions {
+		cond, err := parseCondition(raw)
+		if err != nil {
+			return "", 0, -1, &requestError{http.StatusBadRequest, "InvalidPolicyDocument", "Policy " + err.Error()}
+		}
+		if cond.op == "content-length-range" {
+			min = cond.min
+			max = cond.max
+			continue
+		}
+		covered[cond.field] = true
+		value := fields[cond.field]
+		if cond.field == "bucket" {
+			value = bucketName
+		}
+		if (cond.op == "eq" && value != cond.value) || (cond.op == "starts-with" && !strings.HasPrefix(value, cond.value)) {
+			return "", 0, -1, policyDenied("Policy Condition failed: " + cond.String())
+		}
+	}
+	var extra []string
+	for field := range fields {
+		if !covered[field] && !policyExempt(field) {
+			extra = append(extra, field)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return "", 0, -1, policyDenied("Extra input fields: " + strings.Join(extra, ", "))
+	}
+	return owner, min, max, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// verifyPostSignature checks the signature of the policy of a POST upload
+// with the secret key of the access key which signed it, and returns that
+// access key. Signatures of AWS Signature Version 4 and 2 are accepted.
+func (h *Handler) verifyPostSignature(fields map[string]string) (string, *requestError) {
+	policy := fields["policy"]
+	var accessKey, signature string
+	var sign func(secretKey string) string
+	switch {
+	case fields["x-amz-signature"] != "":
+		if fields["x-amz-algorithm"] != "AWS4-HMAC-SHA256" {
+			return "", invalidPost("x-amz-algorithm must be AWS4-HMAC-SHA256")
+		}
+		scope := strings.Split(fields["x-amz-credential"], "/")
+		if len(scope) != 5 || scope[4] != "aws4_request" {
+			return "", invalidPost("x-amz-credential must be ACCESSKEY/DATE/REGION/SERVICE/aws4_request")
+		}
+		accessKey, signature = scope[0], fields["x-amz-signature"]
+		sign = func(secretKey string) string {
+			return hex.EncodeToString(hmacSHA256(signingKey(secretKey, scope[1:]), policy))
+		}
+	case fields["signature"] != "":
+		accessKey, signature = fields["awsaccesskeyid"], fields["signature"]
+		sign = func(secretKey string) string {
+			mac := hmac.New(sha1.New, []byte(secretKey))
+			mac.Write([]byte(policy))
+			return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+	default:
+		return "", invalidPost("A POST policy must be signed with x-amz-signature or signature")
+	}
+	secretKey, ok := h.credentials[accessKey]
+	if !ok {
+		return "", &requestError{http.StatusForbidden, "InvalidAccessKeyId", "The access key " + accessKey + " is not known"}
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(secretKey))) {
+		return "", &requestError{http.StatusForbidden, "SignatureDoesNotMatch", "The signature of the policy does not match"}
+	}
+	return accessKey, nil
+}
+
+// sizeRangeReader reads the file of a POST upload. Reading fails with
+// tooLarge once more than max bytes are read, unless max is negative, and
+// at the end if fewer than min bytes were read.
+type sizeRangeReader struct {
+	r        io.Reader
+	min, max int64
+	n        int64
+	tooLarge error
+}
+
+func (sr *sizeRangeReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.n += int64(n)
+	if sr.max >= 0 && sr.n > sr.max {
+		return n, sr.tooLarge
+	}
+	if err == io.EOF && sr.n < sr.min {
+		return n, &requestError{http.StatusBadRequest, "EntityTooSmall", "The upload is smaller than the minimum allowed by the policy"}
+	}
+	return n, err
+}
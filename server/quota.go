@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"triple-s/storage"
+)
+
+type quotaReport struct {
+	Buckets []storage.QuotaStatus `json:"buckets"`
+	Users   []storage.QuotaStatus `json:"users"`
+}
+
+// getQuotas answers GET /quotas on the admin listener with the quota and
+// usage of every bucket and user as JSON.
+func (h *Handler) getQuotas(w http.ResponseWriter, r *http.Request) {
+	var report quotaReport
+	var err error
+	report.Buckets, err = h.store.BucketQuotas()
+	if err == nil {
+		report.Users, err = h.store.UserQuotas()
+	}
+	if err != nil {
+		http.Error(w, "could not read quotas: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	output, _ := json.MarshalIndent(report, "", "\t")
+	w.Write(append(output, '\n'))
+}
+
+// putQuota answers PUT /quotas/buckets/{Name} and PUT /quotas/users/{Name}
+// with a JSON quota such as {"maxBytes": 1073741824, "maxObjects": 1000}.
+func (h *Handler) putQuota(w http.ResponseWriter, r *http.Request) {
+	var quota storage.Quota
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&quota)
+	if err != nil {
+		http.Error(w, "could not parse quota: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.setQuota(w, r, &quota)
+}
+
+// deleteQuota removes the quota of a bucket or user.
+func (h *Handler) deleteQuota(w http.ResponseWriter, r *http.Request) {
+	h.setQuota(w, r, nil)
+}
+
+func (h *Handler) setQuota(w http.ResponseWriter, r *http.Request, quota *storage.Quota) {
+	var err error
+	switch r.PathValue("Kind") {
+	case "buckets":
+		err = h.store.SetBucketQuota(r.PathValue("Name"), quota)
+	case "users":
+		err = h.store.SetUserQuota(r.PathValue("Name"), quota)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	var serr *storage.Error
+	if errors.As(err, &serr) {
+		code, ok := errorStatus[serr.Code]
+		if !ok {
+			code = http.StatusInternalServerError
+		}
+		http.Error(w, serr.Message, code)
+		return
+	}
+	if err != nil {
+		http.Error(w, "could not set quota: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rate is a token-bucket rate limit: PerSecond requests are allowed per
+// second on average, and up to Burst at once. A zero PerSecond is no
+// limit.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// RateLimits limit the request rate of every access key, client IP and
+// bucket separately. Anonymous requests are limited per access key by
+// their client IP.
+type RateLimits struct {
+	PerAccessKey Rate
+	PerIP        Rate
+	PerBucket    Rate
+}
+
+// tokenBucket holds the tokens left for one key at the time of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per key. Buckets which have filled up
+// again are dropped, so idle keys take no memory.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// newRateLimiter returns a limiter for rate, or nil if rate is no limit.
+func newRateLimiter(rate Rate) *rateLimiter {
+	if rate.PerSecond <= 0 {
+		return nil
+	}
+	burst := float64(rate.Burst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(rate.PerSecond))
+	}
+	return &rateLimiter{rate: rate.PerSecond, burst: burst, buckets: make(map[string]*tokenBucket)}
+}
+
+// reserve takes a token for key. If none is left, it returns false and how
+// long it takes for the next token to be available. A token taken for a
+// request which is refused anyway is given back with cancel.
+func (l *rateLimiter) reserve(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) > time.Minute {
+		l.prune(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cancel gives back a token taken by reserve.
+func (l *rateLimiter) cancel(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = math.Min(l.burst, b.tokens+1)
+	}
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// rateLimit is a limiter with the key a request is counted under.
+type rateLimit struct {
+	limiter *rateLimiter
+	key     string
+}
+
+// reservation holds the tokens taken for a request, which are given back
+// if the request is refused by a later limit.
+type reservation []rateLimit
+
+type reservationKey struct{}
+
+// reserve takes a token from every limit in limits, or from none.
+func (res *reservation) reserve(limits []rateLimit, now time.Time) (bool, time.Duration) {
+	for _, l := range limits {
+		if l.limiter == nil || l.key == "" {
+			continue
+		}
+		ok, wait := l.limiter.reserve(l.key, now)
+		if !ok {
+			return false, wait
+		}
+		*res = append(*res, l)
+	}
+	return true, 0
+}
+
+func (res reservation) cancel() {
+	for _, l := range res {
+		l.limiter.cancel(l.key)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// limit refuses requests over the limit of their client IP or bucket with
+// SlowDown. It runs before authenticate, so that requests with missing or
+// bad signatures are limited too.
+func (h *Handler) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, _ := splitPath(r.URL.Path)
+		res := &reservation{}
+		ok, wait := res.reserve([]rateLimit{{h.ipLimiter, clientIP(r)}, {h.bucketLimiter, bucket}}, time.Now())
+		if !ok {
+			res.cancel()
+			slowDown(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), reservationKey{}, res)))
+	})
+}
+
+// limitAccessKey refuses requests over the limit of their access key, and
+// uploads beyond the limit on concurrent uploads, with SlowDown. It runs
+// after authenticate, so that only a verified access key is limited as
+// such; anonymous requests are limited by their client IP. A request takes
+// a token from every limit or from none: the tokens taken by limit for a
+// request refused here are given back.
+func (h *Handler) limitAccessKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, _ := r.Context().Value(reservationKey{}).(*reservation)
+		if res == nil {
+			res = &reservation{}
+		}
+		accessKey := identityOf(r)
+		if accessKey == "" {
+			accessKey = clientIP(r)
+		}
+		ok, wait := res.reserve([]rateLimit{{h.accessKeyLimiter, accessKey}}, time.Now())
+		if !ok {
+			res.cancel()
+			slowDown(w, wait)
+			return
+		}
+
+		if _, key := splitPath(r.URL.Path); h.uploads != nil && isUpload(r, key) {
+			select {
+			case h.uploads <- struct{}{}:
+				defer func() { <-h.uploads }()
+			default:
+				res.cancel()
+				slowDown(w, time.Second)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isUpload reports whether a request uploads the content of an object in
+// its body: a PUT of an object other than of its retention or legal hold,
+// or a POST form upload.
+func isUpload(r *http.Request, key string) bool {
+	switch r.Method {
+	case http.MethodPut:
+		query := r.URL.Query()
+		return key != "" && !query.Has("retention") && !query.Has("legal-hold")
+	case http.MethodPost:
+		return isFormUpload(r)
+	}
+	return false
+}
+
+// slowDown answers with SlowDown, telling the client to retry after wait.
+func slowDown(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	writeHttpError(w, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate")
+}
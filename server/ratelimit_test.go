@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimits(t *testing.T) {
+	_, store := newTestHandler(t)
+	// The limits refill too slowly to matter while the test runs.
+	h := NewHandler(store, Options{
+		Credentials: map[string]string{testAccessKey: testSecretKey},
+		RateLimits: RateLimits{
+			PerIP:        Rate{PerSecond: 0.001, Burst: 4},
+			PerAccessKey: Rate{PerSecond: 0.001, Burst: 1},
+		},
+	})
+	signed := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+		signRequest(r, []string{"host", "x-amz-content-sha256", "x-amz-date"}, unsignedPayload, time.Now())
+		return r
+	}
+	unsigned := func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+	}
+
+	tests := []struct {
+		r    *http.Request
+		want int
+	}{
+		{signed(), http.StatusOK},
+		// Over the access key limit: the token of the IP is given back.
+		{signed(), http.StatusServiceUnavailable},
+		// Unsigned requests count against the IP before they are refused.
+		{unsigned(), http.StatusForbidden},
+		{unsigned(), http.StatusForbidden},
+		{unsigned(), http.StatusForbidden},
+		{unsigned(), http.StatusServiceUnavailable},
+	}
+	for i, test := range tests {
+		if w := serve(h, test.r); w.Code != test.want {
+			t.Errorf("request %d = %d, want %d", i, w.Code, test.want)
+		}
+	}
+}
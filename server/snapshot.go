@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"triple-s/storage"
+)
+
+// postBucket dispatches POST /{BucketName}?snapshot[=Name], which creates a
+// snapshot of the bucket, and POST /{BucketName}?rollback=Name, which rolls
+// the bucket back to a snapshot.
+func (h *Handler) postBucket(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case query.Has("rollback"):
+		h.rollbackBucket(w, r)
+	case query.Has("snapshot"):
+		h.createSnapshot(w, r)
+	case isFormUpload(r):
+		h.postObject(w, r)
+	default:
+		h.badRequest(w, r)
+	}
+}
+
+// createSnapshot creates a snapshot named by the snapshot parameter, or
+// after the current time if it is empty.
+func (h *Handler) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("snapshot")
+	if name == "" {
+		name = time.Now().UTC().Format("20060102T150405Z")
+	}
+	snap, err := h.store.CreateSnapshot(r.PathValue("BucketName"), name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	fmt.Fprintln(w, "<CreateSnapshotResult>")
+	fmt.Fprintln(w, "\t<Name>"+snap.Name+"</Name>")
+	fmt.Fprintln(w, "\t<CreationTime>"+snap.CreationTime.Format(storage.TimeLayout)+"</CreationTime>")
+	fmt.Fprintln(w, "</CreateSnapshotResult>")
+}
+
+func (h *Handler) rollbackBucket(w http.ResponseWriter, r *http.Request) {
+	err := h.store.RollbackBucket(r.PathValue("BucketName"), r.URL.Query().Get("rollback"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	snaps, err := h.store.Snapshots(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	fmt.Fprintln(w, "<ListSnapshotsResult>")
+	fmt.Fprintln(w, "\t<Name>"+r.PathValue("BucketName")+"</Name>")
+	for _, snap := range snaps {
+		fmt.Fprintln(w, "\t<Snapshot>")
+		fmt.Fprintln(w, "\t\t<Name>"+snap.Name+"</Name>")
+		fmt.Fprintln(w, "\t\t<CreationTime>"+snap.CreationTime.Format(storage.TimeLayout)+"</CreationTime>")
+		fmt.Fprintln(w, "\t</Snapshot>")
+	}
+	fmt.Fprintln(w, "</ListSnapshotsResult>")
+}
+
+func (h *Handler) deleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	err := h.store.DeleteSnapshot(r.PathValue("BucketName"), r.URL.Query().Get("snapshot"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectSnapshotWrite answers requests modifying an object of a snapshot,
+// which is read-only. It reports whether it answered.
+func rejectSnapshotWrite(w http.ResponseWriter, r *http.Request) bool {
+	if !r.URL.Query().Has("snapshot") {
+		return false
+	}
+	writeHttpError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "Snapshots are read-only")
+	return true
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hostName returns the host a request is addressed to, in lower case and
+// without a port.
+func hostName(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	return strings.ToLower(host)
+}
+
+// hostBucket returns the bucket named by a host of the form
+// <bucket>.<domain>.
+func hostBucket(host string, domain string) (string, bool) {
+	if domain == "" {
+		return "", false
+	}
+	bucket, ok := strings.CutSuffix(host, "."+domain)
+	return bucket, ok && bucket != ""
+}
+
+// withBucketPath returns r readdressed to bucket in path style, so that
+// it is routed like a path-style request.
+func withBucketPath(r *http.Request, bucket string) *http.Request {
+	u := *r.URL
+	u.Path = "/" + bucket + u.Path
+	if u.RawPath != "" {
+		u.RawPath = "/" + bucket + u.RawPath
+	}
+	rewritten := *r
+	rewritten.URL = &u
+	return &rewritten
+}
+
+// virtualHost routes virtual-hosted-style requests, addressed to
+// <bucket>.<Domain>/<key>, like path-style requests. Requests to other
+// hosts are path-style.
+func (h *Handler) virtualHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, ok := hostBucket(hostName(r), h.domain)
+		if ok && strings.HasPrefix(r.URL.Path, "/") {
+			r = withBucketPath(r, bucket)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
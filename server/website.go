@@ -0,0 +1,283 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"triple-s/storage"
+)
+
+type websiteRedirect struct {
+	Protocol             string  `xml:"Protocol,omitempty"`
+	HostName             string  `xml:"HostName,omitempty"`
+	ReplaceKeyPrefixWith *string `xml:"ReplaceKeyPrefixWith"`
+	ReplaceKeyWith       string  `xml:"ReplaceKeyWith,omitempty"`
+	HttpRedirectCode     int     `xml:"HttpRedirectCode,omitempty"`
+}
+
+func (r websiteRedirect) redirect() storage.Redirect {
+	return storage.Redirect{
+		Protocol:             r.Protocol,
+		HostName:             r.HostName,
+		ReplaceKeyPrefixWith: r.ReplaceKeyPrefixWith,
+		ReplaceKeyWith:       r.ReplaceKeyWith,
+		HTTPRedirectCode:     r.HttpRedirectCode,
+	}
+}
+
+func toWebsiteRedirect(r storage.Redirect) websiteRedirect {
+	return websiteRedirect{r.Protocol, r.HostName, r.ReplaceKeyPrefixWith, r.ReplaceKeyWith, r.HTTPRedirectCode}
+}
+
+type routingRule struct {
+	Condition *struct {
+		KeyPrefixEquals             string `xml:"KeyPrefixEquals,omitempty"`
+		HttpErrorCodeReturnedEquals int    `xml:"HttpErrorCodeReturnedEquals,omitempty"`
+	} `xml:"Condition"`
+	Redirect websiteRedirect `xml:"Redirect"`
+}
+
+type websiteConfiguration struct {
+	XMLName       xml.Name `xml:"WebsiteConfiguration"`
+	IndexDocument *struct {
+		Suffix string `xml:"Suffix"`
+	} `xml:"IndexDocument"`
+	ErrorDocument *struct {
+		Key string `xml:"Key"`
+	} `xml:"ErrorDocument"`
+	RedirectAllRequestsTo *websiteRedirect `xml:"RedirectAllRequestsTo"`
+	RoutingRules          []routingRule    `xml:"RoutingRules>RoutingRule"`
+}
+
+func (h *Handler) putBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	var doc websiteConfiguration
+	err := xml.NewDecoder(r.Body).Decode(&doc)
+	if err != nil {
+		writeHttpError(w, http.StatusBadRequest, "MalformedXML", "Could not parse WebsiteConfiguration")
+		return
+	}
+	var cfg storage.WebsiteConfig
+	if doc.IndexDocument != nil {
+		cfg.IndexDocument = doc.IndexDocument.Suffix
+	}
+	if doc.ErrorDocument != nil {
+		cfg.ErrorDocument = doc.ErrorDocument.Key
+	}
+	if doc.RedirectAllRequestsTo != nil {
+		redirect := doc.RedirectAllRequestsTo.redirect()
+		cfg.RedirectAllRequestsTo = &redirect
+	}
+	for _, rule := range doc.RoutingRules {
+		var storageRule storage.RoutingRule
+		if rule.Condition != nil {
+			storageRule.KeyPrefixEquals = rule.Condition.KeyPrefixEquals
+			storageRule.HTTPErrorCode = rule.Condition.HttpErrorCodeReturnedEquals
+		}
+		storageRule.Redirect = rule.Redirect.redirect()
+		cfg.RoutingRules = append(cfg.RoutingRules, storageRule)
+	}
+	err = h.store.SetBucketWebsite(r.PathValue("BucketName"), &cfg)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+}
+
+func (h *Handler) getBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	cfg, err := h.store.BucketConfig(r.PathValue("BucketName"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	website := cfg.Website
+	if website == nil {
+		writeStoreError(w, storage.ErrNoWebsiteConfig)
+		return
+	}
+
+	var doc websiteConfiguration
+	if website.IndexDocument != "" {
+		doc.IndexDocument = &struct {
+			Suffix string `xml:"Suffix"`
+		}{website.IndexDocument}
+	}
+	if website.ErrorDocument != "" {
+		doc.ErrorDocument = &struct {
+			Key string `xml:"Key"`
+		}{website.ErrorDocument}
+	}
+	if website.RedirectAllRequestsTo != nil {
+		redirect := toWebsiteRedirect(*website.RedirectAllRequestsTo)
+		doc.RedirectAllRequestsTo = &redirect
+	}
+	for _, rule := range website.RoutingRules {
+		docRule := routingRule{Redirect: toWebsiteRedirect(rule.Redirect)}
+		if rule.KeyPrefixEquals != "" || rule.HTTPErrorCode != 0 {
+			docRule.Condition = &struct {
+				KeyPrefixEquals             string `xml:"KeyPrefixEquals,omitempty"`
+				HttpErrorCodeReturnedEquals int    `xml:"HttpErrorCodeReturnedEquals,omitempty"`
+			}{rule.KeyPrefixEquals, rule.HTTPErrorCode}
+		}
+		doc.RoutingRules = append(doc.RoutingRules, docRule)
+	}
+	fmt.Fprintln(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>")
+	output, _ := xml.MarshalIndent(doc, "", "\t")
+	fmt.Fprintln(w, string(output))
+}
+
+func (h *Handler) deleteBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	err := h.store.SetBucketWebsite(r.PathValue("BucketName"), nil)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebsiteHandler serves the buckets configured as static websites. The
+// bucket is named by the host of a request: <bucket>.<WebsiteDomain> for
+// hosts under the website domain, and the whole host name otherwise, so
+// that a bucket can be named after the site it serves.
+func (h *Handler) WebsiteHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.websiteMethodNotAllowed)
+	mux.HandleFunc("GET /{BucketName}/{ObjectKey...}", h.getWebsite)
+	return h.websiteBucket(h.instrument(h.limit(h.cors(mux))))
+}
+
+// websiteBucket rewrites website requests into path-style requests for
+// the bucket named by their host.
+func (h *Handler) websiteBucket(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := hostName(r)
+		bucket, ok := hostBucket(host, h.websiteDomain)
+		if !ok {
+			bucket = host
+		}
+		if bucket == "" || !strings.HasPrefix(r.URL.Path, "/") {
+			writeWebsiteError(w, http.StatusBadRequest, "BadRequest", "The request does not name a bucket")
+			return
+		}
+		next.ServeHTTP(w, withBucketPath(r, bucket))
+	})
+}
+
+func (h *Handler) websiteMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET, HEAD")
+	writeWebsiteError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource")
+}
+
+// getWebsite serves an object of a website. Requests for a directory
+// serve its index document, and requests for a missing object the error
+// document, after the routing rules of the bucket had their say.
+func (h *Handler) getWebsite(w http.ResponseWriter, r *http.Request) {
+	bucketName, key := r.PathValue("BucketName"), r.PathValue("ObjectKey")
+	cfg, err := h.store.BucketConfig(bucketName)
+	if err == nil && cfg.Website == nil {
+		err = storage.ErrNoWebsiteConfig
+	}
+	if err != nil {
+		writeWebsiteStoreError(w, err)
+		return
+	}
+	website := cfg.Website
+	if website.RedirectAllRequestsTo != nil {
+		redirectWebsite(w, r, *website.RedirectAllRequestsTo, key)
+		return
+	}
+	if rule, ok := website.MatchRoutingRule(key, 0); ok {
+		redirectWebsite(w, r, rule.Redirect, rule.RedirectKey(key))
+		return
+	}
+
+	objectKey := key
+	if objectKey == "" || strings.HasSuffix(objectKey, "/") {
+		objectKey += website.IndexDocument
+	}
+	obj, content, err := h.store.GetObject(bucketName, objectKey, nil)
+	if err == nil {
+		defer content.Close()
+		serveObject(w, r, obj, content)
+		return
+	}
+	if errors.Is(err, storage.ErrObjectNotFound) && objectKey == key {
+		// A directory addressed without its trailing slash.
+		_, dirErr := h.store.HeadObject(bucketName, key+"/"+website.IndexDocument)
+		if dirErr == nil {
+			w.Header().Set("Location", (&url.URL{Path: "/" + key + "/"}).EscapedPath())
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+	}
+	status, _, _ := storeError(err)
+	if rule, ok := website.MatchRoutingRule(key, status); ok {
+		redirectWebsite(w, r, rule.Redirect, rule.RedirectKey(key))
+		return
+	}
+	if status == http.StatusNotFound && website.ErrorDocument != "" {
+		errObj, errContent, docErr := h.store.GetObject(bucketName, website.ErrorDocument, nil)
+		if docErr == nil {
+			defer errContent.Close()
+			setObjectHeaders(w, errObj)
+			w.WriteHeader(http.StatusNotFound)
+			io.Copy(w, errContent)
+			return
+		}
+	}
+	writeWebsiteStoreError(w, err)
+}
+
+// redirectWebsite redirects a website request to key as redirect says.
+func redirectWebsite(w http.ResponseWriter, r *http.Request, redirect storage.Redirect, key string) {
+	protocol := redirect.Protocol
+	if protocol == "" {
+		protocol = "http"
+		if r.TLS != nil {
+			protocol = "https"
+		}
+	}
+	host := redirect.HostName
+	if host == "" {
+		host = r.Host
+	}
+	code := redirect.HTTPRedirectCode
+	if code == 0 {
+		code = http.StatusMovedPermanently
+	}
+	w.Header().Set("Location", protocol+"://"+host+(&url.URL{Path: "/" + key}).EscapedPath())
+	w.WriteHeader(code)
+}
+
+// writeWebsiteError answers a website request with an HTML error page,
+// since website visitors are browsers rather than S3 clients.
+func writeWebsiteError(w http.ResponseWriter, code int, errorCode string, message string) {
+	if rec, ok := w.(*responseRecorder); ok {
+		rec.errorCode = errorCode
+	}
+	title := strconv.Itoa(code) + " " + http.StatusText(code)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, "<html>")
+	fmt.Fprintln(w, "<head><title>"+title+"</title></head>")
+	fmt.Fprintln(w, "<body>")
+	fmt.Fprintln(w, "<h1>"+title+"</h1>")
+	fmt.Fprintln(w, "<ul>")
+	fmt.Fprintln(w, "<li>Code: "+errorCode+"</li>")
+	fmt.Fprintln(w, "<li>Message: "+html.EscapeString(message)+"</li>")
+	fmt.Fprintln(w, "<li>RequestId: "+w.Header().Get("x-amz-request-id")+"</li>")
+	fmt.Fprintln(w, "</ul>")
+	fmt.Fprintln(w, "</body>")
+	fmt.Fprintln(w, "</html>")
+}
+
+func writeWebsiteStoreError(w http.ResponseWriter, err error) {
+	code, errorCode, message := storeError(err)
+	writeWebsiteError(w, code, errorCode, message)
+}
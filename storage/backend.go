@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Backend persists the buckets, objects and bucket configuration of a
+// Store. The Store validates requests and serializes modifications, so a
+// backend only has to store and return what it is given.
+type Backend interface {
+	// ReadBuckets returns every bucket record, including deleted ones.
+	ReadBuckets() ([]Bucket, error)
+	WriteBuckets(bkts []Bucket) error
+
+	// CreateBucket prepares the storage of a new, empty bucket. It
+	// returns ErrBucketExists if storage for the name already exists.
+	CreateBucket(bucketName string) error
+	// RemoveBucket removes the storage of an empty bucket. It returns
+	// errIncompleteRemoval if the bucket was emptied but could not be
+	// removed entirely.
+	RemoveBucket(bucketName string) error
+
+	// ReadObjects returns the object records of a bucket.
+	ReadObjects(bucketName string) ([]Object, error)
+	WriteObjects(bucketName string, objs []Object) error
+
+	// NewObject returns a writer for the content of a new object in the
+	// bucket. The content replaces that of an object only when committed.
+	NewObject(bucketName string) (PendingObject, error)
+	OpenObject(bucketName string, objectKey string) (io.ReadSeekCloser, error)
+	// RemoveObject removes the content of an object. Removing content
+	// which does not exist is not an error.
+	RemoveObject(bucketName string, objectKey string) error
+
+	// ReadSnapshots returns the snapshots of a bucket.
+	ReadSnapshots(bucketName string) ([]Snapshot, error)
+	WriteSnapshots(bucketName string, snaps []Snapshot) error
+	// CreateSnapshot stores the objects of a bucket as a read-only
+	// snapshot which shares their content rather than copying it where the
+	// backend can.
+	CreateSnapshot(bucketName string, snapshotName string, objs []Object) error
+	// ReadSnapshotObjects returns the object records of a snapshot.
+	ReadSnapshotObjects(bucketName string, snapshotName string) ([]Object, error)
+	// WriteSnapshotObjects replaces the object records of a snapshot,
+	// whose content stays the same.
+	WriteSnapshotObjects(bucketName string, snapshotName string, objs []Object) error
+	OpenSnapshotObject(bucketName string, snapshotName string, objectKey string) (io.ReadSeekCloser, error)
+	// RestoreSnapshotObject replaces the content of an object of the
+	// bucket with its content in the snapshot.
+	RestoreSnapshotObject(bucketName string, snapshotName string, objectKey string) error
+	// RemoveSnapshot removes the objects of a snapshot. Removing a
+	// snapshot which does not exist is not an error.
+	RemoveSnapshot(bucketName string, snapshotName string) error
+
+	// ReadConfig returns the encoded configuration of a bucket, or nil if
+	// it has none.
+	ReadConfig(bucketName string) ([]byte, error)
+	WriteConfig(bucketName string, data []byte) error
+	RemoveConfig(bucketName string) error
+	// ReadUserQuotas returns the encoded quotas of users, or nil if none
+	// were set.
+	ReadUserQuotas() ([]byte, error)
+	WriteUserQuotas(data []byte) error
+
+	// Check verifies that the backend can be read from and written to.
+	Check() error
+	// Sync commits written data to stable storage.
+	Sync() error
+}
+
+// PendingObject receives the content of an object being written.
+type PendingObject interface {
+	io.Writer
+	// Commit makes the written content the content of objectKey.
+	Commit(objectKey string) error
+	// Abort discards the written content. It does nothing after Commit.
+	Abort() error
+}
+
+// errIncompleteRemoval is returned by Backend.RemoveBucket when a bucket
+// was emptied but could not be removed.
+var errIncompleteRemoval = errors.New("bucket storage could not be removed")
+
+// memoryBackend keeps everything in memory. It is meant for tests and
+// ephemeral environments; its content is lost when the process exits.
+type memoryBackend struct {
+	mu      sync.RWMutex
+	buckets []Bucket
+	objects map[string][]Object
+	content map[string]map[string][]byte
+	configs map[string][]byte
+	quotas  []byte
+	// snapshots and snapshotObjects are indexed by bucket, and the
+	// latter then by snapshot name. Content is shared with the bucket,
+	// which never modifies it in place.
+	snapshots       map[string][]Snapshot
+	snapshotObjects map[string]map[string][]Object
+	snapshotContent map[string]map[string]map[string][]byte
+}
+
+// NewMemoryBackend returns an empty backend which keeps all data in
+// memory.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{
+		objects: make(map[string][]Object),
+		content: make(map[string]map[string][]byte),
+		configs: make(map[string][]byte),
+
+		snapshots:       make(map[string][]Snapshot),
+		snapshotObjects: make(map[string]map[string][]Object),
+		snapshotContent: make(map[string]map[string]map[string][]byte),
+	}
+}
+
+func (m *memoryBackend) ReadBuckets() ([]Bucket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.buckets), nil
+}
+
+func (m *memoryBackend) WriteBuckets(bkts []Bucket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buckets = slices.Clone(bkts)
+	return nil
+}
+
+func (m *memoryBackend) CreateBucket(bucketName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.content[bucketName]; ok {
+		return ErrBucketExists
+	}
+	m.objects[bucketName] = nil
+	m.content[bucketName] = make(map[string][]byte)
+	return nil
+}
+
+func (m *memoryBackend) RemoveBucket(bucketName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, bucketName)
+	delete(m.content, bucketName)
+	return nil
+}
+
+func (m *memoryBackend) ReadObjects(bucketName string) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	objs, ok := m.objects[bucketName]
+	if !ok {
+		return nil, errors.New("no object metadata for bucket " + bucketName)
+	}
+	return slices.Clone(objs), nil
+}
+
+func (m *memoryBackend) WriteObjects(bucketName string, objs []Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[bucketName] = slices.Clone(objs)
+	return nil
+}
+
+type memoryPendingObject struct {
+	bytes.Buffer
+	backend    *memoryBackend
+	bucketName string
+}
+
+func (p *memoryPendingObject) Commit(objectKey string) error {
+	p.backend.mu.Lock()
+	defer p.backend.mu.Unlock()
+	content, ok := p.backend.content[p.bucketName]
+	if !ok {
+		return errors.New("bucket " + p.bucketName + " does not exist")
+	}
+	content[objectKey] = bytes.Clone(p.Bytes())
+	return nil
+}
+
+func (p *memoryPendingObject) Abort() error {
+	p.Reset()
+	return nil
+}
+
+func (m *memoryBackend) NewObject(bucketName string) (PendingObject, error) {
+	return &memoryPendingObject{backend: m, bucketName: bucketName}, nil
+}
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+func (m *memoryBackend) OpenObject(bucketName string, objectKey string) (io.ReadSeekCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	content, ok := m.content[bucketName][objectKey]
+	if !ok {
+		return nil, errors.New("object " + bucketName + "/" + objectKey + " has no content")
+	}
+	return readSeekNopCloser{bytes.NewReader(content)}, nil
+}
+
+func (m *memoryBackend) RemoveObject(bucketName string, objectKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.content[bucketName], objectKey)
+	return nil
+}
+
+func (m *memoryBackend) ReadSnapshots(bucketName string) ([]Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.snapshots[bucketName]), nil
+}
+
+func (m *memoryBackend) WriteSnapshots(bucketName string, snaps []Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots[bucketName] = slices.Clone(snaps)
+	return nil
+}
+
+func (m *memoryBackend) CreateSnapshot(bucketName string, snapshotName string, objs []Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content := make(map[string][]byte, len(objs))
+	for _, obj := range objs {
+		data, ok := m.content[bucketName][obj.Key]
+		if !ok {
+			return errors.New("object " + bucketName + "/" + obj.Key + " has no content")
+		}
+		content[obj.Key] = data
+	}
+	if m.snapshotObjects[bucketName] == nil {
+		m.snapshotObjects[bucketName] = make(map[string][]Object)
+		m.snapshotContent[bucketName] = make(map[string]map[string][]byte)
+	}
+	m.snapshotObjects[bucketName][snapshotName] = slices.Clone(objs)
+	m.snapshotContent[bucketName][snapshotName] = content
+	return nil
+}
+
+func (m *memoryBackend) ReadSnapshotObjects(bucketName string, snapshotName string) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	objs, ok := m.snapshotObjects[bucketName][snapshotName]
+	if !ok {
+		return nil, errors.New("no object metadata for snapshot " + bucketName + "@" + snapshotName)
+	}
+	return slices.Clone(objs), nil
+}
+
+func (m *memoryBackend) WriteSnapshotObjects(bucketName string, snapshotName string, objs []Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.snapshotObjects[bucketName][snapshotName]; !ok {
+		return errors.New("snapshot " + bucketName + "@" + snapshotName + " does not exist")
+	}
+	m.snapshotObjects[bucketName][snapshotName] = slices.Clone(objs)
+	return nil
+}
+
+func (m *memoryBackend) OpenSnapshotObject(bucketName string, snapshotName string, objectKey string) (io.ReadSeekCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	content, ok := m.snapshotContent[bucketName][snapshotName][objectKey]
+	if !ok {
+		return nil, errors.New("object " + bucketName + "@" + snapshotName + "/" + objectKey + " has no content")
+	}
+	return readSeekNopCloser{bytes.NewReader(content)}, nil
+}
+
+func (m *memoryBackend) RestoreSnapshotObject(bucketName string, snapshotName string, objectKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.snapshotContent[bucketName][snapshotName][objectKey]
+	if !ok {
+		return errors.New("object " + bucketName + "@" + snapshotName + "/" + objectKey + " has no content")
+	}
+	bucketContent, ok := m.content[bucketName]
+	if !ok {
+		return errors.New("bucket " + bucketName + " does not exist")
+	}
+	bucketContent[objectKey] = content
+	return nil
+}
+
+func (m *memoryBackend) RemoveSnapshot(bucketName string, snapshotName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.snapshotObjects[bucketName], snapshotName)
+	delete(m.snapshotContent[bucketName], snapshotName)
+	return nil
+}
+
+func (m *memoryBackend) ReadConfig(bucketName string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return bytes.Clone(m.configs[bucketName]), nil
+}
+
+func (m *memoryBackend) WriteConfig(bucketName string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configs[bucketName] = bytes.Clone(data)
+	return nil
+}
+
+func (m *memoryBackend) RemoveConfig(bucketName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.configs, bucketName)
+	return nil
+}
+
+func (m *memoryBackend) ReadUserQuotas() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return bytes.Clone(m.quotas), nil
+}
+
+func (m *memoryBackend) WriteUserQuotas(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotas = bytes.Clone(data)
+	return nil
+}
+
+func (m *memoryBackend) Check() error {
+	return nil
+}
+
+func (m *memoryBackend) Sync() error {
+	return nil
+}
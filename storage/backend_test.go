@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testBackends are the backends the Backend contract and the Store
+// operations are tested against.
+var testBackends = []struct {
+	name string
+	new  func(t *testing.T) Backend
+}{
+	{"memory", func(t *testing.T) Backend {
+		return NewMemoryBackend()
+	}},
+	{"fs", func(t *testing.T) Backend {
+		backend, err := NewFSBackend(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	}},
+	{"dedup", func(t *testing.T) Backend {
+		backend, err := NewDedupBackend(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	}},
+}
+
+// forEachBackend runs test with a new store over each of testBackends.
+func forEachBackend(t *testing.T, test func(t *testing.T, store *Store)) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store, err := New(backend.new(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test(t, store)
+		})
+	}
+}
+
+func readContent(t *testing.T, content io.ReadSeekCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBackendContract(t *testing.T) {
+	for _, test := range testBackends {
+		t.Run(test.name, func(t *testing.T) {
+			backend := test.new(t)
+
+			bkts, err := backend.ReadBuckets()
+			if err != nil || len(bkts) != 0 {
+				t.Fatalf("ReadBuckets of a new backend = %v, %v", bkts, err)
+			}
+			err = backend.CreateBucket("photos")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = backend.CreateBucket("photos")
+			if !errors.Is(err, ErrBucketExists) {
+				t.Errorf("CreateBucket of existing storage = %v, want ErrBucketExists", err)
+			}
+			objs, err := backend.ReadObjects("photos")
+			if err != nil || len(objs) != 0 {
+				t.Errorf("ReadObjects of a new bucket = %v, %v", objs, err)
+			}
+
+			pending, err := backend.NewObject("photos")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(pending, "meow")
+			err = pending.Commit("cats/tom.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pending.Abort()
+			content, err := backend.OpenObject("photos", "cats/tom.jpg")
+			if got := readContent(t, content, err); got != "meow" {
+				t.Errorf("committed content = %q, want meow", got)
+			}
+
+			pending, err = backend.NewObject("photos")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(pending, "woof")
+			pending.Abort()
+			content, err = backend.OpenObject("photos", "cats/tom.jpg")
+			if got := readContent(t, content, err); got != "meow" {
+				t.Errorf("content after an aborted write = %q, want meow", got)
+			}
+
+			want := []Object{{Key: "cats/tom.jpg", Size: 4, ContentType: "image/jpeg", ETag: "4a4be40c96ac6314e91d93f38043a634", Owner: "AKIAEXAMPLE"}}
+			err = backend.WriteObjects("photos", want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			objs, err = backend.ReadObjects("photos")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(objs) != 1 || objs[0].Key != want[0].Key || objs[0].Size != want[0].Size || objs[0].ETag != want[0].ETag || objs[0].Owner != want[0].Owner {
+				t.Errorf("ReadObjects = %+v, want %+v", objs, want)
+			}
+
+			err = backend.RemoveObject("photos", "cats/tom.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = backend.OpenObject("photos", "cats/tom.jpg")
+			if err == nil {
+				t.Error("OpenObject of removed content succeeded")
+			}
+			err = backend.RemoveObject("photos", "cats/tom.jpg")
+			if err != nil {
+				t.Errorf("RemoveObject of missing content = %v, want nil", err)
+			}
+
+			data, err := backend.ReadConfig("photos")
+			if err != nil || data != nil {
+				t.Errorf("ReadConfig without a configuration = %q, %v", data, err)
+			}
+			err = backend.WriteConfig("photos", []byte(`{"compression":"gzip"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err = backend.ReadConfig("photos")
+			if err != nil || string(data) != `{"compression":"gzip"}` {
+				t.Errorf("ReadConfig = %q, %v", data, err)
+			}
+			err = backend.RemoveConfig("photos")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err = backend.ReadConfig("photos")
+			if err != nil || data != nil {
+				t.Errorf("ReadConfig after RemoveConfig = %q, %v", data, err)
+			}
+			data, err = backend.ReadUserQuotas()
+			if err != nil || data != nil {
+				t.Errorf("ReadUserQuotas without quotas = %q, %v", data, err)
+			}
+
+			err = backend.WriteObjects("photos", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = backend.RemoveBucket("photos")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = backend.CreateBucket("photos")
+			if err != nil {
+				t.Errorf("CreateBucket after RemoveBucket = %v", err)
+			}
+			if err := backend.Check(); err != nil {
+				t.Errorf("Check = %v", err)
+			}
+		})
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *Store) {
+		_, err := store.CreateBucket("photos", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"b.jpg", "a.jpg", "cats/tom.jpg", "cats/felix.jpg", "dogs/rex.jpg", "c.jpg"} {
+			putString(t, store, "photos", key, key)
+		}
+
+		keys := func(result ListResult) []string {
+			var keys []string
+			for _, obj := range result.Objects {
+				keys = append(keys, obj.Key)
+			}
+			return keys
+		}
+		tests := []struct {
+			opts     ListOptions
+			keys     []string
+			prefixes []string
+			next     string
+		}{
+			{ListOptions{}, []string{"a.jpg", "b.jpg", "c.jpg", "cats/felix.jpg", "cats/tom.jpg", "dogs/rex.jpg"}, nil, ""},
+			{ListOptions{Prefix: "cats/"}, []string{"cats/felix.jpg", "cats/tom.jpg"}, nil, ""},
+			{ListOptions{Delimiter: "/"}, []string{"a.jpg", "b.jpg", "c.jpg"}, []string{"cats/", "dogs/"}, ""},
+			{ListOptions{MaxKeys: 2}, []string{"a.jpg", "b.jpg"}, nil, "b.jpg"},
+			{ListOptions{StartAfter: "b.jpg", MaxKeys: 2}, []string{"c.jpg", "cats/felix.jpg"}, nil, "cats/felix.jpg"},
+			{ListOptions{Delimiter: "/", StartAfter: "c.jpg", MaxKeys: 1}, nil, []string{"cats/"}, "cats/"},
+			{ListOptions{Delimiter: "/", StartAfter: "cats/"}, nil, []string{"dogs/"}, ""},
+		}
+		for _, test := range tests {
+			result, err := store.ListObjects("photos", test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(keys(result), test.keys) || !slices.Equal(result.CommonPrefixes, test.prefixes) || result.NextStartAfter != test.next || result.IsTruncated != (test.next != "") {
+				t.Errorf("ListObjects(%+v) = %v %v truncated %v after %q, want %v %v after %q",
+					test.opts, keys(result), result.CommonPrefixes, result.IsTruncated, result.NextStartAfter, test.keys, test.prefixes, test.next)
+			}
+		}
+
+		_, err = store.ListObjects("nothing", ListOptions{})
+		if !errors.Is(err, ErrBucketNotFound) {
+			t.Errorf("ListObjects of a missing bucket = %v, want ErrBucketNotFound", err)
+		}
+	})
+}
+
+func TestKeyConflicts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *Store) {
+		_, err := store.CreateBucket("photos", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putString(t, store, "photos", "cats/tom.jpg", "meow")
+
+		for _, key := range []string{"cats", "cats/tom.jpg/small"} {
+			_, err = store.PutObject("photos", key, strings.NewReader("x"), PutOptions{})
+			if !errors.Is(err, ErrInvalidObjectKey) {
+				t.Errorf("PutObject(%q) next to cats/tom.jpg = %v, want ErrInvalidObjectKey", key, err)
+			}
+		}
+		// Keys sharing a prefix which is not a path segment do not
+		// conflict.
+		putString(t, store, "photos", "cats.jpg", "x")
+		putString(t, store, "photos", "cats/tom.jpeg", "x")
+
+		// Once the conflicting key is deleted the other can be created.
+		err = store.DeleteObject("photos", "cats/tom.jpg", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.DeleteObject("photos", "cats/tom.jpeg", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putString(t, store, "photos", "cats", "now a file")
+		if got := getString(t, store, "photos", "cats"); got != "now a file" {
+			t.Errorf("content = %q", got)
+		}
+	})
+}
@@ -0,0 +1,571 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// backupBatch is the number of objects whose content Backup opens at once.
+const backupBatch = 64
+
+// bucketSnapshot is what Backup records of a bucket before copying its
+// objects.
+type bucketSnapshot struct {
+	bucket Bucket
+	keys   []string
+	config []byte
+}
+
+// Backup writes a tar archive of the active buckets with their objects and
+// configuration, and of the user quotas, to w while the store keeps
+// serving requests. The archive has the layout of a data directory:
+// buckets.csv and the user quotas first, then the objects of every bucket
+// followed by its configuration and objects.csv.
+//
+// The buckets and their keys are taken at the start. Objects are copied
+// in small batches opened under the read lock, so every archived object
+// matches its row in objects.csv; an object replaced during the backup is
+// archived in its new version and one deleted is left out. Every content
+// is checked against its ETag, and the ETags of objects stored before
+// digests were recorded are filled in. Encrypted content is archived as it
+// is stored and only checked by its size. An object whose content does not
+// match is left out of objects.csv, so that it is not restored, and Backup
+// returns the names of such objects as bucket/key.
+func (s *Store) Backup(w io.Writer) ([]string, error) {
+	snapshots, quotas, err := s.snapshotBuckets()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tw := tar.NewWriter(w)
+
+	bkts := make([]Bucket, 0, len(snapshots))
+	for _, snap := range snapshots {
+		bkts = append(bkts, snap.bucket)
+	}
+	err = writeCSVEntry(tw, bucketMetadataFile, bucketHeader, bucketRecords(bkts), now)
+	if err != nil {
+		return nil, err
+	}
+	if quotas != nil {
+		err = writeEntry(tw, systemDir+"/"+userQuotasFile, quotas, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var skipped []string
+
+	for _, snap := range snapshots {
+		bucketName := snap.bucket.Name
+		objs := make([]Object, 0, len(snap.keys))
+		for start := 0; start < len(snap.keys); start += backupBatch {
+			batch, contents, err := s.openObjects(bucketName, snap.keys[start:min(start+backupBatch, len(snap.keys))])
+			if err != nil {
+				return skipped, err
+			}
+			for i := range batch {
+				matches := false
+				if err == nil {
+					matches, err = writeObjectEntry(tw, bucketName, &batch[i], contents[i])
+				}
+				contents[i].Close()
+				if err == nil && matches {
+					objs = append(objs, batch[i])
+				} else if err == nil {
+					skipped = append(skipped, bucketName+"/"+batch[i].Key)
+				}
+			}
+			if err != nil {
+				return skipped, err
+			}
+		}
+
+		if snap.config != nil {
+			err = writeEntry(tw, systemDir+"/buckets/"+bucketName+".json", snap.config, now)
+			if err != nil {
+				return skipped, err
+			}
+		}
+		err = writeCSVEntry(tw, bucketName+"/"+objectMetadataFile, objectHeader, objectRecords(objs), now)
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, tw.Close()
+}
+
+// snapshotBuckets returns the active buckets with the keys of their
+// objects and their configuration, and the encoded user quotas.
+func (s *Store) snapshotBuckets() ([]bucketSnapshot, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return nil, nil, err
+	}
+	quotas, err := s.backend.ReadUserQuotas()
+	if err != nil {
+		return nil, nil, wrap(ErrConfigurationError, "Could not read user quotas", err)
+	}
+	var snapshots []bucketSnapshot
+	for _, bkt := range bkts {
+		if bkt.Status != StatusActive {
+			continue
+		}
+		objs, err := s.readObjects(bkt.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		snap := bucketSnapshot{bucket: bkt}
+		for _, obj := range objs {
+			snap.keys = append(snap.keys, obj.Key)
+		}
+		snap.config, err = s.backend.ReadConfig(bkt.Name)
+		if err != nil {
+			return nil, nil, wrap(ErrConfigurationError, "Could not read bucket configuration", err)
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return snapshots, quotas, nil
+}
+
+// openObjects returns the rows and open contents of those of the given
+// objects of a bucket which still exist. They are opened together under
+// the read lock, so every content matches its row.
+func (s *Store) openObjects(bucketName string, keys []string) ([]Object, []io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, err := s.bucket(bucketName)
+	if errors.Is(err, ErrBucketNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows := make(map[string]Object, len(objs))
+	for _, obj := range objs {
+		rows[obj.Key] = obj
+	}
+
+	var found []Object
+	var contents []io.ReadCloser
+	for _, key := range keys {
+		obj, ok := rows[key]
+		if !ok {
+			continue
+		}
+		content, err := s.backend.OpenObject(bucketName, key)
+		if err != nil {
+			for _, c := range contents {
+				c.Close()
+			}
+			return nil, nil, wrap(ErrObjectAccess, "Could not access object "+bucketName+"/"+key, err)
+		}
+		found = append(found, obj)
+		contents = append(contents, content)
+	}
+	return found, contents, nil
+}
+
+// zeros reads as an endless run of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// writeObjectEntry archives the stored content of an object, checks it
+// against the object's size and ETag and fills in a missing ETag. It
+// reports whether the content matches; content which does not is still
+// archived, cut or padded to its recorded size, so that the archive stays
+// readable.
+func writeObjectEntry(tw *tar.Writer, bucketName string, obj *Object, content io.Reader) (bool, error) {
+	name := bucketName + "/" + obj.Key
+	size := storedSize(*obj)
+	err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: size, ModTime: obj.LastModified})
+	if err != nil {
+		return false, err
+	}
+	archived := &countingWriter{w: tw}
+	recorded := io.LimitReader(content, size)
+	matches := true
+	if obj.Encryption != "" {
+		// Encrypted content is archived as it is stored, and
+		// authenticated when it is read.
+		_, err = io.Copy(archived, recorded)
+	} else {
+		var originalSize int64
+		var etag string
+		originalSize, etag, err = contentDigest(io.TeeReader(recorded, archived), obj.Compression)
+		if errors.Is(err, errCorruptContent) {
+			matches, err = false, nil
+		} else if err == nil {
+			matches = originalSize == obj.Size && (obj.ETag == "" || obj.ETag == etag)
+		}
+		if err == nil {
+			_, err = io.Copy(archived, recorded)
+		}
+		if matches {
+			obj.ETag = etag
+		}
+	}
+	if err != nil {
+		return false, wrap(ErrObjectAccess, "Could not back up object "+name, err)
+	}
+	if archived.n < size {
+		matches = false
+		_, err = io.CopyN(archived, zeros{}, size-archived.n)
+		if err != nil {
+			return false, err
+		}
+	}
+	if n, _ := io.CopyN(io.Discard, content, 1); n > 0 {
+		matches = false
+	}
+	return matches, nil
+}
+
+func writeCSVEntry(tw *tar.Writer, name string, header []string, records [][]string, modTime time.Time) error {
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	err := csvWriter.Write(header)
+	if err == nil {
+		err = csvWriter.WriteAll(records)
+	}
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, name, buf.Bytes(), modTime)
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	return err
+}
+
+// restoredBucket is a bucket being restored.
+type restoredBucket struct {
+	bucket  Bucket
+	created bool
+	done    bool
+	// objects holds the size and digest of every restored content as it
+	// is stored.
+	objects map[string]Object
+	config  []byte
+}
+
+// Restore recreates buckets from an archive written by Backup. If
+// bucketName is not empty, only that bucket is restored; otherwise the
+// archived user quotas are restored as well, for users without a quota.
+// The restored buckets must not exist yet. Every object is checked against
+// the ETag recorded for it, and archived content not listed in objects.csv
+// is dropped; a bucket becomes visible only once all of its objects are
+// restored, and a bucket whose restore fails is removed again. Restore
+// returns the names of the restored buckets.
+func (s *Store) Restore(r io.Reader, bucketName string) ([]string, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err == nil && hdr.Name != bucketMetadataFile {
+		err = errors.New("archive does not begin with " + bucketMetadataFile)
+	}
+	var bkts []Bucket
+	if err == nil {
+		var records [][]string
+		records, err = parseCSV(tr)
+		if err == nil {
+			bkts, err = parseBucketRecords(records)
+		}
+	}
+	if err != nil {
+		return nil, wrap(ErrInvalidArgument, "Could not read the backup", err)
+	}
+
+	pending := make(map[string]*restoredBucket)
+	var order []string
+	for _, bkt := range bkts {
+		if bkt.Status != StatusActive || (bucketName != "" && bkt.Name != bucketName) {
+			continue
+		}
+		err := ValidateBucketName(bkt.Name)
+		if err != nil {
+			return nil, err
+		}
+		pending[bkt.Name] = &restoredBucket{bucket: bkt, objects: make(map[string]Object)}
+		order = append(order, bkt.Name)
+	}
+	if bucketName != "" && len(order) == 0 {
+		return nil, wrap(ErrBucketNotFound, "The backup has no bucket "+bucketName, nil)
+	}
+	for _, name := range order {
+		_, err := s.Bucket(name)
+		if err == nil {
+			return nil, wrap(ErrBucketExists, "Bucket "+name+" already exists", nil)
+		}
+		if !errors.Is(err, ErrBucketNotFound) {
+			return nil, err
+		}
+	}
+
+	quotas, err := s.restoreEntries(tr, pending)
+	if err == nil && bucketName == "" && quotas != nil {
+		err = s.restoreUserQuotas(quotas)
+	}
+	for _, name := range order {
+		rb := pending[name]
+		if err == nil && !rb.done {
+			err = wrap(ErrInvalidArgument, "The backup is incomplete: bucket "+name+" has no "+objectMetadataFile, nil)
+		}
+	}
+	var restored []string
+	for _, name := range order {
+		rb := pending[name]
+		if rb.done {
+			restored = append(restored, name)
+		} else if rb.created {
+			s.discardRestore(rb)
+		}
+	}
+	if len(restored) > 0 {
+		s.mu.Lock()
+		countErr := s.countUsage()
+		s.mu.Unlock()
+		if err == nil {
+			err = countErr
+		}
+	}
+	return restored, err
+}
+
+// restoreEntries restores the archive entries following buckets.csv, and
+// returns the archived user quotas.
+func (s *Store) restoreEntries(tr *tar.Reader, pending map[string]*restoredBucket) ([]byte, error) {
+	var quotas []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return quotas, nil
+		}
+		if err != nil {
+			return nil, wrap(ErrInvalidArgument, "Could not read the backup", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		first, rest, _ := strings.Cut(hdr.Name, "/")
+		if first == systemDir {
+			name, ok := strings.CutPrefix(rest, "buckets/")
+			name, isConfig := strings.CutSuffix(name, ".json")
+			if rb := pending[name]; ok && isConfig && rb != nil {
+				rb.config, err = io.ReadAll(tr)
+			} else if rest == userQuotasFile {
+				quotas, err = io.ReadAll(tr)
+			}
+			if err != nil {
+				return nil, wrap(ErrInvalidArgument, "Could not read the backup", err)
+			}
+			continue
+		}
+		rb := pending[first]
+		if rb == nil {
+			continue
+		}
+		if rb.done {
+			return nil, wrap(ErrInvalidArgument, "Backup entry "+hdr.Name+" follows the metadata of its bucket", nil)
+		}
+		if !rb.created {
+			err := s.backend.CreateBucket(first)
+			if errors.Is(err, ErrBucketExists) {
+				return nil, wrap(ErrBucketExists, "Bucket "+first+" already exists", nil)
+			}
+			if err != nil {
+				return nil, wrap(ErrBucketAccess, "Could not create bucket "+first, err)
+			}
+			rb.created = true
+		}
+		if rest == objectMetadataFile {
+			err = s.finishRestore(rb, tr)
+		} else {
+			err = s.restoreObject(rb, rest, tr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// restoreUserQuotas adds the archived quotas of users who have none.
+func (s *Store) restoreUserQuotas(data []byte) error {
+	var archived map[string]Quota
+	err := json.Unmarshal(data, &archived)
+	if err != nil {
+		return wrap(ErrInvalidArgument, "Could not read the user quotas of the backup", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	quotas, err := s.readUserQuotas()
+	if err != nil {
+		return err
+	}
+	for user, quota := range archived {
+		if _, ok := quotas[user]; !ok && user != "" && validateQuota(quota) == nil {
+			quotas[user] = quota
+		}
+	}
+	content, err := json.MarshalIndent(quotas, "", "\t")
+	if err == nil {
+		err = s.backend.WriteUserQuotas(content)
+	}
+	if err != nil {
+		return wrap(ErrConfigurationError, "Could not save user quotas", err)
+	}
+	return nil
+}
+
+// restoreObject stores the content of an object of a bucket being
+// restored. The object is only listed by finishRestore.
+func (s *Store) restoreObject(rb *restoredBucket, objectKey string, r io.Reader) error {
+	name := rb.bucket.Name + "/" + objectKey
+	err := validateObjectKey(objectKey)
+	if err != nil {
+		return wrap(ErrInvalidObjectKey, "Backup entry "+name+" is not a valid object", nil)
+	}
+	pending, err := s.backend.NewObject(rb.bucket.Name)
+	if err != nil {
+		return wrap(ErrObjectAccess, "Could not restore object "+name, err)
+	}
+	defer pending.Abort()
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(pending, hash), r)
+	if err == nil {
+		err = pending.Commit(objectKey)
+	}
+	if err != nil {
+		return wrap(ErrObjectAccess, "Could not restore object "+name, err)
+	}
+	rb.objects[objectKey] = Object{Key: objectKey, Size: size, ETag: hex.EncodeToString(hash.Sum(nil))}
+	return nil
+}
+
+// decompressedDigest returns the size and digest of the content a restored
+// compressed object decompresses to. Content which does not decompress
+// is given an empty digest, so that it does not match.
+func (s *Store) decompressedDigest(bucketName string, obj Object) (Object, error) {
+	content, err := s.backend.OpenObject(bucketName, obj.Key)
+	if err != nil {
+		return Object{}, wrap(ErrObjectAccess, "Could not access restored object "+bucketName+"/"+obj.Key, err)
+	}
+	defer content.Close()
+	size, etag, err := contentDigest(content, obj.Compression)
+	if errors.Is(err, errCorruptContent) {
+		return Object{Key: obj.Key, Size: -1}, nil
+	}
+	if err != nil {
+		return Object{}, wrap(ErrObjectAccess, "Could not access restored object "+bucketName+"/"+obj.Key, err)
+	}
+	return Object{Key: obj.Key, Size: size, ETag: etag}, nil
+}
+
+// finishRestore checks the restored objects of a bucket against its
+// objects.csv read from r, writes its metadata and configuration and lists
+// the bucket.
+func (s *Store) finishRestore(rb *restoredBucket, r io.Reader) error {
+	bucketName := rb.bucket.Name
+	records, err := parseCSV(r)
+	var objs []Object
+	if err == nil {
+		objs, err = parseObjectRecords(records)
+	}
+	if err != nil {
+		return wrap(ErrInvalidArgument, "Could not read the object metadata of bucket "+bucketName, err)
+	}
+	listed := make(map[string]bool, len(objs))
+	for _, obj := range objs {
+		listed[obj.Key] = true
+	}
+	// Content left out of objects.csv, as Backup does with content which
+	// does not match its object, is not restored.
+	for key := range rb.objects {
+		if !listed[key] {
+			s.backend.RemoveObject(bucketName, key)
+			delete(rb.objects, key)
+		}
+	}
+	for i, obj := range objs {
+		restored, ok := rb.objects[obj.Key]
+		if obj.Encryption != "" {
+			// Encrypted content can only be checked by its size.
+			if !ok || restored.Size != obj.StoredSize {
+				return wrap(ErrInvalidArgument, "Object "+bucketName+"/"+obj.Key+" does not match its size", nil)
+			}
+			continue
+		}
+		if ok && obj.Compression != "" && restored.Size == obj.StoredSize {
+			restored, err = s.decompressedDigest(bucketName, obj)
+			if err != nil {
+				return err
+			}
+		}
+		if !ok || restored.Size != obj.Size || (obj.ETag != "" && restored.ETag != obj.ETag) {
+			return wrap(ErrInvalidArgument, "Object "+bucketName+"/"+obj.Key+" does not match its checksum", nil)
+		}
+		objs[i].ETag = restored.ETag
+	}
+
+	err = s.writeObjects(bucketName, objs)
+	if err != nil {
+		return err
+	}
+	if rb.config != nil {
+		err = s.backend.WriteConfig(bucketName, rb.config)
+		if err != nil {
+			return wrap(ErrConfigurationError, "Could not save bucket configuration", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return err
+	}
+	// A row of a deleted bucket with the same name is replaced.
+	kept := bkts[:0]
+	for _, bkt := range bkts {
+		if bkt.Name != bucketName {
+			kept = append(kept, bkt)
+		}
+	}
+	err = s.writeBuckets(append(kept, rb.bucket))
+	if err != nil {
+		return err
+	}
+	rb.done = true
+	return nil
+}
+
+// discardRestore removes what was restored of a bucket whose restore
+// failed.
+func (s *Store) discardRestore(rb *restoredBucket) {
+	for key := range rb.objects {
+		s.backend.RemoveObject(rb.bucket.Name, key)
+	}
+	s.backend.RemoveConfig(rb.bucket.Name)
+	s.backend.RemoveBucket(rb.bucket.Name)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBackupSkipsCorruptObjects(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestDir(t, dir)
+	createTestBucket(t, store, "photos")
+	putString(t, store, "photos", "good.txt", "meow")
+	putString(t, store, "photos", "flipped.txt", "purr")
+	putString(t, store, "photos", "short.txt", "hiss")
+	err := store.SetUserQuota("AKIAEXAMPLE", &Quota{MaxObjects: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Content of the same size which does not match the ETag, and content
+	// shorter than recorded.
+	err = os.WriteFile(filepath.Join(dir, "photos", "flipped.txt"), []byte("purs"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "photos", "short.txt"), []byte("hi"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	skipped, err := store.Backup(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(skipped, []string{"photos/flipped.txt", "photos/short.txt"}) {
+		t.Errorf("Backup skipped %v, want photos/flipped.txt and photos/short.txt", skipped)
+	}
+
+	restored := openTestDir(t, t.TempDir())
+	err = restored.SetUserQuota("AKIAOTHER", &Quota{MaxBytes: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bkts, err := restored.Restore(&archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(bkts, []string{"photos"}) {
+		t.Errorf("Restore = %v, want photos", bkts)
+	}
+	result, err := restored.ListObjects("photos", ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != 1 || result.Objects[0].Key != "good.txt" {
+		t.Errorf("restored objects = %+v, want good.txt", result.Objects)
+	}
+	if got := getString(t, restored, "photos", "good.txt"); got != "meow" {
+		t.Errorf("restored content = %q, want meow", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photos", "flipped.txt")); err != nil {
+		t.Errorf("skipped content was removed from the backed up store: %v", err)
+	}
+	if _, err := restored.backend.OpenObject("photos", "short.txt"); err == nil {
+		t.Error("content of a skipped object was restored")
+	}
+
+	quotas, err := restored.UserQuotas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Quota{"AKIAEXAMPLE": {MaxObjects: 10}, "AKIAOTHER": {MaxBytes: 100}}
+	got := make(map[string]Quota)
+	for _, status := range quotas {
+		got[status.Name] = status.Quota
+	}
+	if len(got) != len(want) || got["AKIAEXAMPLE"] != want["AKIAEXAMPLE"] || got["AKIAOTHER"] != want["AKIAOTHER"] {
+		t.Errorf("restored user quotas = %v, want %v", got, want)
+	}
+}
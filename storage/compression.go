@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CompressionGzip stores the content of new objects gzip-compressed. It is
+// the only supported algorithm: zstd would need a dependency outside the
+// standard library.
+const CompressionGzip = "gzip"
+
+// errCorruptContent is returned for stored content which cannot be
+// decompressed.
+var errCorruptContent = errors.New("compressed content is corrupt")
+
+// SetBucketCompression makes new objects of a bucket be stored compressed
+// with algorithm, or uncompressed when it is empty. Existing objects keep
+// the way they are stored until they are rewritten.
+func (s *Store) SetBucketCompression(bucketName string, algorithm string) error {
+	switch algorithm {
+	case "", CompressionGzip:
+	case "zstd":
+		return wrap(ErrInvalidArgument, "zstd compression is not supported; use gzip", nil)
+	default:
+		return wrap(ErrInvalidArgument, "Compression algorithm must be gzip or empty", nil)
+	}
+	return s.UpdateBucketConfig(bucketName, func(cfg *BucketConfig) error {
+		cfg.Compression = algorithm
+		return nil
+	})
+}
+
+// transformed reports whether the content of obj is stored compressed or
+// encrypted rather than as it is.
+func transformed(obj Object) bool {
+	return obj.Compression != "" || obj.Encryption != ""
+}
+
+// storedSize returns the size of the content of obj as it is stored.
+func storedSize(obj Object) int64 {
+	if transformed(obj) {
+		return obj.StoredSize
+	}
+	return obj.Size
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// contentDigest reads stored content and returns the size and hex-encoded
+// MD5 digest of the content it decompresses to.
+func contentDigest(r io.Reader, compression string) (int64, string, error) {
+	if compression != "" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, "", fmt.Errorf("%w: %v", errCorruptContent, err)
+		}
+		defer gz.Close()
+		size, digest, err := contentDigest(gz, "")
+		if err != nil {
+			err = fmt.Errorf("%w: %v", errCorruptContent, err)
+		}
+		return size, digest, err
+	}
+	hash := md5.New()
+	size, err := io.Copy(hash, r)
+	return size, hex.EncodeToString(hash.Sum(nil)), err
+}
+
+// EncodedContent is implemented by the content of objects stored
+// compressed. It reads as the original content; Encoded gives the
+// compressed bytes, which a client accepting the encoding can be sent as
+// they are.
+type EncodedContent interface {
+	io.ReadSeekCloser
+	// Encoding is the HTTP content coding of the compressed bytes.
+	Encoding() string
+	Encoded() io.ReadSeeker
+	EncodedSize() int64
+}
+
+// openContent returns the original content of obj from its stored content,
+// which it closes on failure. customerKey is the key given to read an
+// SSE-C object.
+func (s *Store) openContent(obj Object, stored io.ReadSeekCloser, customerKey []byte) (io.ReadSeekCloser, error) {
+	content := stored
+	encodedSize := obj.StoredSize
+	if obj.Encryption != "" {
+		dataKey, err := s.dataKey(obj, customerKey)
+		if err != nil {
+			stored.Close()
+			return nil, err
+		}
+		decrypted, err := newDecryptContent(stored, dataKey, obj.StoredSize)
+		if err != nil {
+			stored.Close()
+			return nil, wrap(ErrObjectAccess, "Could not decrypt object", err)
+		}
+		content = decrypted
+		encodedSize = decrypted.size
+	} else if customerKey != nil {
+		stored.Close()
+		return nil, wrap(ErrInvalidArgument, "The object is not encrypted with a customer-provided key", nil)
+	}
+	if obj.Compression == "" {
+		return content, nil
+	}
+	return &gzipContent{stored: content, size: obj.Size, encodedSize: encodedSize}, nil
+}
+
+// gzipContent decompresses stored content on the fly. Seeking is lazy:
+// reading after a seek forward skips decompressed bytes and reading after
+// a seek backward starts over, so a range costs decompressing everything
+// before it.
+type gzipContent struct {
+	stored io.ReadSeekCloser
+	gz     *gzip.Reader
+	size   int64
+	// encodedSize is the size of the compressed content.
+	encodedSize int64
+	// pos is the offset gz has reached, and offset the one to read from.
+	pos    int64
+	offset int64
+}
+
+func (c *gzipContent) Read(p []byte) (int, error) {
+	if c.offset >= c.size {
+		return 0, io.EOF
+	}
+	if c.gz == nil || c.offset < c.pos {
+		_, err := c.stored.Seek(0, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		if c.gz == nil {
+			c.gz, err = gzip.NewReader(c.stored)
+		} else {
+			err = c.gz.Reset(c.stored)
+		}
+		if err != nil {
+			return 0, err
+		}
+		c.pos = 0
+	}
+	if c.offset > c.pos {
+		skipped, err := io.CopyN(io.Discard, c.gz, c.offset-c.pos)
+		c.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.gz.Read(p)
+	c.pos += int64(n)
+	c.offset = c.pos
+	return n, err
+}
+
+func (c *gzipContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to a negative offset")
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+func (c *gzipContent) Close() error {
+	return c.stored.Close()
+}
+
+func (c *gzipContent) Encoding() string {
+	return CompressionGzip
+}
+
+func (c *gzipContent) EncodedSize() int64 {
+	return c.encodedSize
+}
+
+// Encoded rewinds the compressed content and returns it.
+func (c *gzipContent) Encoded() io.ReadSeeker {
+	c.stored.Seek(0, io.SeekStart)
+	return c.stored
+}
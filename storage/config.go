@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// BucketConfig holds the optional per-bucket settings.
+type BucketConfig struct {
+	Logging *LoggingConfig `json:"logging,omitempty"`
+	// Compression is the algorithm new objects are stored compressed
+	// with; empty stores them as they are.
+	Compression string            `json:"compression,omitempty"`
+	Encryption  *EncryptionConfig `json:"encryption,omitempty"`
+	ObjectLock  *ObjectLockConfig `json:"objectLock,omitempty"`
+	Quota       *Quota            `json:"quota,omitempty"`
+	CORS        []CORSRule        `json:"cors,omitempty"`
+	Website     *WebsiteConfig    `json:"website,omitempty"`
+}
+
+// EncryptionConfig is the encryption policy of new objects of a bucket.
+type EncryptionConfig struct {
+	// Default is the encryption of uploads which do not ask for one;
+	// only EncryptionAES256 can be a default.
+	Default string `json:"default,omitempty"`
+	// Require rejects uploads which are not encrypted, either as they ask
+	// or by default.
+	Require bool `json:"require,omitempty"`
+}
+
+// LoggingConfig names where server access logs of a bucket are delivered.
+type LoggingConfig struct {
+	TargetBucket string `json:"targetBucket"`
+	TargetPrefix string `json:"targetPrefix"`
+}
+
+func (s *Store) readBucketConfig(bucketName string) (BucketConfig, error) {
+	var cfg BucketConfig
+	content, err := s.backend.ReadConfig(bucketName)
+	if err == nil && content != nil {
+		err = json.Unmarshal(content, &cfg)
+	}
+	if err != nil {
+		return cfg, wrap(ErrConfigurationError, "Could not read bucket configuration", err)
+	}
+	return cfg, nil
+}
+
+func (s *Store) removeBucketConfig(bucketName string) error {
+	err := s.backend.RemoveConfig(bucketName)
+	if err != nil {
+		return wrap(ErrConfigurationError, "Could not delete bucket configuration", err)
+	}
+	return nil
+}
+
+// BucketConfig returns the configuration of an active bucket.
+func (s *Store) BucketConfig(bucketName string) (BucketConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, err := s.bucket(bucketName)
+	if err != nil {
+		return BucketConfig{}, err
+	}
+	return s.readBucketConfig(bucketName)
+}
+
+// UpdateBucketConfig applies update to the configuration of an active
+// bucket and saves the result unless update returns an error.
+func (s *Store) UpdateBucketConfig(bucketName string, update func(cfg *BucketConfig) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	cfg, err := s.readBucketConfig(bucketName)
+	if err != nil {
+		return err
+	}
+	err = update(&cfg)
+	if err != nil {
+		return err
+	}
+	return s.writeBucketConfig(bucketName, cfg)
+}
+
+func (s *Store) writeBucketConfig(bucketName string, cfg BucketConfig) error {
+	content, err := json.MarshalIndent(cfg, "", "\t")
+	if err == nil {
+		err = s.backend.WriteConfig(bucketName, content)
+	}
+	if err != nil {
+		return wrap(ErrConfigurationError, "Could not save bucket configuration", err)
+	}
+	return nil
+}
+
+// SetBucketLogging enables delivery of the bucket's server access logs
+// into another bucket, or disables it when cfg is nil.
+func (s *Store) SetBucketLogging(bucketName string, cfg *LoggingConfig) error {
+	if cfg != nil {
+		_, err := s.Bucket(cfg.TargetBucket)
+		if errors.Is(err, ErrBucketNotFound) {
+			return ErrInvalidLogTarget
+		}
+		if err != nil {
+			return err
+		}
+		if strings.Contains(cfg.TargetPrefix, "/") || len(cfg.TargetPrefix) > 512 {
+			return wrap(ErrInvalidArgument, "Target prefix must not contain '/' and must be at most 512 characters", nil)
+		}
+	}
+	return s.UpdateBucketConfig(bucketName, func(bc *BucketConfig) error {
+		bc.Logging = cfg
+		return nil
+	})
+}
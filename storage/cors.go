@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+)
+
+// CORSRule allows cross-origin requests to a bucket from browsers. Origins
+// and headers may contain one '*' wildcard.
+type CORSRule struct {
+	ID             string   `json:"id,omitempty"`
+	AllowedOrigins []string `json:"allowedOrigins"`
+	AllowedMethods []string `json:"allowedMethods"`
+	// AllowedHeaders are the headers a preflight request may ask to send.
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// ExposeHeaders are the response headers scripts may read.
+	ExposeHeaders []string `json:"exposeHeaders,omitempty"`
+	// MaxAgeSeconds is how long browsers may cache a preflight response;
+	// 0 leaves it to the browser.
+	MaxAgeSeconds int `json:"maxAgeSeconds,omitempty"`
+}
+
+var corsMethods = []string{"GET", "PUT", "POST", "DELETE", "HEAD"}
+
+func validateCORSRule(rule CORSRule) error {
+	if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+		return wrap(ErrInvalidArgument, "A CORS rule needs at least one allowed origin and method", nil)
+	}
+	for _, method := range rule.AllowedMethods {
+		if !slices.Contains(corsMethods, method) {
+			return wrap(ErrInvalidArgument, "CORS method "+method+" is not supported; use GET, PUT, POST, DELETE or HEAD", nil)
+		}
+	}
+	for _, pattern := range append(rule.AllowedOrigins, rule.AllowedHeaders...) {
+		if strings.Count(pattern, "*") > 1 {
+			return wrap(ErrInvalidArgument, "Allowed origin or header "+pattern+" can contain at most one wildcard", nil)
+		}
+	}
+	if rule.MaxAgeSeconds < 0 {
+		return wrap(ErrInvalidArgument, "MaxAgeSeconds must not be negative", nil)
+	}
+	if len(rule.ID) > 255 {
+		return wrap(ErrInvalidArgument, "CORS rule ID must be at most 255 characters", nil)
+	}
+	return nil
+}
+
+// SetBucketCORS replaces the CORS rules of a bucket, or removes them when
+// rules is nil.
+func (s *Store) SetBucketCORS(bucketName string, rules []CORSRule) error {
+	if rules != nil && (len(rules) == 0 || len(rules) > 100) {
+		return wrap(ErrInvalidArgument, "A CORS configuration needs between 1 and 100 rules", nil)
+	}
+	for _, rule := range rules {
+		err := validateCORSRule(rule)
+		if err != nil {
+			return err
+		}
+	}
+	return s.UpdateBucketConfig(bucketName, func(cfg *BucketConfig) error {
+		cfg.CORS = rules
+		return nil
+	})
+}
+
+// MatchCORS returns the first rule which allows a request from origin with
+// method and the given request headers.
+func MatchCORS(rules []CORSRule, origin string, method string, headers []string) (CORSRule, bool) {
+	for _, rule := range rules {
+		if rule.allows(origin, method, headers) {
+			return rule, true
+		}
+	}
+	return CORSRule{}, false
+}
+
+func (rule CORSRule) allows(origin string, method string, headers []string) bool {
+	if !slices.Contains(rule.AllowedMethods, method) {
+		return false
+	}
+	if !matchAny(rule.AllowedOrigins, origin, false) {
+		return false
+	}
+	for _, header := range headers {
+		if !matchAny(rule.AllowedHeaders, header, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether value matches one of patterns, each of which may
+// contain one '*' standing for any text.
+func matchAny(patterns []string, value string, ignoreCase bool) bool {
+	for _, pattern := range patterns {
+		if ignoreCase {
+			pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+		}
+		prefix, suffix, wildcard := strings.Cut(pattern, "*")
+		if !wildcard && pattern == value {
+			return true
+		}
+		if wildcard && len(value) >= len(prefix)+len(suffix) && strings.HasPrefix(value, prefix) && strings.HasSuffix(value, suffix) {
+			return true
+		}
+	}
+	return false
+}
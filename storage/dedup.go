@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore is implemented by backends which store identical content once,
+// as a blob shared by every object with that content.
+type BlobStore interface {
+	// CollectGarbage removes the blobs which no object refers to anymore
+	// and returns their number and size.
+	CollectGarbage() (int, int64, error)
+	BlobStats() (BlobStats, error)
+}
+
+type BlobStats struct {
+	Blobs int
+	// StoredBytes is the size of the blobs, and LogicalBytes the size of
+	// the object content referring to them, counting snapshots.
+	StoredBytes  int64
+	LogicalBytes int64
+}
+
+// errNoBlobs is returned by the BlobStore methods of a directory backend
+// which does not deduplicate content.
+var errNoBlobs = errors.New("deduplication is not enabled")
+
+// Ratio returns how many bytes of content are stored per byte of blob
+// storage, or 1 if no blob is stored.
+func (s BlobStats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+	return float64(s.LogicalBytes) / float64(s.StoredBytes)
+}
+
+// NewDedupBackend returns a directory backend like NewFSBackend which
+// stores the content of new objects once per SHA-256 digest in
+// .triple-s/blobs. Object files are hard links to their blob, so the link
+// count of a blob is its reference count, kept by the file system; a blob
+// with no link but its own is garbage. Objects stored before are
+// deduplicated once they are rewritten.
+func NewDedupBackend(dir string) (Backend, error) {
+	b, err := NewFSBackend(dir)
+	if err != nil {
+		return nil, err
+	}
+	b.(*fsBackend).dedup = true
+	return b, nil
+}
+
+func (b *fsBackend) blobsDir() string {
+	return filepath.Join(b.dir, systemDir, "blobs")
+}
+
+func (b *fsBackend) blobPath(digest string) string {
+	return filepath.Join(b.blobsDir(), digest[:2], digest)
+}
+
+// blobPendingObject hashes the content while it is written, so that it can
+// be committed as a link to the blob with that digest.
+type blobPendingObject struct {
+	*fsPendingObject
+	hash hash.Hash
+}
+
+func (p *blobPendingObject) Write(data []byte) (int, error) {
+	n, err := p.fsPendingObject.Write(data)
+	p.hash.Write(data[:n])
+	return n, err
+}
+
+// Commit makes the written file the blob of its digest unless the blob
+// exists already, and links the blob to the object file. A blob collected
+// between finding and linking it is stored again from the written file.
+func (p *blobPendingObject) Commit(objectKey string) error {
+	err := p.Sync()
+	closeErr := p.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(p.Name())
+		return err
+	}
+
+	blob := p.backend.blobPath(hex.EncodeToString(p.hash.Sum(nil)))
+	err = os.MkdirAll(filepath.Dir(blob), 0o755)
+	file := p.Name()
+	for err == nil {
+		err = os.Link(p.Name(), blob)
+		if err == nil {
+			err = syncDir(filepath.Dir(blob))
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+		// The content is stored already; the object becomes another
+		// link to it and the written file is dropped.
+		file = p.Name() + ".blob"
+		err = os.Link(blob, file)
+		if err == nil {
+			os.Remove(p.Name())
+			break
+		}
+		file = p.Name()
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	}
+	if err != nil {
+		os.Remove(p.Name())
+		return err
+	}
+	return p.commitFile(file, objectKey)
+}
+
+// walkBlobs calls fn for every blob with its file information.
+func (b *fsBackend) walkBlobs(fn func(path string, info fs.FileInfo) error) error {
+	err := filepath.WalkDir(b.blobsDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(path, info)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CollectGarbage removes the blobs without links. It does not need the
+// store to be locked: a blob linked by an upload while it is removed only
+// stops being shared, as the object keeps its own link to the content.
+func (b *fsBackend) CollectGarbage() (int, int64, error) {
+	if !b.dedup {
+		return 0, 0, errNoBlobs
+	}
+	removed := 0
+	var freed int64
+	err := b.walkBlobs(func(path string, info fs.FileInfo) error {
+		if linkCount(info) != 1 {
+			return nil
+		}
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+func (b *fsBackend) BlobStats() (BlobStats, error) {
+	if !b.dedup {
+		return BlobStats{}, errNoBlobs
+	}
+	var stats BlobStats
+	err := b.walkBlobs(func(path string, info fs.FileInfo) error {
+		links := linkCount(info)
+		if links < 2 {
+			return nil
+		}
+		stats.Blobs++
+		stats.StoredBytes += info.Size()
+		stats.LogicalBytes += info.Size() * int64(links-1)
+		return nil
+	})
+	return stats, err
+}
+
+// CollectGarbage removes the blobs of a BlobStore backend which no object
+// refers to anymore and returns their number and size. Other backends,
+// and directory backends without deduplication, have nothing to collect.
+func (s *Store) CollectGarbage() (int, int64, error) {
+	blobs, ok := s.backend.(BlobStore)
+	if !ok {
+		return 0, 0, nil
+	}
+	removed, freed, err := blobs.CollectGarbage()
+	if errors.Is(err, errNoBlobs) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return removed, freed, wrap(ErrObjectAccess, "Could not collect unreferenced blobs", err)
+	}
+	return removed, freed, nil
+}
+
+// BlobStats returns the deduplication statistics of a BlobStore backend,
+// or nil for other backends and directory backends without deduplication.
+func (s *Store) BlobStats() (*BlobStats, error) {
+	blobs, ok := s.backend.(BlobStore)
+	if !ok {
+		return nil, nil
+	}
+	stats, err := blobs.BlobStats()
+	if errors.Is(err, errNoBlobs) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrap(ErrObjectAccess, "Could not read blob statistics", err)
+	}
+	return &stats, nil
+}
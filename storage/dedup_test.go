@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := NewDedupBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := New(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestBucket(t, store, "photos")
+	putString(t, store, "photos", "tom.jpg", "meow")
+	putString(t, store, "photos", "felix.jpg", "meow")
+	// Writing the content an object links to already leaves no
+	// temporary file behind.
+	putString(t, store, "photos", "tom.jpg", "meow")
+	tmp, err := os.ReadDir(filepath.Join(dir, systemDir, "tmp"))
+	if err != nil || len(tmp) != 0 {
+		t.Errorf("temporary files = %v, %v, want none", tmp, err)
+	}
+
+	stats, err := store.BlobStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats == nil || stats.Blobs != 1 || stats.StoredBytes != 4 || stats.LogicalBytes != 8 {
+		t.Errorf("BlobStats = %+v, want 1 blob of 4 bytes for 8", stats)
+	}
+	err = store.DeleteObject("photos", "tom.jpg", false)
+	if err == nil {
+		err = store.DeleteObject("photos", "felix.jpg", false)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, freed, err := store.CollectGarbage()
+	if err != nil || removed != 1 || freed != 4 {
+		t.Errorf("CollectGarbage = %d, %d, %v, want 1 blob of 4 bytes", removed, freed, err)
+	}
+}
+
+func TestNoDedup(t *testing.T) {
+	store := openTestDir(t, t.TempDir())
+	stats, err := store.BlobStats()
+	if stats != nil || err != nil {
+		t.Errorf("BlobStats without dedup = %+v, %v, want nil", stats, err)
+	}
+	removed, freed, err := store.CollectGarbage()
+	if removed != 0 || freed != 0 || err != nil {
+		t.Errorf("CollectGarbage without dedup = %d, %d, %v, want nothing", removed, freed, err)
+	}
+}
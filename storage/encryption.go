@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Encryption modes recorded for objects encrypted at rest. The data key of
+// an AES256 (SSE-S3) object is wrapped by a master key of the store's
+// keyring, and that of a customer-key (SSE-C) object by the key its client
+// provides, which is never stored.
+const (
+	EncryptionAES256   = "AES256"
+	EncryptionCustomer = "SSE-C"
+)
+
+// encryptionChunk is the size of the plaintext chunks sealed separately,
+// so that a range can be decrypted without the content before it.
+const encryptionChunk = 64 << 10
+
+// Encryption asks for an object to be encrypted at rest, or gives the key
+// of an SSE-C object to read it.
+type Encryption struct {
+	// Mode is EncryptionAES256, EncryptionCustomer or empty.
+	Mode string
+	// CustomerKey is the 256-bit key of an SSE-C object.
+	CustomerKey []byte
+}
+
+// Keyring holds the master keys wrapping the data keys of SSE-S3 objects.
+// New objects use the current key; the others are kept to read objects
+// stored before a rotation.
+type Keyring struct {
+	keys    map[string][]byte
+	current string
+}
+
+// ParseKeyring reads master keys given one per line as id:base64, where
+// the key decodes to 32 bytes. The last key is the current one. Blank
+// lines and lines starting with '#' are ignored.
+func ParseKeyring(text string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok || id == "" {
+			return nil, errors.New("master key is not of the form id:base64")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("master key " + id + " is not 32 base64-encoded bytes")
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, errors.New("master key " + id + " is given more than once")
+		}
+		k.keys[id] = key
+		k.current = id
+	}
+	if k.current == "" {
+		return nil, errors.New("no master key is given")
+	}
+	return k, nil
+}
+
+// Current returns the id of the key new objects are encrypted with.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// SetKeyring enables SSE-S3 with the master keys of k, replacing those
+// given before.
+func (s *Store) SetKeyring(k *Keyring) {
+	s.keyring.Store(k)
+}
+
+// SetBucketEncryption sets the encryption policy of new objects of a
+// bucket, or removes it when cfg is nil. Existing objects are not
+// changed.
+func (s *Store) SetBucketEncryption(bucketName string, cfg *EncryptionConfig) error {
+	if cfg != nil {
+		switch {
+		case cfg.Default != "" && cfg.Default != EncryptionAES256:
+			return wrap(ErrInvalidArgument, "The default encryption must be AES256", nil)
+		case cfg.Default == "" && !cfg.Require:
+			return wrap(ErrInvalidArgument, "The encryption configuration must set a default or require encryption", nil)
+		case cfg.Default != "" && s.keyring.Load() == nil:
+			return ErrEncryptionNotConfigured
+		}
+	}
+	return s.UpdateBucketConfig(bucketName, func(bc *BucketConfig) error {
+		bc.Encryption = cfg
+		return nil
+	})
+}
+
+// bucketEncryption applies the encryption policy of a bucket to an
+// upload asking for enc.
+func bucketEncryption(cfg *EncryptionConfig, enc Encryption) (Encryption, error) {
+	if enc.Mode != "" || cfg == nil {
+		return enc, nil
+	}
+	if cfg.Default != "" {
+		return Encryption{Mode: cfg.Default}, nil
+	}
+	if cfg.Require {
+		return enc, ErrEncryptionRequired
+	}
+	return enc, nil
+}
+
+// newDataKey returns a random data key and the key wrapping it for a
+// new object, recording the wrapped key in obj.
+func (s *Store) newDataKey(enc Encryption, obj *Object) ([]byte, error) {
+	if k := s.keyring.Load(); enc.Mode == EncryptionAES256 && k != nil {
+		obj.MasterKeyId = k.current
+	}
+	kek, err := s.keyEncryptionKey(enc, obj.MasterKeyId)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, 32)
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	obj.Encryption = enc.Mode
+	obj.WrappedKey, err = wrapKey(kek, dataKey)
+	return dataKey, err
+}
+
+// keyEncryptionKey returns the key wrapping the data keys of objects
+// encrypted with enc.
+func (s *Store) keyEncryptionKey(enc Encryption, masterKeyId string) ([]byte, error) {
+	switch enc.Mode {
+	case EncryptionAES256:
+		k := s.keyring.Load()
+		if k == nil {
+			return nil, ErrEncryptionNotConfigured
+		}
+		key, ok := k.keys[masterKeyId]
+		if !ok {
+			return nil, wrap(ErrMasterKeyNotFound, "Master key "+masterKeyId+" of the object is not in the keyring", nil)
+		}
+		return key, nil
+	case EncryptionCustomer:
+		if len(enc.CustomerKey) != 32 {
+			return nil, wrap(ErrInvalidEncryptionKey, "The customer-provided key must be 256 bits", nil)
+		}
+		return enc.CustomerKey, nil
+	}
+	return nil, wrap(ErrInvalidArgument, "Unknown server-side encryption "+enc.Mode, nil)
+}
+
+// dataKey unwraps the data key of an encrypted object. customerKey is the
+// key given to read an SSE-C object.
+func (s *Store) dataKey(obj Object, customerKey []byte) ([]byte, error) {
+	if obj.Encryption == EncryptionCustomer && customerKey == nil {
+		return nil, ErrCustomerKeyRequired
+	}
+	if obj.Encryption != EncryptionCustomer && customerKey != nil {
+		return nil, wrap(ErrInvalidArgument, "The object is not encrypted with a customer-provided key", nil)
+	}
+	kek, err := s.keyEncryptionKey(Encryption{Mode: obj.Encryption, CustomerKey: customerKey}, obj.MasterKeyId)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := unwrapKey(kek, obj.WrappedKey)
+	if err != nil && obj.Encryption == EncryptionCustomer {
+		return nil, ErrCustomerKeyMismatch
+	}
+	if err != nil {
+		return nil, wrap(ErrObjectAccess, "Could not unwrap the data key of the object", err)
+	}
+	return dataKey, nil
+}
+
+// wrapKey seals a data key with kek; the result starts with its nonce.
+func wrapKey(kek []byte, dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func unwrapKey(kek []byte, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce derives the nonce of a chunk from its index; data keys are
+// never reused, so the nonces are unique per key.
+func chunkNonce(aead cipher.AEAD, index int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	return nonce
+}
+
+// chunkData authenticates whether a chunk is the last, so that truncated
+// content does not decrypt.
+func chunkData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// decryptedSize returns the plaintext size of size bytes of encrypted
+// content: every chunk, and at least one, carries a GCM tag.
+func decryptedSize(size int64, overhead int) int64 {
+	sealed := int64(encryptionChunk + overhead)
+	chunks := (size + sealed - 1) / sealed
+	return size - chunks*int64(overhead)
+}
+
+// encryptWriter seals what is written to it chunk by chunk. A full chunk
+// is only sealed once more data follows, so Close can mark the last one.
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index int64
+}
+
+func newEncryptWriter(w io.Writer, dataKey []byte) (*encryptWriter, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, encryptionChunk+aead.Overhead())}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == encryptionChunk {
+			err := e.seal(false)
+			if err != nil {
+				return written, err
+			}
+		}
+		n := min(len(p), encryptionChunk-len(e.buf))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	sealed := e.aead.Seal(e.buf[:0], chunkNonce(e.aead, e.index), e.buf, chunkData(final))
+	_, err := e.w.Write(sealed)
+	e.buf = e.buf[:0]
+	e.index++
+	return err
+}
+
+// Close seals the last chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// decryptContent decrypts stored content on the fly, opening the chunk
+// holding the current offset.
+type decryptContent struct {
+	stored io.ReadSeekCloser
+	aead   cipher.AEAD
+	// storedSize and size are the sizes of the content before and after
+	// decryption.
+	storedSize int64
+	size       int64
+	offset     int64
+	chunk      []byte
+	// chunkIndex is the index of the decrypted chunk, or -1.
+	chunkIndex int64
+}
+
+func newDecryptContent(stored io.ReadSeekCloser, dataKey []byte, storedSize int64) (*decryptContent, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptContent{
+		stored:     stored,
+		aead:       aead,
+		storedSize: storedSize,
+		size:       decryptedSize(storedSize, aead.Overhead()),
+		chunkIndex: -1,
+	}, nil
+}
+
+func (d *decryptContent) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	index := d.offset / encryptionChunk
+	if index != d.chunkIndex {
+		sealedChunk := int64(encryptionChunk + d.aead.Overhead())
+		start := index * sealedChunk
+		_, err := d.stored.Seek(start, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		sealed := make([]byte, min(sealedChunk, d.storedSize-start))
+		_, err = io.ReadFull(d.stored, sealed)
+		if err != nil {
+			return 0, err
+		}
+		final := start+int64(len(sealed)) == d.storedSize
+		d.chunk, err = d.aead.Open(sealed[:0], chunkNonce(d.aead, index), sealed, chunkData(final))
+		if err != nil {
+			d.chunkIndex = -1
+			return 0, errors.New("encrypted content does not authenticate")
+		}
+		d.chunkIndex = index
+	}
+	n := copy(p, d.chunk[d.offset-index*encryptionChunk:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+func (d *decryptContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to a negative offset")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+func (d *decryptContent) Close() error {
+	return d.stored.Close()
+}
+
+// RotateKeys wraps the data keys of SSE-S3 objects, including those kept
+// by snapshots, with the current master key, so that older keys can be
+// retired. Only metadata is rewritten. It returns the number of objects
+// whose key was rewrapped.
+func (s *Store) RotateKeys() (int, error) {
+	k := s.keyring.Load()
+	if k == nil {
+		return 0, ErrEncryptionNotConfigured
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, bkt := range bkts {
+		if bkt.Status != StatusActive {
+			continue
+		}
+		objs, err := s.readObjects(bkt.Name)
+		if err != nil {
+			return rotated, err
+		}
+		n, err := s.rewrapKeys(k, objs)
+		if err != nil {
+			return rotated, err
+		}
+		if n > 0 {
+			err = s.writeObjects(bkt.Name, objs)
+			if err != nil {
+				return rotated, err
+			}
+			rotated += n
+		}
+
+		snaps, err := s.readSnapshots(bkt.Name)
+		if err != nil {
+			return rotated, err
+		}
+		for _, snap := range snaps {
+			objs, err := s.backend.ReadSnapshotObjects(bkt.Name, snap.Name)
+			if err != nil {
+				return rotated, wrap(ErrMetadata, "Could not read snapshot metadata", err)
+			}
+			n, err := s.rewrapKeys(k, objs)
+			if err != nil {
+				return rotated, err
+			}
+			if n > 0 {
+				err = s.backend.WriteSnapshotObjects(bkt.Name, snap.Name, objs)
+				if err != nil {
+					return rotated, wrap(ErrMetadata, "Could not update snapshot metadata", err)
+				}
+				rotated += n
+			}
+		}
+	}
+	return rotated, nil
+}
+
+// rewrapKeys wraps the data keys of those objects whose master key is not
+// the current key of k with that key and returns their number.
+func (s *Store) rewrapKeys(k *Keyring, objs []Object) (int, error) {
+	n := 0
+	for i, obj := range objs {
+		if obj.Encryption != EncryptionAES256 || obj.MasterKeyId == k.current {
+			continue
+		}
+		dataKey, err := s.dataKey(obj, nil)
+		if err != nil {
+			return n, err
+		}
+		wrapped, err := wrapKey(k.keys[k.current], dataKey)
+		if err != nil {
+			return n, wrap(ErrObjectAccess, "Could not wrap data key", err)
+		}
+		objs[i].MasterKeyId = k.current
+		objs[i].WrappedKey = wrapped
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+// Error is returned by Store operations. Code is the S3-style error code
+// that identifies the kind of failure; errors.Is matches errors by code, so
+// a returned error can be compared against the Err* values below.
+type Error struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Message + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrInvalidBucketName   = &Error{Code: "BucketNameInvalid", Message: "Bucket name is invalid"}
+	ErrBucketExists        = &Error{Code: "BucketNameUnavailable", Message: "Bucket with this name already exists"}
+	ErrBucketNotFound      = &Error{Code: "BucketNotFound", Message: "Bucket does not exist"}
+	ErrBucketNotEmpty      = &Error{Code: "BucketNotEmpty", Message: "Bucket is not empty"}
+	ErrInvalidObjectKey    = &Error{Code: "ObjectKeyInvalid", Message: "Object key is too long (> 1024)"}
+	ErrMetadataAccess      = &Error{Code: "MetadataAccessDenied", Message: "Public metadata access is forbidden"}
+	ErrObjectNotFound      = &Error{Code: "ObjectNotFound", Message: "Object does not exist"}
+	ErrInvalidLogTarget    = &Error{Code: "InvalidTargetBucketForLogging", Message: "Target bucket does not exist"}
+	ErrInvalidArgument     = &Error{Code: "InvalidArgument", Message: "Invalid argument"}
+	ErrMetadata            = &Error{Code: "MetadataError", Message: "Could not access metadata"}
+	ErrObjectAccess        = &Error{Code: "ObjectAccessError", Message: "Could not access object"}
+	ErrBucketAccess        = &Error{Code: "BucketAccessError", Message: "Could not access bucket"}
+	ErrConfigurationError  = &Error{Code: "ConfigurationError", Message: "Could not access bucket configuration"}
+	ErrInvalidSnapshotName = &Error{Code: "SnapshotNameInvalid", Message: "Snapshot name is invalid"}
+	ErrSnapshotExists      = &Error{Code: "SnapshotNameUnavailable", Message: "Snapshot with this name already exists"}
+	ErrSnapshotNotFound    = &Error{Code: "SnapshotNotFound", Message: "Snapshot does not exist"}
+
+	ErrEncryptionNotConfigured = &Error{Code: "EncryptionNotConfigured", Message: "Server-side encryption needs a master key, which is not configured"}
+	ErrMasterKeyNotFound       = &Error{Code: "MasterKeyNotFound", Message: "Master key of the object is not in the keyring"}
+	ErrInvalidEncryptionKey    = &Error{Code: "InvalidEncryptionKey", Message: "The customer-provided encryption key is not valid"}
+	ErrCustomerKeyRequired     = &Error{Code: "CustomerKeyRequired", Message: "The object is encrypted with a customer-provided key, which must be given to read it"}
+	ErrCustomerKeyMismatch     = &Error{Code: "CustomerKeyMismatch", Message: "The customer-provided key does not match the key of the object"}
+	ErrEncryptionRequired      = &Error{Code: "EncryptionRequired", Message: "The bucket only accepts encrypted uploads"}
+	ErrNoEncryptionConfig      = &Error{Code: "ServerSideEncryptionConfigurationNotFoundError", Message: "The bucket has no encryption configuration"}
+
+	ErrObjectLocked       = &Error{Code: "ObjectLocked", Message: "The object is protected by Object Lock"}
+	ErrInvalidBucketState = &Error{Code: "InvalidBucketState", Message: "Object Lock is not enabled for the bucket"}
+	ErrNoObjectLockConfig = &Error{Code: "ObjectLockConfigurationNotFoundError", Message: "Object Lock is not enabled for the bucket"}
+	ErrNoRetention        = &Error{Code: "NoSuchObjectLockConfiguration", Message: "The object has no retention"}
+
+	ErrQuotaExceeded = &Error{Code: "QuotaExceeded", Message: "The upload exceeds a storage quota"}
+
+	ErrNoCORSConfig    = &Error{Code: "NoSuchCORSConfiguration", Message: "The bucket has no CORS configuration"}
+	ErrNoWebsiteConfig = &Error{Code: "NoSuchWebsiteConfiguration", Message: "The bucket is not configured as a website"}
+)
+
+// wrap returns an error of the same kind as kind with a specific message
+// and cause.
+func wrap(kind *Error, message string, err error) *Error {
+	return &Error{Code: kind.Code, Message: message, Err: err}
+}
@@ -0,0 +1,668 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const (
+	bucketMetadataFile   = "buckets.csv"
+	objectMetadataFile   = "objects.csv"
+	snapshotMetadataFile = "snapshots.csv"
+	userQuotasFile       = "quotas.json"
+)
+
+var (
+	bucketHeader   = []string{"Name", "CreationTime", "LastModifiedTime", "Status"}
+	objectHeader   = []string{"ObjectKey", "Size", "ContentType", "LastModified", "ETag", "Compression", "StoredSize", "Encryption", "MasterKeyId", "WrappedKey", "LockMode", "RetainUntil", "LegalHold", "Owner"}
+	snapshotHeader = []string{"Name", "CreationTime"}
+)
+
+// fsBackend keeps the data in a directory: buckets.csv lists the buckets,
+// and every bucket is a subdirectory holding its objects next to an
+// objects.csv file describing them. Keys containing '/' are stored in
+// nested directories. Bucket configuration, snapshots and temporary
+// files live in the reserved .triple-s subdirectory.
+//
+// Object files are never modified in place, only replaced by rename or
+// removed, so snapshots share them through hard links.
+type fsBackend struct {
+	dir string
+	// dedup stores the content of objects as shared blobs.
+	dedup bool
+}
+
+// NewFSBackend returns the backend kept in dir, creating the directory and
+// its bucket metadata if they do not exist yet. Temporary files of uploads
+// interrupted by a crash are removed. The backend keeps a Journal.
+func NewFSBackend(dir string) (Backend, error) {
+	b := &fsBackend{dir: dir}
+	err := os.RemoveAll(b.tmpDir())
+	if err == nil {
+		err = os.MkdirAll(b.tmpDir(), 0o755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(b.bucketMetadataPath())
+	if errors.Is(err, os.ErrNotExist) {
+		err = writeCSV(b.bucketMetadataPath(), bucketHeader, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *fsBackend) bucketMetadataPath() string {
+	return filepath.Join(b.dir, bucketMetadataFile)
+}
+
+func (b *fsBackend) bucketPath(bucketName string) string {
+	return filepath.Join(b.dir, bucketName)
+}
+
+func (b *fsBackend) objectMetadataPath(bucketName string) string {
+	return filepath.Join(b.dir, bucketName, objectMetadataFile)
+}
+
+func (b *fsBackend) objectPath(bucketName string, objectKey string) string {
+	return filepath.Join(b.dir, bucketName, filepath.FromSlash(objectKey))
+}
+
+func (b *fsBackend) configDir() string {
+	return filepath.Join(b.dir, systemDir, "buckets")
+}
+
+func (b *fsBackend) configPath(bucketName string) string {
+	return filepath.Join(b.configDir(), bucketName+".json")
+}
+
+// snapshotsDir holds the snapshots of a bucket: snapshots.csv lists them,
+// and every snapshot is a subdirectory laid out like the bucket directory.
+func (b *fsBackend) snapshotsDir(bucketName string) string {
+	return filepath.Join(b.dir, systemDir, "snapshots", bucketName)
+}
+
+func (b *fsBackend) snapshotPath(bucketName string, snapshotName string) string {
+	return filepath.Join(b.snapshotsDir(bucketName), snapshotName)
+}
+
+func (b *fsBackend) tmpDir() string {
+	return filepath.Join(b.dir, systemDir, "tmp")
+}
+
+func (b *fsBackend) userQuotasPath() string {
+	return filepath.Join(b.dir, systemDir, userQuotasFile)
+}
+
+func (b *fsBackend) journalPath() string {
+	return filepath.Join(b.dir, systemDir, "journal.json")
+}
+
+// syncDir commits the entries of a directory, such as a file renamed into
+// it, to stable storage.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	closeErr := dir.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readCSV returns the records of a metadata file without its header.
+func readCSV(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseCSV(file)
+}
+
+// parseCSV returns the records of metadata read from r without its header.
+func parseCSV(r io.Reader) ([][]string, error) {
+	csvReader := csv.NewReader(r)
+	_, err := csvReader.Read() // header
+	if err != nil {
+		return nil, err
+	}
+	var records [][]string
+	fields, err := csvReader.Read()
+	for err == nil {
+		records = append(records, fields)
+		fields, err = csvReader.Read()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return records, nil
+}
+
+// writeCSV replaces a metadata file. The records are written to a
+// temporary file first so that readers never see a partial file.
+func writeCSV(path string, header []string, records [][]string) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	csvWriter := csv.NewWriter(file)
+	err = csvWriter.Write(header)
+	if err == nil {
+		err = csvWriter.WriteAll(records)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func parseBucketRecords(records [][]string) ([]Bucket, error) {
+	bkts := make([]Bucket, 0, len(records))
+	for _, fields := range records {
+		if len(fields) != len(bucketHeader) {
+			return nil, errors.New("invalid bucket metadata content")
+		}
+		bkts = append(bkts, Bucket{
+			Name:             fields[0],
+			CreationTime:     parseTime(fields[1]),
+			LastModifiedTime: parseTime(fields[2]),
+			Status:           fields[3],
+		})
+	}
+	return bkts, nil
+}
+
+func bucketRecords(bkts []Bucket) [][]string {
+	records := make([][]string, 0, len(bkts))
+	for _, bkt := range bkts {
+		records = append(records, []string{bkt.Name, formatTime(bkt.CreationTime), formatTime(bkt.LastModifiedTime), bkt.Status})
+	}
+	return records
+}
+
+func (b *fsBackend) ReadBuckets() ([]Bucket, error) {
+	records, err := readCSV(b.bucketMetadataPath())
+	if err != nil {
+		return nil, err
+	}
+	return parseBucketRecords(records)
+}
+
+func (b *fsBackend) WriteBuckets(bkts []Bucket) error {
+	return writeCSV(b.bucketMetadataPath(), bucketHeader, bucketRecords(bkts))
+}
+
+func (b *fsBackend) CreateBucket(bucketName string) error {
+	bucketPath := b.bucketPath(bucketName)
+	_, err := os.Stat(bucketPath)
+	if !errors.Is(err, os.ErrNotExist) {
+		return ErrBucketExists
+	}
+	err = os.Mkdir(bucketPath, 0o755)
+	if err != nil {
+		return err
+	}
+	err = writeCSV(b.objectMetadataPath(bucketName), objectHeader, nil)
+	if err != nil {
+		os.RemoveAll(bucketPath)
+		return err
+	}
+	return nil
+}
+
+// RemoveBucket tolerates a bucket which was partly removed already.
+func (b *fsBackend) RemoveBucket(bucketName string) error {
+	err := os.Remove(b.objectMetadataPath(bucketName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.Remove(b.bucketPath(bucketName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errIncompleteRemoval
+	}
+	return nil
+}
+
+func parseObjectRecords(records [][]string) ([]Object, error) {
+	objs := make([]Object, 0, len(records))
+	for _, fields := range records {
+		// Files written before the ETag, compression, encryption,
+		// Object Lock or owner columns were added lack them.
+		if len(fields) != len(objectHeader) && len(fields) != 13 && len(fields) != 10 && len(fields) != 7 && len(fields) != 5 && len(fields) != 4 {
+			return nil, errors.New("invalid object metadata content")
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		obj := Object{
+			Key:          fields[0],
+			Size:         size,
+			ContentType:  fields[2],
+			LastModified: parseTime(fields[3]),
+		}
+		if len(fields) > 4 {
+			obj.ETag = fields[4]
+		}
+		if len(fields) > 5 {
+			obj.Compression = fields[5]
+			obj.StoredSize, _ = strconv.ParseInt(fields[6], 10, 64)
+		}
+		if len(fields) > 7 && fields[7] != "" {
+			obj.Encryption = fields[7]
+			obj.MasterKeyId = fields[8]
+			wrappedKey, err := base64.StdEncoding.DecodeString(fields[9])
+			if err != nil {
+				return nil, errors.New("invalid wrapped key of object " + obj.Key)
+			}
+			obj.WrappedKey = wrappedKey
+		}
+		if len(fields) > 10 && fields[10] != "" {
+			obj.LockMode = fields[10]
+			obj.RetainUntil = parseTime(fields[11])
+		}
+		if len(fields) > 10 {
+			obj.LegalHold = fields[12] == "ON"
+		}
+		if len(fields) > 13 {
+			obj.Owner = fields[13]
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+func objectRecords(objs []Object) [][]string {
+	records := make([][]string, 0, len(objs))
+	for _, obj := range objs {
+		storedSize, wrappedKey, retainUntil, legalHold := "", "", "", ""
+		if transformed(obj) {
+			storedSize = strconv.FormatInt(obj.StoredSize, 10)
+		}
+		if obj.Encryption != "" {
+			wrappedKey = base64.StdEncoding.EncodeToString(obj.WrappedKey)
+		}
+		if obj.LockMode != "" {
+			retainUntil = formatTime(obj.RetainUntil)
+		}
+		if obj.LegalHold {
+			legalHold = "ON"
+		}
+		records = append(records, []string{obj.Key, strconv.FormatInt(obj.Size, 10), obj.ContentType, formatTime(obj.LastModified), obj.ETag, obj.Compression, storedSize, obj.Encryption, obj.MasterKeyId, wrappedKey, obj.LockMode, retainUntil, legalHold, obj.Owner})
+	}
+	return records
+}
+
+func (b *fsBackend) ReadObjects(bucketName string) ([]Object, error) {
+	records, err := readCSV(b.objectMetadataPath(bucketName))
+	if err != nil {
+		return nil, err
+	}
+	return parseObjectRecords(records)
+}
+
+func (b *fsBackend) WriteObjects(bucketName string, objs []Object) error {
+	return writeCSV(b.objectMetadataPath(bucketName), objectHeader, objectRecords(objs))
+}
+
+// fsPendingObject is a temporary file which is renamed into the bucket
+// directory on commit, so readers of the previous content keep reading it.
+type fsPendingObject struct {
+	*os.File
+	backend    *fsBackend
+	bucketName string
+	done       bool
+}
+
+func (b *fsBackend) NewObject(bucketName string) (PendingObject, error) {
+	file, err := os.CreateTemp(b.tmpDir(), "object-*")
+	if err != nil {
+		return nil, err
+	}
+	p := &fsPendingObject{File: file, backend: b, bucketName: bucketName}
+	if b.dedup {
+		return &blobPendingObject{fsPendingObject: p, hash: sha256.New()}, nil
+	}
+	return p, nil
+}
+
+func (p *fsPendingObject) Commit(objectKey string) error {
+	err := p.Sync()
+	closeErr := p.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(p.Name())
+		return err
+	}
+	return p.commitFile(p.Name(), objectKey)
+}
+
+// commitFile renames a written file to the object file of objectKey.
+func (p *fsPendingObject) commitFile(file string, objectKey string) error {
+	path := p.backend.objectPath(p.bucketName, objectKey)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.Rename(file, path)
+	}
+	// Renaming a link over another link to the same file, as when a
+	// deduplicated object is written again with the same content, does
+	// nothing.
+	os.Remove(file)
+	if err != nil {
+		return err
+	}
+	err = syncDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	p.done = true
+	return nil
+}
+
+func (p *fsPendingObject) Abort() error {
+	if p.done {
+		return nil
+	}
+	p.done = true
+	p.Close()
+	return os.Remove(p.Name())
+}
+
+func (b *fsBackend) OpenObject(bucketName string, objectKey string) (io.ReadSeekCloser, error) {
+	return os.Open(b.objectPath(bucketName, objectKey))
+}
+
+// RemoveObject also removes the directories of a nested key which are
+// left empty.
+func (b *fsBackend) RemoveObject(bucketName string, objectKey string) error {
+	path := b.objectPath(bucketName, objectKey)
+	err := os.Remove(path)
+	if errors.Is(err, syscall.ENOTDIR) {
+		// A file, such as an object restored by an interrupted rollback,
+		// is where the key needs a directory, so the key has no content.
+		return nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	bucketPath := b.bucketPath(bucketName)
+	dir := filepath.Dir(path)
+	for dir != bucketPath && os.Remove(dir) == nil {
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
+
+func (b *fsBackend) ReadSnapshots(bucketName string) ([]Snapshot, error) {
+	records, err := readCSV(filepath.Join(b.snapshotsDir(bucketName), snapshotMetadataFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]Snapshot, 0, len(records))
+	for _, fields := range records {
+		if len(fields) != len(snapshotHeader) {
+			return nil, errors.New("invalid snapshot metadata content")
+		}
+		snaps = append(snaps, Snapshot{Name: fields[0], CreationTime: parseTime(fields[1])})
+	}
+	return snaps, nil
+}
+
+func (b *fsBackend) WriteSnapshots(bucketName string, snaps []Snapshot) error {
+	dir := b.snapshotsDir(bucketName)
+	if len(snaps) == 0 {
+		// The directory is removed once the last snapshot is, so a
+		// bucket without snapshots leaves nothing behind.
+		err := os.Remove(filepath.Join(dir, snapshotMetadataFile))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		os.Remove(dir)
+		return nil
+	}
+	records := make([][]string, 0, len(snaps))
+	for _, snap := range snaps {
+		records = append(records, []string{snap.Name, formatTime(snap.CreationTime)})
+	}
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	return writeCSV(filepath.Join(dir, snapshotMetadataFile), snapshotHeader, records)
+}
+
+// CreateSnapshot hard-links the object files into the snapshot directory.
+func (b *fsBackend) CreateSnapshot(bucketName string, snapshotName string, objs []Object) error {
+	dir := b.snapshotPath(bucketName, snapshotName)
+	err := os.RemoveAll(dir)
+	if err == nil {
+		err = os.MkdirAll(dir, 0o755)
+	}
+	for _, obj := range objs {
+		if err != nil {
+			break
+		}
+		path := filepath.Join(dir, filepath.FromSlash(obj.Key))
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err == nil {
+			err = os.Link(b.objectPath(bucketName, obj.Key), path)
+		}
+	}
+	if err == nil {
+		err = writeCSV(filepath.Join(dir, objectMetadataFile), objectHeader, objectRecords(objs))
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func (b *fsBackend) ReadSnapshotObjects(bucketName string, snapshotName string) ([]Object, error) {
+	records, err := readCSV(filepath.Join(b.snapshotPath(bucketName, snapshotName), objectMetadataFile))
+	if err != nil {
+		return nil, err
+	}
+	return parseObjectRecords(records)
+}
+
+func (b *fsBackend) WriteSnapshotObjects(bucketName string, snapshotName string, objs []Object) error {
+	return writeCSV(filepath.Join(b.snapshotPath(bucketName, snapshotName), objectMetadataFile), objectHeader, objectRecords(objs))
+}
+
+func (b *fsBackend) OpenSnapshotObject(bucketName string, snapshotName string, objectKey string) (io.ReadSeekCloser, error) {
+	return os.Open(filepath.Join(b.snapshotPath(bucketName, snapshotName), filepath.FromSlash(objectKey)))
+}
+
+// RestoreSnapshotObject links the snapshot's file under a temporary name
+// and renames it over the object file, so readers see either version.
+func (b *fsBackend) RestoreSnapshotObject(bucketName string, snapshotName string, objectKey string) error {
+	tmp, err := os.CreateTemp(b.tmpDir(), "restore-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	err = os.Link(filepath.Join(b.snapshotPath(bucketName, snapshotName), filepath.FromSlash(objectKey)), tmp.Name())
+	if err != nil {
+		return err
+	}
+	path := b.objectPath(bucketName, objectKey)
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	// Renaming a link over another link to the same file, as when an
+	// interrupted rollback is repeated, does nothing.
+	os.Remove(tmp.Name())
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (b *fsBackend) RemoveSnapshot(bucketName string, snapshotName string) error {
+	err := os.RemoveAll(b.snapshotPath(bucketName, snapshotName))
+	if err != nil {
+		return err
+	}
+	// Only succeeds once the last snapshot is removed and unlisted.
+	os.Remove(b.snapshotsDir(bucketName))
+	return nil
+}
+
+func (b *fsBackend) ReadConfig(bucketName string) ([]byte, error) {
+	data, err := os.ReadFile(b.configPath(bucketName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (b *fsBackend) WriteConfig(bucketName string, data []byte) error {
+	path := b.configPath(bucketName)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path+".tmp", data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (b *fsBackend) RemoveConfig(bucketName string) error {
+	err := os.Remove(b.configPath(bucketName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *fsBackend) ReadUserQuotas() ([]byte, error) {
+	data, err := os.ReadFile(b.userQuotasPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (b *fsBackend) WriteUserQuotas(data []byte) error {
+	path := b.userQuotasPath()
+	err := os.WriteFile(path+".tmp", data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (b *fsBackend) WriteJournal(data []byte) error {
+	path := b.journalPath()
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (b *fsBackend) ReadJournal() ([]byte, error) {
+	data, err := os.ReadFile(b.journalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (b *fsBackend) RemoveJournal() error {
+	err := os.Remove(b.journalPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Check verifies that the bucket metadata is readable and that the data
+// directory is writable.
+func (b *fsBackend) Check() error {
+	_, err := readCSV(b.bucketMetadataPath())
+	if err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(b.tmpDir(), "probe-*")
+	if err != nil {
+		return err
+	}
+	_, err = probe.Write([]byte("ok"))
+	probe.Close()
+	os.Remove(probe.Name())
+	return err
+}
+
+// Sync commits the bucket and object metadata files to stable storage.
+func (b *fsBackend) Sync() error {
+	bkts, err := b.ReadBuckets()
+	if err != nil {
+		return err
+	}
+	paths := []string{b.bucketMetadataPath()}
+	for _, bkt := range bkts {
+		if bkt.Status == StatusActive {
+			paths = append(paths, b.objectMetadataPath(bkt.Name))
+		}
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = file.Sync()
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
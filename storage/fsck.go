@@ -0,0 +1,523 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProblemKind classifies an inconsistency found by Fsck.
+type ProblemKind string
+
+const (
+	// ProblemMissingBucket is an active bucket without its directory or
+	// object metadata.
+	ProblemMissingBucket ProblemKind = "missing-bucket"
+	// ProblemUnlistedBucket is a bucket directory which buckets.csv does
+	// not list.
+	ProblemUnlistedBucket ProblemKind = "unlisted-bucket"
+	// ProblemDeletedBucket is a bucket left Deleted whose directory is
+	// gone or still holds files.
+	ProblemDeletedBucket ProblemKind = "deleted-bucket"
+	// ProblemDuplicateRow is a bucket or object listed more than once.
+	ProblemDuplicateRow ProblemKind = "duplicate-row"
+	// ProblemUnlistedFile is an object file which objects.csv does not
+	// list.
+	ProblemUnlistedFile ProblemKind = "unlisted-file"
+	// ProblemMissingFile is an object listed in objects.csv without its
+	// file.
+	ProblemMissingFile ProblemKind = "missing-file"
+	// ProblemSizeMismatch is an object whose recorded size differs from
+	// its file.
+	ProblemSizeMismatch ProblemKind = "size-mismatch"
+	// ProblemETagMismatch is an object whose recorded ETag is missing or
+	// differs from the digest of its file.
+	ProblemETagMismatch ProblemKind = "etag-mismatch"
+	// ProblemLeftover is a temporary file, empty directory or bucket
+	// configuration left behind by an interrupted operation.
+	ProblemLeftover ProblemKind = "leftover"
+)
+
+// Problem is an inconsistency between the metadata and the files of a
+// store.
+type Problem struct {
+	Kind   ProblemKind
+	Bucket string
+	// Key is the object concerned, or "" for a bucket.
+	Key    string
+	Detail string
+	// Repaired is set when Fsck fixed the problem.
+	Repaired bool
+}
+
+func (p Problem) String() string {
+	name := p.Bucket
+	if p.Key != "" {
+		name += "/" + p.Key
+	}
+	if name == "" {
+		name = "-"
+	}
+	s := string(p.Kind) + " " + name + ": " + p.Detail
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// checker collects the problems of one Fsck run.
+type checker struct {
+	backend  *fsBackend
+	repair   bool
+	problems []Problem
+}
+
+// report records a problem. fix is called to repair it when repairing;
+// the problem counts as repaired if fix succeeds, and nil means the
+// problem cannot be repaired automatically.
+func (c *checker) report(kind ProblemKind, bucketName string, objectKey string, detail string, fix func() error) {
+	p := Problem{Kind: kind, Bucket: bucketName, Key: objectKey, Detail: detail}
+	if c.repair && fix != nil {
+		err := fix()
+		if err != nil {
+			p.Detail += "; repair failed: " + err.Error()
+		} else {
+			p.Repaired = true
+		}
+	}
+	c.problems = append(c.problems, p)
+}
+
+// Fsck checks the directory store kept in dir for inconsistencies between
+// the metadata files and the bucket directories and returns them. With
+// repair set it also fixes them: unlisted buckets and files are indexed,
+// rows without files are dropped, sizes and ETags are recomputed and
+// leftovers are removed. Encrypted content is only checked by its size.
+// The server must not be running on dir.
+func Fsck(dir string, repair bool) ([]Problem, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	b := &fsBackend{dir: dir}
+	c := &checker{backend: b, repair: repair}
+	journal, err := b.ReadJournal()
+	if err != nil {
+		return nil, err
+	}
+	if journal != nil {
+		// Recovery completes or rolls back the operation the way the
+		// server would, so it runs before anything else is checked.
+		c.report(ProblemLeftover, "", "", "journal records an interrupted operation", func() error {
+			_, err := New(b)
+			return err
+		})
+	}
+	bkts, err := b.ReadBuckets()
+	if err != nil {
+		return nil, wrap(ErrMetadata, "Could not read bucket metadata", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != systemDir {
+			dirs[e.Name()] = true
+		}
+	}
+
+	bucketsChanged := false
+	listed := make(map[string]bool)
+	var kept []Bucket
+	for _, bkt := range bkts {
+		keep := true
+		drop := func() error {
+			keep = false
+			bucketsChanged = true
+			return nil
+		}
+		switch {
+		case listed[bkt.Name]:
+			c.report(ProblemDuplicateRow, bkt.Name, "", "bucket is listed more than once", drop)
+		case bkt.Status == StatusDeleted && !dirs[bkt.Name]:
+			c.report(ProblemDeletedBucket, bkt.Name, "", "deleted bucket is still listed", drop)
+		case bkt.Status == StatusDeleted:
+			empty, err := emptyDirs(b.bucketPath(bkt.Name), true)
+			if err != nil {
+				return c.problems, wrap(ErrBucketAccess, "Could not read bucket directory "+bkt.Name, err)
+			}
+			if len(empty) == 0 {
+				c.report(ProblemDeletedBucket, bkt.Name, "", "directory of the deleted bucket still holds files", nil)
+				break
+			}
+			c.report(ProblemDeletedBucket, bkt.Name, "", "empty directory of the deleted bucket is left over", func() error {
+				err := os.RemoveAll(b.bucketPath(bkt.Name))
+				if err != nil {
+					return err
+				}
+				return drop()
+			})
+		case !dirs[bkt.Name]:
+			c.report(ProblemMissingBucket, bkt.Name, "", "bucket directory is missing", drop)
+		default:
+			err := c.checkObjects(bkt.Name)
+			if err != nil {
+				return c.problems, err
+			}
+		}
+		if keep {
+			kept = append(kept, bkt)
+			listed[bkt.Name] = true
+		}
+	}
+
+	for _, name := range sortedNames(dirs) {
+		if listed[name] {
+			continue
+		}
+		if ValidateBucketName(name) != nil {
+			c.report(ProblemUnlistedBucket, name, "", "directory is not a bucket and its name is not a valid bucket name", nil)
+			continue
+		}
+		c.report(ProblemUnlistedBucket, name, "", "bucket directory is not listed", func() error {
+			info, err := os.Stat(b.bucketPath(name))
+			if err != nil {
+				return err
+			}
+			created := info.ModTime()
+			kept = append(kept, Bucket{Name: name, CreationTime: created, LastModifiedTime: created, Status: StatusActive})
+			listed[name] = true
+			bucketsChanged = true
+			return nil
+		})
+		if listed[name] {
+			err := c.checkObjects(name)
+			if err != nil {
+				return c.problems, err
+			}
+		}
+	}
+	if bucketsChanged {
+		err = b.WriteBuckets(kept)
+		if err != nil {
+			return c.problems, wrap(ErrMetadata, "Could not update bucket metadata", err)
+		}
+	}
+
+	c.checkSystemDir(listed)
+	return c.problems, nil
+}
+
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileDigest returns the size and hex-encoded MD5 digest of the content
+// stored in a file with the given compression.
+func fileDigest(path string, compression string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	return contentDigest(file, compression)
+}
+
+// detectContentType guesses the content type of an unlisted file.
+func detectContentType(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	return http.DetectContentType(head[:n])
+}
+
+// checkObjects compares the object metadata of a bucket with the files in
+// its directory.
+func (c *checker) checkObjects(bucketName string) error {
+	b := c.backend
+	bucketPath := b.bucketPath(bucketName)
+	changed := false
+	objs, err := b.ReadObjects(bucketName)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.report(ProblemMissingBucket, bucketName, "", "object metadata is missing", func() error {
+			changed = true
+			return nil
+		})
+		if !changed {
+			return nil
+		}
+	} else if err != nil {
+		return wrap(ErrMetadata, "Could not read object metadata of "+bucketName, err)
+	}
+
+	files := make(map[string]fs.FileInfo)
+	err = filepath.WalkDir(bucketPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(bucketPath, path)
+		key := filepath.ToSlash(rel)
+		if d.IsDir() || key == objectMetadataFile {
+			return nil
+		}
+		if key == objectMetadataFile+".tmp" {
+			c.report(ProblemLeftover, bucketName, "", "temporary object metadata is left over", func() error {
+				return os.Remove(path)
+			})
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[key] = info
+		return nil
+	})
+	if err != nil {
+		return wrap(ErrBucketAccess, "Could not read bucket directory "+bucketName, err)
+	}
+
+	seen := make(map[string]bool)
+	var kept []Object
+	for _, obj := range objs {
+		path := b.objectPath(bucketName, obj.Key)
+		info, ok := files[obj.Key]
+		keep := true
+		drop := func() error {
+			keep = false
+			changed = true
+			return nil
+		}
+		switch {
+		case seen[obj.Key]:
+			c.report(ProblemDuplicateRow, bucketName, obj.Key, "object is listed more than once", drop)
+		case !ok:
+			c.report(ProblemMissingFile, bucketName, obj.Key, "object file is missing", drop)
+		default:
+			if info.Size() != storedSize(obj) {
+				c.report(ProblemSizeMismatch, bucketName, obj.Key, "recorded size differs from the file", func() error {
+					// The original size of encrypted or compressed
+					// content is not the size of its file.
+					if transformed(obj) {
+						obj.StoredSize = info.Size()
+					} else {
+						obj.Size = info.Size()
+					}
+					changed = true
+					return nil
+				})
+			}
+			if obj.Encryption != "" {
+				// The keys of encrypted content are not at hand; it
+				// is authenticated when it is read.
+				break
+			}
+			size, digest, err := fileDigest(path, obj.Compression)
+			if errors.Is(err, errCorruptContent) {
+				c.report(ProblemETagMismatch, bucketName, obj.Key, err.Error(), nil)
+				break
+			}
+			if err != nil {
+				return wrap(ErrObjectAccess, "Could not read object "+bucketName+"/"+obj.Key, err)
+			}
+			if obj.Compression != "" && size != obj.Size {
+				c.report(ProblemSizeMismatch, bucketName, obj.Key, "recorded size differs from the decompressed content", func() error {
+					obj.Size = size
+					changed = true
+					return nil
+				})
+			}
+			if obj.ETag != digest {
+				detail := "recorded ETag differs from the file"
+				if obj.ETag == "" {
+					detail = "no ETag is recorded"
+				}
+				c.report(ProblemETagMismatch, bucketName, obj.Key, detail, func() error {
+					obj.ETag = digest
+					changed = true
+					return nil
+				})
+			}
+		}
+		if keep {
+			kept = append(kept, obj)
+			seen[obj.Key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		path := b.objectPath(bucketName, key)
+		if validateObjectKey(key) != nil {
+			c.report(ProblemUnlistedFile, bucketName, key, "file is not listed and its name is not a valid object key", nil)
+			continue
+		}
+		c.report(ProblemUnlistedFile, bucketName, key, "object file is not listed", func() error {
+			_, digest, err := fileDigest(path, "")
+			if err != nil {
+				return err
+			}
+			info := files[key]
+			kept = append(kept, Object{
+				Key:          key,
+				Size:         info.Size(),
+				ContentType:  detectContentType(path),
+				LastModified: info.ModTime(),
+				ETag:         digest,
+			})
+			changed = true
+			return nil
+		})
+	}
+
+	if changed {
+		err = b.WriteObjects(bucketName, kept)
+		if err != nil {
+			return wrap(ErrMetadata, "Could not update object metadata of "+bucketName, err)
+		}
+	}
+	dirs, err := emptyDirs(bucketPath, false)
+	if err != nil {
+		return wrap(ErrBucketAccess, "Could not read bucket directory "+bucketName, err)
+	}
+	for _, dir := range dirs {
+		rel, _ := filepath.Rel(bucketPath, dir)
+		c.report(ProblemLeftover, bucketName, filepath.ToSlash(rel)+"/", "directory is empty", func() error {
+			return os.RemoveAll(dir)
+		})
+	}
+	return nil
+}
+
+// emptyDirs returns the directories below root, and root itself if
+// includeRoot is set, which hold nothing but empty directories. Nested
+// directories of a returned one are not returned.
+func emptyDirs(root string, includeRoot bool) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (path != root || includeRoot) {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Children are walked after their parents, so walking backwards
+	// decides on the children first.
+	empty := make(map[string]bool)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		if err != nil {
+			return nil, err
+		}
+		empty[dirs[i]] = true
+		for _, e := range entries {
+			if !empty[filepath.Join(dirs[i], e.Name())] {
+				empty[dirs[i]] = false
+				break
+			}
+		}
+	}
+	var result []string
+	for _, dir := range dirs {
+		if empty[dir] && !empty[filepath.Dir(dir)] {
+			result = append(result, dir)
+		}
+	}
+	return result, nil
+}
+
+// checkSystemDir looks for temporary files, for configuration and
+// snapshots of buckets which do not exist, for unlisted snapshots and for
+// unreferenced blobs.
+func (c *checker) checkSystemDir(buckets map[string]bool) {
+	b := c.backend
+	tmpPath := b.bucketMetadataPath() + ".tmp"
+	if _, err := os.Stat(tmpPath); err == nil {
+		c.report(ProblemLeftover, "", "", "temporary bucket metadata is left over", func() error {
+			return os.Remove(tmpPath)
+		})
+	}
+	tmpFiles, _ := os.ReadDir(b.tmpDir())
+	for _, e := range tmpFiles {
+		path := filepath.Join(b.tmpDir(), e.Name())
+		c.report(ProblemLeftover, "", "", "temporary file "+e.Name()+" is left over", func() error {
+			return os.RemoveAll(path)
+		})
+	}
+	configs, _ := os.ReadDir(b.configDir())
+	for _, e := range configs {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || buckets[name] {
+			continue
+		}
+		path := filepath.Join(b.configDir(), e.Name())
+		c.report(ProblemLeftover, name, "", "configuration of a bucket which does not exist", func() error {
+			return os.Remove(path)
+		})
+	}
+	snapshotBuckets, _ := os.ReadDir(filepath.Join(b.dir, systemDir, "snapshots"))
+	for _, e := range snapshotBuckets {
+		name := e.Name()
+		dir := b.snapshotsDir(name)
+		if !buckets[name] {
+			c.report(ProblemLeftover, name, "", "snapshots of a bucket which does not exist", func() error {
+				return os.RemoveAll(dir)
+			})
+			continue
+		}
+		snaps, err := b.ReadSnapshots(name)
+		if err != nil {
+			continue
+		}
+		entries, _ := os.ReadDir(dir)
+		for _, snapDir := range entries {
+			if !snapDir.IsDir() || findSnapshot(snaps, snapDir.Name()) >= 0 {
+				continue
+			}
+			path := filepath.Join(dir, snapDir.Name())
+			c.report(ProblemLeftover, name, "", "snapshot "+snapDir.Name()+" is not listed", func() error {
+				return os.RemoveAll(path)
+			})
+		}
+	}
+	b.walkBlobs(func(path string, info fs.FileInfo) error {
+		if linkCount(info) == 1 {
+			c.report(ProblemLeftover, "", "", "blob "+info.Name()+" is not referenced", func() error {
+				return os.Remove(path)
+			})
+		}
+		return nil
+	})
+}
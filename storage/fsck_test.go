@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFsckRepairsStoredSize(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestDir(t, dir)
+	createTestBucket(t, store, "secrets")
+	putTestObject(t, store, "secrets", "key.txt", "secret", PutOptions{Encryption: Encryption{Mode: EncryptionAES256}})
+	putString(t, store, "secrets", "plain.txt", "plain")
+
+	path := filepath.Join(dir, "secrets", "key.txt")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, append(data, 0), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "secrets", "plain.txt"), []byte("plain!"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Fsck(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := NewFSBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err := backend.ReadObjects("secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		switch obj.Key {
+		case "key.txt":
+			if obj.Size != 6 || obj.StoredSize != int64(len(data)+1) {
+				t.Errorf("repaired encrypted object has size %d stored in %d, want 6 stored in %d", obj.Size, obj.StoredSize, len(data)+1)
+			}
+		case "plain.txt":
+			if obj.Size != 6 {
+				t.Errorf("repaired object has size %d, want 6", obj.Size)
+			}
+		}
+	}
+}
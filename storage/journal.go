@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Journal is implemented by backends which can crash part way through an
+// operation modifying several files. Before such an operation the Store
+// writes a record of it with WriteJournal; when the Store is created after
+// a crash, it finds the record and completes or rolls back the operation
+// before it is used.
+type Journal interface {
+	// WriteJournal durably replaces the journal record.
+	WriteJournal(data []byte) error
+	// ReadJournal returns the journal record, or nil if there is none.
+	ReadJournal() ([]byte, error)
+	RemoveJournal() error
+}
+
+// Journaled operations.
+const (
+	opCreateBucket = "create-bucket"
+	opDeleteBucket = "delete-bucket"
+	opPutObject    = "put-object"
+	opDeleteObject = "delete-object"
+
+	opCreateSnapshot = "create-snapshot"
+	opDeleteSnapshot = "delete-snapshot"
+	opRollbackBucket = "rollback-bucket"
+)
+
+// journalEntry describes an operation in progress: enough to complete it
+// or to undo what it has done.
+type journalEntry struct {
+	Op     string `json:"op"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key,omitempty"`
+	// Snapshot names the snapshot of a snapshot operation.
+	Snapshot string `json:"snapshot,omitempty"`
+	// Object is the row a put-object operation records, and StoredETag
+	// the digest of its content as stored if that is encrypted.
+	Object     *Object `json:"object,omitempty"`
+	StoredETag string  `json:"storedETag,omitempty"`
+	// Time is the modification time the operation sets on the bucket.
+	Time time.Time `json:"time"`
+}
+
+// journaled runs the steps of an operation modifying several files. The
+// operation is recorded in the journal first. If a step fails, the
+// operation is recovered at once; if that fails too, the record is kept
+// for recovery when the store is opened again and further journaled
+// operations are refused until then.
+func (s *Store) journaled(entry journalEntry, steps func() error) error {
+	j, ok := s.backend.(Journal)
+	if !ok {
+		return steps()
+	}
+	if s.unrecovered {
+		return wrap(ErrMetadata, "An interrupted operation could not be recovered; restart the server", nil)
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = j.WriteJournal(data)
+	}
+	if err != nil {
+		return wrap(ErrMetadata, "Could not write the journal", err)
+	}
+
+	err = steps()
+	if err != nil {
+		if s.recover(entry) != nil {
+			s.unrecovered = true
+			return err
+		}
+		// Recovery may have completed the operation.
+		if s.countUsage() != nil {
+			s.unrecovered = true
+		}
+	}
+	// A record left behind describes a finished operation, which
+	// recovery leaves as it is, and is replaced by the next one.
+	j.RemoveJournal()
+	return err
+}
+
+// recoverJournal recovers the operation recorded in the journal, if any.
+func (s *Store) recoverJournal() error {
+	j, ok := s.backend.(Journal)
+	if !ok {
+		return nil
+	}
+	data, err := j.ReadJournal()
+	if err != nil || data == nil {
+		return err
+	}
+	var entry journalEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return err
+	}
+	err = s.recover(entry)
+	if err != nil {
+		return err
+	}
+	return j.RemoveJournal()
+}
+
+// recover brings the files an interrupted operation was modifying into a
+// consistent state. Object content is replaced first, so an operation is
+// rolled back when its content was not replaced yet and completed
+// otherwise. Recovering a finished operation changes nothing.
+func (s *Store) recover(entry journalEntry) error {
+	switch entry.Op {
+	case opCreateBucket:
+		return s.recoverCreateBucket(entry)
+	case opDeleteBucket:
+		return s.recoverDeleteBucket(entry)
+	case opPutObject:
+		return s.recoverPutObject(entry)
+	case opDeleteObject:
+		return s.recoverDeleteObject(entry)
+	case opCreateSnapshot, opDeleteSnapshot:
+		return s.recoverSnapshot(entry)
+	case opRollbackBucket:
+		return s.recoverRollback(entry)
+	}
+	return errors.New("unknown journal operation " + entry.Op)
+}
+
+// recoverCreateBucket rolls back the creation of a bucket which is not
+// listed yet. Its storage is only removed while it holds no objects.
+func (s *Store) recoverCreateBucket(entry journalEntry) error {
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return err
+	}
+	for _, bkt := range bkts {
+		if bkt.Name == entry.Bucket {
+			return nil
+		}
+	}
+	objs, err := s.backend.ReadObjects(entry.Bucket)
+	if err == nil && len(objs) > 0 {
+		return nil
+	}
+	err = s.backend.RemoveBucket(entry.Bucket)
+	if err != nil && !errors.Is(err, errIncompleteRemoval) {
+		return err
+	}
+	return s.backend.RemoveConfig(entry.Bucket)
+}
+
+// recoverDeleteBucket completes the deletion of a bucket.
+func (s *Store) recoverDeleteBucket(entry journalEntry) error {
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, bkt := range bkts {
+		if bkt.Name == entry.Bucket && bkt.Status == StatusActive {
+			index = i
+		}
+	}
+	if index < 0 {
+		return s.removeBucketConfig(entry.Bucket)
+	}
+	err = s.backend.RemoveBucket(entry.Bucket)
+	if errors.Is(err, errIncompleteRemoval) {
+		bkts[index].Status = StatusDeleted
+		return s.writeBuckets(bkts)
+	}
+	if err != nil {
+		return err
+	}
+	err = s.writeBuckets(append(bkts[:index], bkts[index+1:]...))
+	if err != nil {
+		return err
+	}
+	return s.removeBucketConfig(entry.Bucket)
+}
+
+// recoverPutObject completes the upload of an object if its content was
+// replaced, which is the case when the content matches the recorded
+// digest.
+func (s *Store) recoverPutObject(entry journalEntry) error {
+	if entry.Object == nil {
+		return errors.New("put-object journal record has no object")
+	}
+	content, err := s.backend.OpenObject(entry.Bucket, entry.Key)
+	if err != nil {
+		// The object did not exist before and its content was not
+		// stored.
+		return nil
+	}
+	// Encrypted content is compared as it is stored, as its key may not
+	// be at hand.
+	expected := entry.Object.ETag
+	compression := entry.Object.Compression
+	if entry.Object.Encryption != "" {
+		expected, compression = entry.StoredETag, ""
+	}
+	_, digest, err := contentDigest(content, compression)
+	content.Close()
+	if errors.Is(err, errCorruptContent) {
+		// The previous content is stored differently.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if digest != expected {
+		return nil
+	}
+
+	objs, err := s.readObjects(entry.Bucket)
+	if err != nil {
+		return err
+	}
+	if i := findObject(objs, entry.Key); i >= 0 {
+		objs[i] = *entry.Object
+	} else {
+		objs = append(objs, *entry.Object)
+	}
+	err = s.writeObjects(entry.Bucket, objs)
+	if err != nil {
+		return err
+	}
+	return s.touchBucket(entry.Bucket, entry.Time)
+}
+
+// recoverDeleteObject completes the deletion of an object.
+func (s *Store) recoverDeleteObject(entry journalEntry) error {
+	err := s.backend.RemoveObject(entry.Bucket, entry.Key)
+	if err != nil {
+		return err
+	}
+	objs, err := s.readObjects(entry.Bucket)
+	if err != nil {
+		return err
+	}
+	if i := findObject(objs, entry.Key); i >= 0 {
+		err = s.writeObjects(entry.Bucket, append(objs[:i], objs[i+1:]...))
+		if err != nil {
+			return err
+		}
+	}
+	return s.touchBucket(entry.Bucket, entry.Time)
+}
@@ -0,0 +1,518 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// crashBackend wraps a journaled backend and fails its modifying calls
+// after the first failAfter. With crash set every later call fails, as if
+// the process had died; otherwise only the next one does, and the store
+// goes on to recover the operation itself.
+type crashBackend struct {
+	Backend
+	journal   Journal
+	failAfter int
+	crash     bool
+	calls     int
+	failed    bool
+}
+
+func (c *crashBackend) step() error {
+	c.calls++
+	if c.calls > c.failAfter && (c.crash || c.calls == c.failAfter+1) {
+		c.failed = true
+		return errCrash
+	}
+	return nil
+}
+
+func (c *crashBackend) WriteBuckets(bkts []Bucket) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.WriteBuckets(bkts)
+}
+
+func (c *crashBackend) CreateBucket(bucketName string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.CreateBucket(bucketName)
+}
+
+func (c *crashBackend) RemoveBucket(bucketName string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.RemoveBucket(bucketName)
+}
+
+func (c *crashBackend) WriteObjects(bucketName string, objs []Object) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.WriteObjects(bucketName, objs)
+}
+
+type crashPendingObject struct {
+	PendingObject
+	backend *crashBackend
+}
+
+func (p crashPendingObject) Commit(objectKey string) error {
+	if err := p.backend.step(); err != nil {
+		return err
+	}
+	return p.PendingObject.Commit(objectKey)
+}
+
+func (c *crashBackend) NewObject(bucketName string) (PendingObject, error) {
+	pending, err := c.Backend.NewObject(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return crashPendingObject{pending, c}, nil
+}
+
+func (c *crashBackend) RemoveObject(bucketName string, objectKey string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.RemoveObject(bucketName, objectKey)
+}
+
+func (c *crashBackend) WriteSnapshots(bucketName string, snaps []Snapshot) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.WriteSnapshots(bucketName, snaps)
+}
+
+func (c *crashBackend) CreateSnapshot(bucketName string, snapshotName string, objs []Object) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.CreateSnapshot(bucketName, snapshotName, objs)
+}
+
+func (c *crashBackend) WriteSnapshotObjects(bucketName string, snapshotName string, objs []Object) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.WriteSnapshotObjects(bucketName, snapshotName, objs)
+}
+
+func (c *crashBackend) RestoreSnapshotObject(bucketName string, snapshotName string, objectKey string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.RestoreSnapshotObject(bucketName, snapshotName, objectKey)
+}
+
+func (c *crashBackend) RemoveSnapshot(bucketName string, snapshotName string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.RemoveSnapshot(bucketName, snapshotName)
+}
+
+func (c *crashBackend) WriteConfig(bucketName string, data []byte) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.WriteConfig(bucketName, data)
+}
+
+func (c *crashBackend) RemoveConfig(bucketName string) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.RemoveConfig(bucketName)
+}
+
+func (c *crashBackend) WriteUserQuotas(data []byte) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.Backend.WriteUserQuotas(data)
+}
+
+func (c *crashBackend) WriteJournal(data []byte) error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.journal.WriteJournal(data)
+}
+
+func (c *crashBackend) ReadJournal() ([]byte, error) {
+	return c.journal.ReadJournal()
+}
+
+func (c *crashBackend) RemoveJournal() error {
+	if err := c.step(); err != nil {
+		return err
+	}
+	return c.journal.RemoveJournal()
+}
+
+const testKeyring = "k1:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+
+// openCrashTestStore opens the store in dir with the test keyring.
+func openCrashTestStore(t *testing.T, backend Backend) *Store {
+	t.Helper()
+	store, err := New(backend)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	keyring, err := ParseKeyring(testKeyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetKeyring(keyring)
+	return store
+}
+
+func openTestDir(t *testing.T, dir string) *Store {
+	t.Helper()
+	backend, err := NewFSBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return openCrashTestStore(t, backend)
+}
+
+// describeStore returns the buckets, their configuration, objects,
+// content and snapshots, leaving out modification times.
+func describeStore(t *testing.T, store *Store) string {
+	t.Helper()
+	var sb strings.Builder
+	bkts, err := store.ListBuckets()
+	if err != nil {
+		t.Fatalf("ListBuckets: %v", err)
+	}
+	describeObjects := func(bucketName string, snapshotName string) {
+		result, err := store.ListObjects(bucketName, ListOptions{Snapshot: snapshotName})
+		if err != nil {
+			t.Fatalf("ListObjects(%q, %q): %v", bucketName, snapshotName, err)
+		}
+		for _, obj := range result.Objects {
+			var content io.ReadSeekCloser
+			if snapshotName != "" {
+				_, content, err = store.GetSnapshotObject(bucketName, snapshotName, obj.Key, nil)
+			} else {
+				_, content, err = store.GetObject(bucketName, obj.Key, nil)
+			}
+			fmt.Fprintf(&sb, "  %s size=%d etag=%s owner=%s encryption=%s content=%q\n",
+				obj.Key, obj.Size, obj.ETag, obj.Owner, obj.Encryption, readContent(t, content, err))
+		}
+	}
+	for _, bkt := range bkts {
+		cfg, err := store.BucketConfig(bkt.Name)
+		if err != nil {
+			t.Fatalf("BucketConfig(%q): %v", bkt.Name, err)
+		}
+		data, _ := json.Marshal(cfg)
+		fmt.Fprintf(&sb, "bucket %s config=%s\n", bkt.Name, data)
+		describeObjects(bkt.Name, "")
+		snaps, err := store.Snapshots(bkt.Name)
+		if err != nil {
+			t.Fatalf("Snapshots(%q): %v", bkt.Name, err)
+		}
+		for _, snap := range snaps {
+			fmt.Fprintf(&sb, " snapshot %s\n", snap.Name)
+			describeObjects(bkt.Name, snap.Name)
+		}
+	}
+	return sb.String()
+}
+
+// checkUsage compares the usage counted by a store with its objects.
+func checkUsage(t *testing.T, store *Store) {
+	t.Helper()
+	buckets := make(map[string]Usage)
+	users := make(map[string]Usage)
+	bkts, err := store.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bkt := range bkts {
+		result, err := store.ListObjects(bkt.Name, ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		usage := Usage{}
+		for _, obj := range result.Objects {
+			usage.Objects++
+			usage.Bytes += obj.Size
+			if obj.Owner != "" {
+				user := users[obj.Owner]
+				user.Objects++
+				user.Bytes += obj.Size
+				users[obj.Owner] = user
+			}
+		}
+		buckets[bkt.Name] = usage
+	}
+
+	statuses, err := store.BucketQuotas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(buckets) {
+		t.Errorf("usage is counted for %d buckets, want %d", len(statuses), len(buckets))
+	}
+	for _, status := range statuses {
+		if status.Usage != buckets[status.Name] {
+			t.Errorf("usage of bucket %s = %+v, want %+v", status.Name, status.Usage, buckets[status.Name])
+		}
+	}
+	statuses, err = store.UserQuotas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Usage != users[status.Name] {
+			t.Errorf("usage of user %s = %+v, want %+v", status.Name, status.Usage, users[status.Name])
+		}
+		delete(users, status.Name)
+	}
+	for user, usage := range users {
+		t.Errorf("usage of user %s = none, want %+v", user, usage)
+	}
+}
+
+// crashTests are the journaled operations, each run on the store left by
+// its setup.
+var crashTests = []struct {
+	name  string
+	setup func(t *testing.T, store *Store)
+	op    func(store *Store) error
+}{
+	{
+		name:  "create bucket",
+		setup: func(t *testing.T, store *Store) {},
+		op: func(store *Store) error {
+			_, err := store.CreateBucket("photos", true)
+			return err
+		},
+	},
+	{
+		name: "delete bucket",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "photos")
+			err := store.SetBucketCompression("photos", CompressionGzip)
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+		op: func(store *Store) error {
+			return store.DeleteBucket("photos")
+		},
+	},
+	{
+		name: "put new object",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "photos")
+		},
+		op: func(store *Store) error {
+			_, err := store.PutObject("photos", "cats/tom.jpg", strings.NewReader("meow"), PutOptions{Owner: "AKIA1"})
+			return err
+		},
+	},
+	{
+		name: "overwrite object",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "photos")
+			putTestObject(t, store, "photos", "cats/tom.jpg", "meow", PutOptions{Owner: "AKIA1"})
+		},
+		op: func(store *Store) error {
+			_, err := store.PutObject("photos", "cats/tom.jpg", strings.NewReader("purr purr"), PutOptions{Owner: "AKIA2"})
+			return err
+		},
+	},
+	{
+		name: "overwrite compressed object",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "logs")
+			err := store.SetBucketCompression("logs", CompressionGzip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			putTestObject(t, store, "logs", "app.log", strings.Repeat("started\n", 100), PutOptions{})
+		},
+		op: func(store *Store) error {
+			_, err := store.PutObject("logs", "app.log", strings.NewReader(strings.Repeat("stopped\n", 200)), PutOptions{})
+			return err
+		},
+	},
+	{
+		name: "overwrite encrypted object",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "secrets")
+			putTestObject(t, store, "secrets", "key.txt", "old secret", PutOptions{Encryption: Encryption{Mode: EncryptionAES256}})
+		},
+		op: func(store *Store) error {
+			_, err := store.PutObject("secrets", "key.txt", strings.NewReader("new secret"), PutOptions{Encryption: Encryption{Mode: EncryptionAES256}})
+			return err
+		},
+	},
+	{
+		name: "delete object",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "photos")
+			putTestObject(t, store, "photos", "cats/tom.jpg", "meow", PutOptions{Owner: "AKIA1"})
+			putTestObject(t, store, "photos", "dog.jpg", "woof", PutOptions{Owner: "AKIA1"})
+		},
+		op: func(store *Store) error {
+			return store.DeleteObject("photos", "cats/tom.jpg", false)
+		},
+	},
+	{
+		name: "create snapshot",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "photos")
+			putTestObject(t, store, "photos", "cats/tom.jpg", "meow", PutOptions{})
+			putTestObject(t, store, "photos", "dog.jpg", "woof", PutOptions{})
+		},
+		op: func(store *Store) error {
+			_, err := store.CreateSnapshot("photos", "before")
+			return err
+		},
+	},
+	{
+		name: "delete snapshot",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "photos")
+			putTestObject(t, store, "photos", "cats/tom.jpg", "meow", PutOptions{})
+			_, err := store.CreateSnapshot("photos", "before")
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+		op: func(store *Store) error {
+			return store.DeleteSnapshot("photos", "before")
+		},
+	},
+	{
+		name: "rollback",
+		setup: func(t *testing.T, store *Store) {
+			createTestBucket(t, store, "photos")
+			putTestObject(t, store, "photos", "a.jpg", "a1", PutOptions{Owner: "AKIA1"})
+			putTestObject(t, store, "photos", "b", "b1", PutOptions{Owner: "AKIA1"})
+			_, err := store.CreateSnapshot("photos", "before")
+			if err != nil {
+				t.Fatal(err)
+			}
+			putTestObject(t, store, "photos", "a.jpg", "a2 is longer", PutOptions{Owner: "AKIA2"})
+			err = store.DeleteObject("photos", "b", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			putTestObject(t, store, "photos", "b/c.jpg", "c1", PutOptions{Owner: "AKIA2"})
+		},
+		op: func(store *Store) error {
+			return store.RollbackBucket("photos", "before")
+		},
+	},
+}
+
+func createTestBucket(t *testing.T, store *Store, bucketName string) {
+	t.Helper()
+	_, err := store.CreateBucket(bucketName, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func putTestObject(t *testing.T, store *Store, bucketName string, objectKey string, content string, opts PutOptions) {
+	t.Helper()
+	_, err := store.PutObject(bucketName, objectKey, strings.NewReader(content), opts)
+	if err != nil {
+		t.Fatalf("PutObject(%q, %q): %v", bucketName, objectKey, err)
+	}
+}
+
+// TestJournalRecovery interrupts every journaled operation at each of its
+// steps and checks that reopening the store leaves it as it was before or
+// after the operation, consistent with its files and usage and with an
+// empty journal.
+func TestJournalRecovery(t *testing.T) {
+	for _, test := range crashTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openTestDir(t, dir)
+			test.setup(t, store)
+			before := describeStore(t, store)
+			err := test.op(store)
+			if err != nil {
+				t.Fatalf("uninterrupted operation: %v", err)
+			}
+			after := describeStore(t, store)
+			if before == after {
+				t.Fatal("the operation changes nothing")
+			}
+
+			for _, crash := range []bool{true, false} {
+				mode := "once"
+				if crash {
+					mode = "crash"
+				}
+				for n := 0; ; n++ {
+					if n > 100 {
+						t.Fatal("the operation did not finish within 100 steps")
+					}
+					dir := t.TempDir()
+					test.setup(t, openTestDir(t, dir))
+					inner, err := NewFSBackend(dir)
+					if err != nil {
+						t.Fatal(err)
+					}
+					backend := &crashBackend{Backend: inner, journal: inner.(Journal), failAfter: n, crash: crash}
+					store := openCrashTestStore(t, backend)
+					err = test.op(store)
+					if !backend.failed {
+						if err != nil {
+							t.Fatalf("%s after %d steps: operation failed without a failing step: %v", mode, n, err)
+						}
+						break
+					}
+					if !crash {
+						// The store recovered the operation at once.
+						state := describeStore(t, store)
+						if state != before && state != after {
+							t.Errorf("%s after %d steps: state before reopening is\n%s\nwant\n%s\nor\n%s", mode, n, state, before, after)
+						}
+						checkUsage(t, store)
+					}
+
+					reopened := openTestDir(t, dir)
+					state := describeStore(t, reopened)
+					if state != before && state != after {
+						t.Errorf("%s after %d steps: state is\n%s\nwant\n%s\nor\n%s", mode, n, state, before, after)
+					}
+					checkUsage(t, reopened)
+					_, err = os.Stat(filepath.Join(dir, systemDir, "journal.json"))
+					if !errors.Is(err, os.ErrNotExist) {
+						t.Errorf("%s after %d steps: journal left after reopening: %v", mode, n, err)
+					}
+					problems, err := Fsck(dir, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, p := range problems {
+						t.Errorf("%s after %d steps: %s", mode, n, p)
+					}
+				}
+			}
+		})
+	}
+}
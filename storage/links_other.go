@@ -0,0 +1,11 @@
+//go:build !unix
+
+package storage
+
+import "io/fs"
+
+// linkCount returns 0: the number of hard links to a file is not known on
+// this platform, so blobs are never collected.
+func linkCount(info fs.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"time"
+)
+
+// Retention modes of Object Lock. An object under governance retention can
+// be deleted, overwritten or have its retention shortened by a request
+// which bypasses governance; one under compliance retention cannot until
+// its retain-until date has passed.
+const (
+	LockGovernance = "GOVERNANCE"
+	LockCompliance = "COMPLIANCE"
+)
+
+// ObjectLockConfig is the Object Lock configuration of a bucket. Object
+// Lock is enabled when the bucket is created and cannot be disabled.
+type ObjectLockConfig struct {
+	Enabled bool `json:"enabled"`
+	// DefaultMode is the retention mode of new objects which do not ask
+	// for one, retained for DefaultDays or DefaultYears; empty leaves
+	// them unretained.
+	DefaultMode  string `json:"defaultMode,omitempty"`
+	DefaultDays  int    `json:"defaultDays,omitempty"`
+	DefaultYears int    `json:"defaultYears,omitempty"`
+}
+
+// Retention keeps an object from being deleted or overwritten until
+// RetainUntil.
+type Retention struct {
+	Mode        string
+	RetainUntil time.Time
+}
+
+// retention returns the retention of obj, or nil if it has none.
+func (obj Object) retention() *Retention {
+	if obj.LockMode == "" {
+		return nil
+	}
+	return &Retention{Mode: obj.LockMode, RetainUntil: obj.RetainUntil}
+}
+
+// checkLock returns ErrObjectLocked if obj cannot be deleted or
+// overwritten at now. bypassGovernance lifts governance-mode retention.
+func checkLock(obj Object, bypassGovernance bool, now time.Time) error {
+	if obj.LegalHold {
+		return wrap(ErrObjectLocked, "Object "+obj.Key+" is under legal hold", nil)
+	}
+	if obj.LockMode == "" || !now.Before(obj.RetainUntil) {
+		return nil
+	}
+	if obj.LockMode == LockGovernance && bypassGovernance {
+		return nil
+	}
+	return wrap(ErrObjectLocked, "Object "+obj.Key+" is retained in "+obj.LockMode+" mode until "+obj.RetainUntil.Format(time.RFC3339), nil)
+}
+
+func validateRetention(r Retention, now time.Time) error {
+	if r.Mode != LockGovernance && r.Mode != LockCompliance {
+		return wrap(ErrInvalidArgument, "Retention mode must be GOVERNANCE or COMPLIANCE", nil)
+	}
+	if !now.Before(r.RetainUntil) {
+		return wrap(ErrInvalidArgument, "The retain-until date must be in the future", nil)
+	}
+	return nil
+}
+
+// requireObjectLock fails unless Object Lock is enabled for a bucket.
+func requireObjectLock(cfg BucketConfig) error {
+	if cfg.ObjectLock == nil || !cfg.ObjectLock.Enabled {
+		return wrap(ErrInvalidBucketState, "Object Lock is not enabled for the bucket", nil)
+	}
+	return nil
+}
+
+// lockObject sets the retention and legal hold of a new object from the
+// upload options or the bucket's default retention.
+func lockObject(obj *Object, cfg BucketConfig, opts PutOptions, now time.Time) error {
+	if opts.Retention != nil || opts.LegalHold {
+		err := requireObjectLock(cfg)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Retention != nil {
+		err := validateRetention(*opts.Retention, now)
+		if err != nil {
+			return err
+		}
+		obj.LockMode, obj.RetainUntil = opts.Retention.Mode, opts.Retention.RetainUntil.Truncate(time.Second)
+	} else if lock := cfg.ObjectLock; lock != nil && lock.DefaultMode != "" {
+		obj.LockMode = lock.DefaultMode
+		obj.RetainUntil = now.AddDate(lock.DefaultYears, 0, lock.DefaultDays).Truncate(time.Second)
+	}
+	obj.LegalHold = opts.LegalHold
+	return nil
+}
+
+// SetObjectLockConfig sets the default retention of new objects of a
+// bucket with Object Lock enabled. A config without DefaultMode removes
+// the default retention.
+func (s *Store) SetObjectLockConfig(bucketName string, config ObjectLockConfig) error {
+	if config.DefaultMode != "" {
+		if config.DefaultMode != LockGovernance && config.DefaultMode != LockCompliance {
+			return wrap(ErrInvalidArgument, "Default retention mode must be GOVERNANCE or COMPLIANCE", nil)
+		}
+		if (config.DefaultDays > 0) == (config.DefaultYears > 0) || config.DefaultDays < 0 || config.DefaultYears < 0 {
+			return wrap(ErrInvalidArgument, "Default retention needs a positive number of either days or years", nil)
+		}
+	} else if config.DefaultDays != 0 || config.DefaultYears != 0 {
+		return wrap(ErrInvalidArgument, "Default retention needs a mode", nil)
+	}
+	return s.UpdateBucketConfig(bucketName, func(cfg *BucketConfig) error {
+		err := requireObjectLock(*cfg)
+		if err != nil {
+			return err
+		}
+		config.Enabled = true
+		cfg.ObjectLock = &config
+		return nil
+	})
+}
+
+// PutObjectRetention sets the retention of an object, or removes it when
+// r is nil. Retention can always be extended; shortening or removing it,
+// or changing its mode, needs bypassGovernance for governance mode and is
+// refused for compliance mode until the retain-until date has passed.
+func (s *Store) PutObjectRetention(bucketName string, objectKey string, r *Retention, bypassGovernance bool) (Object, error) {
+	now := time.Now()
+	if r != nil {
+		err := validateRetention(*r, now)
+		if err != nil {
+			return Object{}, err
+		}
+		r.RetainUntil = r.RetainUntil.Truncate(time.Second)
+	}
+	return s.updateObject(bucketName, objectKey, func(cfg BucketConfig, obj *Object) error {
+		err := requireObjectLock(cfg)
+		if err != nil {
+			return err
+		}
+		current := obj.retention()
+		extended := current != nil && r != nil && r.Mode == current.Mode && !r.RetainUntil.Before(current.RetainUntil)
+		if current != nil && !extended && now.Before(current.RetainUntil) {
+			if current.Mode == LockCompliance || !bypassGovernance {
+				return wrap(ErrObjectLocked, "The retention of object "+obj.Key+" can only be extended until "+current.RetainUntil.Format(time.RFC3339), nil)
+			}
+		}
+		obj.LockMode, obj.RetainUntil = "", time.Time{}
+		if r != nil {
+			obj.LockMode, obj.RetainUntil = r.Mode, r.RetainUntil
+		}
+		return nil
+	})
+}
+
+// PutObjectLegalHold places or lifts a legal hold on an object, which
+// keeps it from being deleted or overwritten regardless of its retention.
+func (s *Store) PutObjectLegalHold(bucketName string, objectKey string, on bool) (Object, error) {
+	return s.updateObject(bucketName, objectKey, func(cfg BucketConfig, obj *Object) error {
+		err := requireObjectLock(cfg)
+		if err != nil {
+			return err
+		}
+		obj.LegalHold = on
+		return nil
+	})
+}
+
+// updateObject applies update to the record of an object and saves the
+// result unless update returns an error. The content of the object is
+// not touched.
+func (s *Store) updateObject(bucketName string, objectKey string, update func(cfg BucketConfig, obj *Object) error) (Object, error) {
+	err := validateObjectKey(objectKey)
+	if err != nil {
+		return Object{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.bucket(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	cfg, err := s.readBucketConfig(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	i := findObject(objs, objectKey)
+	if i < 0 {
+		return Object{}, ErrObjectNotFound
+	}
+	err = update(cfg, &objs[i])
+	if err != nil {
+		return Object{}, err
+	}
+	err = s.writeObjects(bucketName, objs)
+	if err != nil {
+		return Object{}, err
+	}
+	return objs[i], nil
+}
+
+// sameVersion reports whether two records describe the same upload of an
+// object.
+func sameVersion(a Object, b Object) bool {
+	return a.Key == b.Key && a.ETag == b.ETag && a.Size == b.Size && a.LastModified.Equal(b.LastModified)
+}
+
+// checkRollbackLocks returns ErrObjectLocked if rolling back to snapObjs
+// would delete or replace a locked object of objs.
+func checkRollbackLocks(objs []Object, snapObjs []Object, now time.Time) error {
+	for _, obj := range objs {
+		if i := findObject(snapObjs, obj.Key); i >= 0 && sameVersion(obj, snapObjs[i]) {
+			continue
+		}
+		err := checkLock(obj, false, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepLocks carries the retention and legal hold of the current objects
+// over to the unchanged objects of a snapshot being rolled back to, whose
+// records may predate them.
+func keepLocks(objs []Object, snapObjs []Object) {
+	for i, snapObj := range snapObjs {
+		j := findObject(objs, snapObj.Key)
+		if j >= 0 && sameVersion(objs[j], snapObj) {
+			snapObjs[i].LockMode = objs[j].LockMode
+			snapObjs[i].RetainUntil = objs[j].RetainUntil
+			snapObjs[i].LegalHold = objs[j].LegalHold
+		}
+	}
+}
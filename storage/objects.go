@@ -0,0 +1,425 @@
+package storage
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxKeyLength is the longest accepted object key.
+const MaxKeyLength = 1024
+
+func validateObjectKey(objectKey string) error {
+	if objectKey == objectMetadataFile || objectKey == objectMetadataFile+".tmp" {
+		return ErrMetadataAccess
+	}
+	if objectKey == "" || len(objectKey) > MaxKeyLength {
+		return ErrInvalidObjectKey
+	}
+	for _, segment := range strings.Split(objectKey, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return wrap(ErrInvalidObjectKey, "Object key must not contain empty, '.' or '..' path segments", nil)
+		}
+	}
+	return nil
+}
+
+// keyConflict returns the key which cannot exist next to objectKey because
+// one of them is a path prefix of the other, e.g. "a" and "a/b".
+func keyConflict(objs []Object, objectKey string) string {
+	for _, obj := range objs {
+		if strings.HasPrefix(obj.Key, objectKey+"/") || strings.HasPrefix(objectKey, obj.Key+"/") {
+			return obj.Key
+		}
+	}
+	return ""
+}
+
+func findObject(objs []Object, objectKey string) int {
+	for i, obj := range objs {
+		if obj.Key == objectKey {
+			return i
+		}
+	}
+	return -1
+}
+
+// PutOptions describe an object being uploaded.
+type PutOptions struct {
+	// ContentType defaults to text/plain.
+	ContentType string
+	Encryption  Encryption
+	// Retention and LegalHold lock the object in a bucket with Object
+	// Lock enabled. Without Retention the bucket's default retention
+	// applies.
+	Retention *Retention
+	LegalHold bool
+	// BypassGovernance allows overwriting an object under
+	// governance-mode retention.
+	BypassGovernance bool
+	// Owner is the verified access key of the uploading user, whose
+	// quota the object counts against.
+	Owner string
+	// Size is the length of the content if it is known in advance. An
+	// upload going over a quota is then refused before it is read.
+	Size int64
+}
+
+// PutObject stores the content read from r under objectKey, replacing any
+// existing object with that key unless it is locked. The content is
+// written aside first, so readers of the previous version are not
+// affected. Keys may contain '/', but a key cannot be a path prefix of
+// another one. Content uploaded to a bucket with compression enabled is
+// stored compressed, and then encrypted as opts ask or as the bucket's
+// encryption configuration defaults to. Uploads which would exceed the
+// quota of the bucket or of the owner fail with ErrQuotaExceeded.
+func (s *Store) PutObject(bucketName string, objectKey string, r io.Reader, opts PutOptions) (Object, error) {
+	err := validateObjectKey(objectKey)
+	if err != nil {
+		return Object{}, err
+	}
+	cfg, err := s.BucketConfig(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	enc, err := bucketEncryption(cfg.Encryption, opts.Encryption)
+	if err != nil {
+		return Object{}, err
+	}
+	var obj Object
+	err = lockObject(&obj, cfg, opts, time.Now())
+	if err != nil {
+		return Object{}, err
+	}
+	err = s.precheckQuotas(bucketName, objectKey, cfg, opts)
+	if err != nil {
+		return Object{}, err
+	}
+	var dataKey []byte
+	if enc.Mode != "" {
+		dataKey, err = s.newDataKey(enc, &obj)
+		if err != nil {
+			return Object{}, err
+		}
+	}
+
+	pending, err := s.backend.NewObject(bucketName)
+	if err != nil {
+		return Object{}, wrap(ErrObjectAccess, "Could not access object", err)
+	}
+	defer pending.Abort()
+	// The digest of the stored bytes tells an interrupted upload of
+	// encrypted content apart from the previous version on recovery.
+	storedHash := md5.New()
+	stored := &countingWriter{w: io.MultiWriter(pending, storedHash)}
+	var layers []io.WriteCloser
+	var w io.Writer = stored
+	if dataKey != nil {
+		encrypter, err := newEncryptWriter(w, dataKey)
+		if err != nil {
+			return Object{}, wrap(ErrObjectAccess, "Could not encrypt object", err)
+		}
+		layers = append(layers, encrypter)
+		w = encrypter
+	}
+	if cfg.Compression == CompressionGzip {
+		gz := gzip.NewWriter(w)
+		layers = append(layers, gz)
+		w = gz
+	}
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), r)
+	for i := len(layers) - 1; i >= 0 && err == nil; i-- {
+		err = layers[i].Close()
+	}
+	if err != nil {
+		return Object{}, wrap(ErrObjectAccess, "Could not write to object", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.bucket(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	if conflict := keyConflict(objs, objectKey); conflict != "" {
+		return Object{}, wrap(ErrInvalidObjectKey, "Object key conflicts with the existing key "+conflict, nil)
+	}
+	now := time.Now()
+	obj.Key, obj.Size, obj.ContentType, obj.LastModified = objectKey, size, contentType, now
+	obj.ETag = hex.EncodeToString(hash.Sum(nil))
+	obj.Compression = cfg.Compression
+	obj.Owner = opts.Owner
+	if transformed(obj) {
+		obj.StoredSize = stored.n
+	}
+	// The configuration is read again, as a quota may have been set
+	// during the upload.
+	cfg, err = s.readBucketConfig(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	var replaced []Object
+	if i := findObject(objs, objectKey); i >= 0 {
+		err = checkLock(objs[i], opts.BypassGovernance, now)
+		if err == nil {
+			err = s.checkQuotas(bucketName, cfg, &objs[i], obj.Owner, obj.Size)
+		}
+		if err != nil {
+			return Object{}, err
+		}
+		replaced = append(replaced, objs[i])
+		objs[i] = obj
+	} else {
+		err = s.checkQuotas(bucketName, cfg, nil, obj.Owner, obj.Size)
+		if err != nil {
+			return Object{}, err
+		}
+		objs = append(objs, obj)
+	}
+
+	entry := journalEntry{Op: opPutObject, Bucket: bucketName, Key: objectKey, Object: &obj, Time: now}
+	if obj.Encryption != "" {
+		entry.StoredETag = hex.EncodeToString(storedHash.Sum(nil))
+	}
+	err = s.journaled(entry, func() error {
+		err := pending.Commit(objectKey)
+		if err != nil {
+			return wrap(ErrObjectAccess, "Could not write to object", err)
+		}
+		err = s.writeObjects(bucketName, objs)
+		if err != nil {
+			return err
+		}
+		return s.touchBucket(bucketName, now)
+	})
+	if err != nil {
+		return Object{}, err
+	}
+	s.usage.add(bucketName, replaced, -1)
+	s.usage.add(bucketName, []Object{obj}, 1)
+	return obj, nil
+}
+
+// precheckQuotas refuses an upload of a known size over a quota before its
+// content is read.
+func (s *Store) precheckQuotas(bucketName string, objectKey string, cfg BucketConfig, opts PutOptions) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return err
+	}
+	var old *Object
+	if i := findObject(objs, objectKey); i >= 0 {
+		old = &objs[i]
+	}
+	return s.checkQuotas(bucketName, cfg, old, opts.Owner, opts.Size)
+}
+
+// GetObject returns the object and its content. The content of encrypted
+// objects is decrypted while it is read; customerKey must be the key of an
+// SSE-C object and nil otherwise. The content of compressed objects is
+// decompressed while it is read and implements EncodedContent. The caller
+// must close the returned reader.
+func (s *Store) GetObject(bucketName string, objectKey string, customerKey []byte) (Object, io.ReadSeekCloser, error) {
+	err := validateObjectKey(objectKey)
+	if err != nil {
+		return Object{}, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, err = s.bucket(bucketName)
+	if err != nil {
+		return Object{}, nil, err
+	}
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return Object{}, nil, err
+	}
+	i := findObject(objs, objectKey)
+	if i < 0 {
+		return Object{}, nil, ErrObjectNotFound
+	}
+	content, err := s.backend.OpenObject(bucketName, objectKey)
+	if err != nil {
+		return Object{}, nil, wrap(ErrObjectAccess, "Could not access object", err)
+	}
+	content, err = s.openContent(objs[i], content, customerKey)
+	if err != nil {
+		return Object{}, nil, err
+	}
+	return objs[i], content, nil
+}
+
+// HeadObject returns the metadata of an object.
+func (s *Store) HeadObject(bucketName string, objectKey string) (Object, error) {
+	err := validateObjectKey(objectKey)
+	if err != nil {
+		return Object{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, err = s.bucket(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return Object{}, err
+	}
+	i := findObject(objs, objectKey)
+	if i < 0 {
+		return Object{}, ErrObjectNotFound
+	}
+	return objs[i], nil
+}
+
+// DeleteObject removes an object unless it is locked. bypassGovernance
+// allows removing an object under governance-mode retention.
+func (s *Store) DeleteObject(bucketName string, objectKey string, bypassGovernance bool) error {
+	err := validateObjectKey(objectKey)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return err
+	}
+	i := findObject(objs, objectKey)
+	if i < 0 {
+		return ErrObjectNotFound
+	}
+	now := time.Now()
+	err = checkLock(objs[i], bypassGovernance, now)
+	if err != nil {
+		return err
+	}
+
+	deleted := objs[i]
+	err = s.journaled(journalEntry{Op: opDeleteObject, Bucket: bucketName, Key: objectKey, Time: now}, func() error {
+		err := s.backend.RemoveObject(bucketName, objectKey)
+		if err != nil {
+			return wrap(ErrObjectAccess, "Could not delete object", err)
+		}
+		err = s.writeObjects(bucketName, append(objs[:i], objs[i+1:]...))
+		if err != nil {
+			return err
+		}
+		return s.touchBucket(bucketName, now)
+	})
+	if err != nil {
+		return err
+	}
+	s.usage.add(bucketName, []Object{deleted}, -1)
+	return nil
+}
+
+type ListOptions struct {
+	// Prefix limits the result to keys that begin with it.
+	Prefix string
+	// Delimiter groups keys that contain it after the prefix into
+	// CommonPrefixes instead of listing them.
+	Delimiter string
+	// StartAfter lists only keys that sort after it.
+	StartAfter string
+	// MaxKeys limits the number of keys and common prefixes returned;
+	// 0 means 1000.
+	MaxKeys int
+	// Snapshot lists the objects of the named snapshot of the bucket
+	// instead of its current objects.
+	Snapshot string
+}
+
+type ListResult struct {
+	Objects        []Object
+	CommonPrefixes []string
+	// IsTruncated is set when more keys follow; the listing continues
+	// with StartAfter set to NextStartAfter.
+	IsTruncated    bool
+	NextStartAfter string
+}
+
+// ListObjects returns the objects of a bucket sorted by key.
+func (s *Store) ListObjects(bucketName string, opts ListOptions) (ListResult, error) {
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = 1000
+	}
+
+	s.mu.RLock()
+	var objs []Object
+	var err error
+	if opts.Snapshot != "" {
+		objs, err = s.snapshotObjects(bucketName, opts.Snapshot)
+	} else {
+		_, err = s.bucket(bucketName)
+		if err == nil {
+			objs, err = s.readObjects(bucketName)
+		}
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return ListResult{}, err
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+
+	var result ListResult
+	for _, obj := range objs {
+		if !strings.HasPrefix(obj.Key, opts.Prefix) || obj.Key <= opts.StartAfter {
+			continue
+		}
+		// A listing continued after a common prefix skips the keys it
+		// groups.
+		if opts.Delimiter != "" && strings.HasSuffix(opts.StartAfter, opts.Delimiter) && strings.HasPrefix(obj.Key, opts.StartAfter) {
+			continue
+		}
+		entry := obj.Key
+		commonPrefix := ""
+		if opts.Delimiter != "" {
+			rest := obj.Key[len(opts.Prefix):]
+			if i := strings.Index(rest, opts.Delimiter); i >= 0 {
+				commonPrefix = opts.Prefix + rest[:i+len(opts.Delimiter)]
+				entry = commonPrefix
+			}
+		}
+		if commonPrefix != "" && len(result.CommonPrefixes) > 0 && result.CommonPrefixes[len(result.CommonPrefixes)-1] == commonPrefix {
+			continue
+		}
+		if len(result.Objects)+len(result.CommonPrefixes) == opts.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix)
+		} else {
+			result.Objects = append(result.Objects, obj)
+		}
+		result.NextStartAfter = entry
+	}
+	if !result.IsTruncated {
+		result.NextStartAfter = ""
+	}
+	return result, nil
+}
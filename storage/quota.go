@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// Quota limits the objects of a bucket or of a user. A zero limit is no
+// limit.
+type Quota struct {
+	MaxBytes   int64 `json:"maxBytes,omitempty"`
+	MaxObjects int64 `json:"maxObjects,omitempty"`
+}
+
+// Usage is what is counted against a quota: the number of objects and the
+// size of their original content.
+type Usage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// QuotaStatus is the quota and usage of a bucket or user.
+type QuotaStatus struct {
+	Name  string `json:"name"`
+	Quota Quota  `json:"quota"`
+	Usage Usage  `json:"usage"`
+}
+
+// usageCounter keeps the usage of every bucket and of every user owning
+// objects. It is counted once when the store is opened and then kept up to
+// date by the operations adding and removing objects, under the store's
+// lock.
+type usageCounter struct {
+	buckets map[string]Usage
+	users   map[string]Usage
+}
+
+// add counts objs in a bucket, or uncounts them when sign is -1.
+func (u *usageCounter) add(bucketName string, objs []Object, sign int64) {
+	for _, obj := range objs {
+		bkt := u.buckets[bucketName]
+		bkt.Objects += sign
+		bkt.Bytes += sign * obj.Size
+		u.buckets[bucketName] = bkt
+		if obj.Owner == "" {
+			continue
+		}
+		user := u.users[obj.Owner]
+		user.Objects += sign
+		user.Bytes += sign * obj.Size
+		u.users[obj.Owner] = user
+	}
+}
+
+// countUsage counts the objects of every active bucket.
+func (s *Store) countUsage() error {
+	s.usage = usageCounter{buckets: make(map[string]Usage), users: make(map[string]Usage)}
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return err
+	}
+	for _, bkt := range bkts {
+		if bkt.Status != StatusActive {
+			continue
+		}
+		objs, err := s.readObjects(bkt.Name)
+		if err != nil {
+			return err
+		}
+		s.usage.buckets[bkt.Name] = Usage{}
+		s.usage.add(bkt.Name, objs, 1)
+	}
+	return nil
+}
+
+// exceeds reports whether usage changed by the given number of objects and
+// bytes goes over quota. Changes which do not add to the usage are always
+// allowed, so that a bucket over its quota can be cleaned up.
+func exceeds(quota Quota, usage Usage, objects int64, bytes int64) bool {
+	if quota.MaxObjects > 0 && objects > 0 && usage.Objects+objects > quota.MaxObjects {
+		return true
+	}
+	return quota.MaxBytes > 0 && bytes > 0 && usage.Bytes+bytes > quota.MaxBytes
+}
+
+// checkQuotas returns ErrQuotaExceeded if replacing old, which is nil for
+// a new object, with an object of size bytes owned by owner goes over the
+// quota of the bucket or of the owner.
+func (s *Store) checkQuotas(bucketName string, cfg BucketConfig, old *Object, owner string, size int64) error {
+	objects, bytes := int64(1), size
+	if old != nil {
+		objects, bytes = 0, size-old.Size
+	}
+	if cfg.Quota != nil && exceeds(*cfg.Quota, s.usage.buckets[bucketName], objects, bytes) {
+		return wrap(ErrQuotaExceeded, "The upload exceeds the quota of bucket "+bucketName+" ("+formatQuota(*cfg.Quota)+")", nil)
+	}
+	if owner == "" {
+		return nil
+	}
+	quotas, err := s.readUserQuotas()
+	if err != nil {
+		return err
+	}
+	quota, ok := quotas[owner]
+	if !ok {
+		return nil
+	}
+	if old != nil && old.Owner != owner {
+		objects, bytes = 1, size
+	}
+	if exceeds(quota, s.usage.users[owner], objects, bytes) {
+		return wrap(ErrQuotaExceeded, "The upload exceeds the quota of user "+owner+" ("+formatQuota(quota)+")", nil)
+	}
+	return nil
+}
+
+func formatQuota(quota Quota) string {
+	switch {
+	case quota.MaxBytes > 0 && quota.MaxObjects > 0:
+		return strconv.FormatInt(quota.MaxBytes, 10) + " bytes, " + strconv.FormatInt(quota.MaxObjects, 10) + " objects"
+	case quota.MaxBytes > 0:
+		return strconv.FormatInt(quota.MaxBytes, 10) + " bytes"
+	default:
+		return strconv.FormatInt(quota.MaxObjects, 10) + " objects"
+	}
+}
+
+func validateQuota(quota Quota) error {
+	if quota.MaxBytes < 0 || quota.MaxObjects < 0 {
+		return wrap(ErrInvalidArgument, "Quota limits must not be negative", nil)
+	}
+	return nil
+}
+
+// SetBucketQuota limits the objects of a bucket, or removes its quota when
+// quota is nil. A bucket already over a new quota keeps its objects, but
+// uploads adding to it are refused.
+func (s *Store) SetBucketQuota(bucketName string, quota *Quota) error {
+	if quota != nil {
+		err := validateQuota(*quota)
+		if err != nil {
+			return err
+		}
+	}
+	return s.UpdateBucketConfig(bucketName, func(cfg *BucketConfig) error {
+		cfg.Quota = quota
+		return nil
+	})
+}
+
+// SetUserQuota limits the objects owned by a user, identified by access
+// key, or removes the user's quota when quota is nil.
+func (s *Store) SetUserQuota(user string, quota *Quota) error {
+	if user == "" {
+		return wrap(ErrInvalidArgument, "A user quota needs an access key", nil)
+	}
+	if quota != nil {
+		err := validateQuota(*quota)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	quotas, err := s.readUserQuotas()
+	if err != nil {
+		return err
+	}
+	if quota != nil {
+		quotas[user] = *quota
+	} else {
+		delete(quotas, user)
+	}
+	content, err := json.MarshalIndent(quotas, "", "\t")
+	if err == nil {
+		err = s.backend.WriteUserQuotas(content)
+	}
+	if err != nil {
+		return wrap(ErrConfigurationError, "Could not save user quotas", err)
+	}
+	return nil
+}
+
+func (s *Store) readUserQuotas() (map[string]Quota, error) {
+	quotas := make(map[string]Quota)
+	content, err := s.backend.ReadUserQuotas()
+	if err == nil && content != nil {
+		err = json.Unmarshal(content, &quotas)
+	}
+	if err != nil {
+		return nil, wrap(ErrConfigurationError, "Could not read user quotas", err)
+	}
+	return quotas, nil
+}
+
+// BucketQuotas returns the quota and usage of every active bucket, sorted
+// by name. Buckets without a quota have a zero Quota.
+func (s *Store) BucketQuotas() ([]QuotaStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	statuses := make([]QuotaStatus, 0, len(s.usage.buckets))
+	for name, usage := range s.usage.buckets {
+		cfg, err := s.readBucketConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		status := QuotaStatus{Name: name, Usage: usage}
+		if cfg.Quota != nil {
+			status.Quota = *cfg.Quota
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses, nil
+}
+
+// UserQuotas returns the quota and usage of every user with a quota or
+// with objects, sorted by access key.
+func (s *Store) UserQuotas() ([]QuotaStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	quotas, err := s.readUserQuotas()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]QuotaStatus, 0, len(quotas))
+	for user, quota := range quotas {
+		statuses = append(statuses, QuotaStatus{Name: user, Quota: quota, Usage: s.usage.users[user]})
+	}
+	for user, usage := range s.usage.users {
+		if _, ok := quotas[user]; !ok && usage.Objects > 0 {
+			statuses = append(statuses, QuotaStatus{Name: user, Usage: usage})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses, nil
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+// Snapshot is a read-only copy of the objects of a bucket at the time it
+// was created.
+type Snapshot struct {
+	Name         string
+	CreationTime time.Time
+}
+
+var snapshotNameFormat = regexp.MustCompile("^[A-Za-z0-9][-_A-Za-z0-9]{0,62}$")
+
+// ValidateSnapshotName reports why a name cannot be used for a snapshot, or
+// returns nil if it can.
+func ValidateSnapshotName(snapshotName string) error {
+	if !snapshotNameFormat.MatchString(snapshotName) {
+		return wrap(ErrInvalidSnapshotName, "Snapshot name must be 1-63 letters, digits, '-' or '_' and start with a letter or digit", nil)
+	}
+	return nil
+}
+
+func (s *Store) readSnapshots(bucketName string) ([]Snapshot, error) {
+	snaps, err := s.backend.ReadSnapshots(bucketName)
+	if err != nil {
+		return nil, wrap(ErrMetadata, "Could not read snapshot metadata", err)
+	}
+	return snaps, nil
+}
+
+func (s *Store) writeSnapshots(bucketName string, snaps []Snapshot) error {
+	err := s.backend.WriteSnapshots(bucketName, snaps)
+	if err != nil {
+		return wrap(ErrMetadata, "Could not update snapshot metadata", err)
+	}
+	return nil
+}
+
+func findSnapshot(snaps []Snapshot, snapshotName string) int {
+	for i, snap := range snaps {
+		if snap.Name == snapshotName {
+			return i
+		}
+	}
+	return -1
+}
+
+// snapshotObjects returns the objects of a snapshot of an active bucket.
+func (s *Store) snapshotObjects(bucketName string, snapshotName string) ([]Object, error) {
+	_, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := s.readSnapshots(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if findSnapshot(snaps, snapshotName) < 0 {
+		return nil, ErrSnapshotNotFound
+	}
+	objs, err := s.backend.ReadSnapshotObjects(bucketName, snapshotName)
+	if err != nil {
+		return nil, wrap(ErrMetadata, "Could not read snapshot metadata", err)
+	}
+	return objs, nil
+}
+
+// CreateSnapshot records the current objects of a bucket as a snapshot.
+// The snapshot shares the content of the objects, so it is cheap to create
+// and only costs space once objects are replaced or deleted.
+func (s *Store) CreateSnapshot(bucketName string, snapshotName string) (Snapshot, error) {
+	err := ValidateSnapshotName(snapshotName)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.bucket(bucketName)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snaps, err := s.readSnapshots(bucketName)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if findSnapshot(snaps, snapshotName) >= 0 {
+		return Snapshot{}, ErrSnapshotExists
+	}
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	now := time.Now()
+	snap := Snapshot{Name: snapshotName, CreationTime: now}
+	err = s.journaled(journalEntry{Op: opCreateSnapshot, Bucket: bucketName, Snapshot: snapshotName, Time: now}, func() error {
+		err := s.backend.CreateSnapshot(bucketName, snapshotName, objs)
+		if err != nil {
+			return wrap(ErrBucketAccess, "Could not create snapshot", err)
+		}
+		return s.writeSnapshots(bucketName, append(snaps, snap))
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
+
+// Snapshots returns the snapshots of an active bucket in the order they
+// were created.
+func (s *Store) Snapshots(bucketName string) ([]Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return s.readSnapshots(bucketName)
+}
+
+// DeleteSnapshot removes a snapshot and the content only it refers to.
+func (s *Store) DeleteSnapshot(bucketName string, snapshotName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	snaps, err := s.readSnapshots(bucketName)
+	if err != nil {
+		return err
+	}
+	i := findSnapshot(snaps, snapshotName)
+	if i < 0 {
+		return ErrSnapshotNotFound
+	}
+	return s.journaled(journalEntry{Op: opDeleteSnapshot, Bucket: bucketName, Snapshot: snapshotName, Time: time.Now()}, func() error {
+		err := s.writeSnapshots(bucketName, append(snaps[:i], snaps[i+1:]...))
+		if err != nil {
+			return err
+		}
+		err = s.backend.RemoveSnapshot(bucketName, snapshotName)
+		if err != nil {
+			return wrap(ErrBucketAccess, "Could not delete snapshot", err)
+		}
+		return nil
+	})
+}
+
+// GetSnapshotObject returns an object of a snapshot and its content like
+// GetObject. The caller must close the returned reader.
+func (s *Store) GetSnapshotObject(bucketName string, snapshotName string, objectKey string, customerKey []byte) (Object, io.ReadSeekCloser, error) {
+	err := validateObjectKey(objectKey)
+	if err != nil {
+		return Object{}, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	objs, err := s.snapshotObjects(bucketName, snapshotName)
+	if err != nil {
+		return Object{}, nil, err
+	}
+	i := findObject(objs, objectKey)
+	if i < 0 {
+		return Object{}, nil, ErrObjectNotFound
+	}
+	content, err := s.backend.OpenSnapshotObject(bucketName, snapshotName, objectKey)
+	if err != nil {
+		return Object{}, nil, wrap(ErrObjectAccess, "Could not access object", err)
+	}
+	content, err = s.openContent(objs[i], content, customerKey)
+	if err != nil {
+		return Object{}, nil, err
+	}
+	return objs[i], content, nil
+}
+
+// RollbackBucket replaces the objects of a bucket with those of one of its
+// snapshots. Objects created after the snapshot are deleted; the snapshot
+// itself is kept. A rollback which would delete or replace a locked object
+// is refused.
+func (s *Store) RollbackBucket(bucketName string, snapshotName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapObjs, err := s.snapshotObjects(bucketName, snapshotName)
+	if err != nil {
+		return err
+	}
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return err
+	}
+	err = checkRollbackLocks(objs, snapObjs, time.Now())
+	if err != nil {
+		return err
+	}
+	entry := journalEntry{Op: opRollbackBucket, Bucket: bucketName, Snapshot: snapshotName, Time: time.Now()}
+	err = s.journaled(entry, func() error {
+		return s.rollback(entry)
+	})
+	if err != nil {
+		return err
+	}
+	// Rolling back is not refused for exceeding a quota: it restores
+	// what the bucket held before.
+	s.usage.add(bucketName, objs, -1)
+	s.usage.add(bucketName, snapObjs, 1)
+	return nil
+}
+
+// rollback performs the steps of a rollback. They can be repeated, so an
+// interrupted rollback is recovered by running them again.
+func (s *Store) rollback(entry journalEntry) error {
+	objs, err := s.readObjects(entry.Bucket)
+	if err != nil {
+		return err
+	}
+	snapObjs, err := s.backend.ReadSnapshotObjects(entry.Bucket, entry.Snapshot)
+	if err != nil {
+		return wrap(ErrMetadata, "Could not read snapshot metadata", err)
+	}
+	// Objects are removed first, as one of them may be stored where a
+	// restored key needs a directory ("a/b" and "a").
+	for _, obj := range objs {
+		if findObject(snapObjs, obj.Key) >= 0 {
+			continue
+		}
+		err := s.backend.RemoveObject(entry.Bucket, obj.Key)
+		if err != nil {
+			return wrap(ErrObjectAccess, "Could not delete object", err)
+		}
+	}
+	for _, obj := range snapObjs {
+		err := s.backend.RestoreSnapshotObject(entry.Bucket, entry.Snapshot, obj.Key)
+		if err != nil {
+			return wrap(ErrObjectAccess, "Could not restore object", err)
+		}
+	}
+	keepLocks(objs, snapObjs)
+	err = s.writeObjects(entry.Bucket, snapObjs)
+	if err != nil {
+		return err
+	}
+	return s.touchBucket(entry.Bucket, entry.Time)
+}
+
+// recoverSnapshot removes the storage of a snapshot which is not listed,
+// which rolls back its creation or completes its deletion.
+func (s *Store) recoverSnapshot(entry journalEntry) error {
+	snaps, err := s.readSnapshots(entry.Bucket)
+	if err != nil {
+		return err
+	}
+	if findSnapshot(snaps, entry.Snapshot) >= 0 {
+		return nil
+	}
+	return s.backend.RemoveSnapshot(entry.Bucket, entry.Snapshot)
+}
+
+// recoverRollback completes a rollback, unless the snapshot is gone.
+func (s *Store) recoverRollback(entry journalEntry) error {
+	snaps, err := s.readSnapshots(entry.Bucket)
+	if err != nil {
+		return err
+	}
+	if findSnapshot(snaps, entry.Snapshot) < 0 {
+		return errors.New("snapshot " + entry.Snapshot + " of an interrupted rollback does not exist")
+	}
+	return s.rollback(entry)
+}
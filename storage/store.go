@@ -0,0 +1,395 @@
+// Package storage implements the triple-s bucket and object store.
+//
+// A Store validates requests and keeps buckets, objects and bucket
+// configuration consistent; where the data is kept is up to its Backend.
+// The default backend keeps it in a directory: buckets.csv lists the
+// buckets, and every bucket is a subdirectory holding its objects next to
+// an objects.csv file describing them. Server state which belongs to no
+// bucket lives in the reserved .triple-s subdirectory.
+package storage
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TimeLayout is the format of the timestamps kept in the metadata files.
+const TimeLayout = "2006-01-02T15-04-05"
+
+// systemDir holds server state which does not belong to any bucket. Its
+// name is reserved and cannot be used for a bucket.
+const systemDir = ".triple-s"
+
+// Bucket status values kept in buckets.csv. A bucket is left Deleted when
+// its directory could not be removed.
+const (
+	StatusActive  = "Active"
+	StatusDeleted = "Deleted"
+)
+
+type Bucket struct {
+	Name             string
+	CreationTime     time.Time
+	LastModifiedTime time.Time
+	Status           string
+}
+
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	// ETag is the hex-encoded MD5 digest of the content. It is empty for
+	// objects stored before digests were recorded.
+	ETag string
+	// Compression names the algorithm the content is stored compressed
+	// with. Size and ETag always describe the original content, and
+	// StoredSize is the size of compressed or encrypted content as it is
+	// stored.
+	Compression string
+	StoredSize  int64
+	// Encryption is the mode the content is encrypted with at rest.
+	// WrappedKey is then its data key, sealed with the master key
+	// MasterKeyId (SSE-S3) or with the customer's key (SSE-C).
+	Encryption  string
+	MasterKeyId string
+	WrappedKey  []byte
+	// LockMode and RetainUntil are the Object Lock retention of the
+	// object; LockMode is empty for an object without retention.
+	// LegalHold keeps the object regardless of its retention.
+	LockMode    string
+	RetainUntil time.Time
+	LegalHold   bool
+	// Owner is the access key of the user who uploaded the object, whose
+	// quota it counts against. It is empty for anonymous uploads.
+	Owner string
+}
+
+// Store is safe for concurrent use by multiple goroutines.
+type Store struct {
+	backend Backend
+	mu      sync.RWMutex
+	// keyring holds the master keys of SSE-S3; nil disables it.
+	keyring atomic.Pointer[Keyring]
+	// unrecovered is set when a journaled operation failed and could not
+	// be recovered.
+	unrecovered bool
+	usage       usageCounter
+}
+
+// New returns a store which keeps its data in backend. If the backend
+// keeps a journal, an operation interrupted by a crash is recovered first.
+func New(backend Backend) (*Store, error) {
+	s := &Store{backend: backend}
+	err := s.recoverJournal()
+	if err != nil {
+		return nil, wrap(ErrMetadata, "Could not recover the interrupted operation", err)
+	}
+	err = s.countUsage()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Open returns the store kept in dir, creating the directory and its
+// bucket metadata if they do not exist yet.
+func Open(dir string) (*Store, error) {
+	backend, err := NewFSBackend(dir)
+	if err != nil {
+		return nil, err
+	}
+	return New(backend)
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(TimeLayout)
+}
+
+func parseTime(value string) time.Time {
+	t, _ := time.ParseInLocation(TimeLayout, value, time.Local)
+	return t
+}
+
+func (s *Store) readBuckets() ([]Bucket, error) {
+	bkts, err := s.backend.ReadBuckets()
+	if err != nil {
+		return nil, wrap(ErrMetadata, "Could not read bucket metadata", err)
+	}
+	return bkts, nil
+}
+
+func (s *Store) writeBuckets(bkts []Bucket) error {
+	err := s.backend.WriteBuckets(bkts)
+	if err != nil {
+		return wrap(ErrMetadata, "Could not update bucket metadata", err)
+	}
+	return nil
+}
+
+func (s *Store) readObjects(bucketName string) ([]Object, error) {
+	objs, err := s.backend.ReadObjects(bucketName)
+	if err != nil {
+		return nil, wrap(ErrMetadata, "Could not read object metadata", err)
+	}
+	return objs, nil
+}
+
+func (s *Store) writeObjects(bucketName string, objs []Object) error {
+	err := s.backend.WriteObjects(bucketName, objs)
+	if err != nil {
+		return wrap(ErrMetadata, "Could not update object metadata", err)
+	}
+	return nil
+}
+
+// touchBucket sets the last modification time of an active bucket.
+func (s *Store) touchBucket(bucketName string, now time.Time) error {
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return err
+	}
+	for i := range bkts {
+		if bkts[i].Name == bucketName && bkts[i].Status == StatusActive {
+			bkts[i].LastModifiedTime = now
+		}
+	}
+	return s.writeBuckets(bkts)
+}
+
+var (
+	bucketCharsetAndLength = regexp.MustCompile("^[-.a-z0-9]{3,63}$")
+	bucketIpFormat         = regexp.MustCompile("^[0-9]+[.][0-9]+[.][0-9]+[.][0-9]+$")
+	bucketConsecutive      = regexp.MustCompile("--|[.][.]")
+)
+
+// ValidateBucketName reports why a name cannot be used for a bucket, or
+// returns nil if it can.
+func ValidateBucketName(bucketName string) error {
+	reason := ""
+	switch {
+	case !bucketCharsetAndLength.MatchString(bucketName):
+		reason = "Contains invalid characters and/or too short/long"
+	case bucketIpFormat.MatchString(bucketName):
+		reason = "Name must not match ip-format"
+	case bucketName[0] == '-' || bucketName[len(bucketName)-1] == '-':
+		reason = "Name must not start or end with a hyphen"
+	case bucketName == systemDir:
+		reason = "Name is reserved"
+	case bucketConsecutive.MatchString(bucketName):
+		reason = "Name must not contain consecutive hyphens/dots"
+	default:
+		return nil
+	}
+	return wrap(ErrInvalidBucketName, "Bucket name is invalid - "+reason, nil)
+}
+
+// ListBuckets returns the active buckets in the order they were created.
+func (s *Store) ListBuckets() ([]Bucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return nil, err
+	}
+	active := bkts[:0]
+	for _, bkt := range bkts {
+		if bkt.Status == StatusActive {
+			active = append(active, bkt)
+		}
+	}
+	return active, nil
+}
+
+func (s *Store) bucket(bucketName string) (Bucket, error) {
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return Bucket{}, err
+	}
+	for _, bkt := range bkts {
+		if bkt.Name == bucketName && bkt.Status == StatusActive {
+			return bkt, nil
+		}
+	}
+	return Bucket{}, ErrBucketNotFound
+}
+
+// Bucket returns the active bucket with the given name.
+func (s *Store) Bucket(bucketName string) (Bucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bucket(bucketName)
+}
+
+// CreateBucket creates an empty bucket. Object Lock can only be enabled
+// for a bucket when it is created, with objectLock.
+func (s *Store) CreateBucket(bucketName string, objectLock bool) (Bucket, error) {
+	err := ValidateBucketName(bucketName)
+	if err != nil {
+		return Bucket{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return Bucket{}, err
+	}
+	now := time.Now()
+	bkt := Bucket{Name: bucketName, CreationTime: now, LastModifiedTime: now, Status: StatusActive}
+	err = s.journaled(journalEntry{Op: opCreateBucket, Bucket: bucketName, Time: now}, func() error {
+		err := s.backend.CreateBucket(bucketName)
+		if errors.Is(err, ErrBucketExists) {
+			return ErrBucketExists
+		}
+		if err != nil {
+			return wrap(ErrBucketAccess, "Could not create bucket", err)
+		}
+		if objectLock {
+			err = s.writeBucketConfig(bucketName, BucketConfig{ObjectLock: &ObjectLockConfig{Enabled: true}})
+			if err != nil {
+				return err
+			}
+		}
+		return s.writeBuckets(append(bkts, bkt))
+	})
+	if err != nil {
+		return Bucket{}, err
+	}
+	s.usage.buckets[bucketName] = Usage{}
+	return bkt, nil
+}
+
+// DeleteBucket removes an empty bucket without snapshots together with its
+// configuration.
+func (s *Store) DeleteBucket(bucketName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, bkt := range bkts {
+		if bkt.Name == bucketName && bkt.Status == StatusActive {
+			index = i
+		}
+	}
+	if index < 0 {
+		return ErrBucketNotFound
+	}
+
+	objs, err := s.readObjects(bucketName)
+	if err != nil {
+		return err
+	}
+	if len(objs) > 0 {
+		return ErrBucketNotEmpty
+	}
+	snaps, err := s.readSnapshots(bucketName)
+	if err != nil {
+		return err
+	}
+	if len(snaps) > 0 {
+		return wrap(ErrBucketNotEmpty, "Bucket has snapshots; delete them first", nil)
+	}
+
+	err = s.journaled(journalEntry{Op: opDeleteBucket, Bucket: bucketName, Time: time.Now()}, func() error {
+		err := s.backend.RemoveBucket(bucketName)
+		if errors.Is(err, errIncompleteRemoval) {
+			bkts[index].Status = StatusDeleted
+			return s.writeBuckets(bkts)
+		}
+		if err != nil {
+			return wrap(ErrBucketAccess, "Could not delete bucket", err)
+		}
+		err = s.writeBuckets(append(bkts[:index], bkts[index+1:]...))
+		if err != nil {
+			return err
+		}
+		return s.removeBucketConfig(bucketName)
+	})
+	if err != nil {
+		return err
+	}
+	delete(s.usage.buckets, bucketName)
+	return nil
+}
+
+type BucketStats struct {
+	Name    string
+	Objects int
+	Bytes   int64
+	// StoredBytes is the size of the content as stored, which is less
+	// than Bytes for compressed objects.
+	StoredBytes int64
+}
+
+// CompressionRatio returns the size of the objects per byte stored, or 1
+// for an empty bucket.
+func (s BucketStats) CompressionRatio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+	return float64(s.Bytes) / float64(s.StoredBytes)
+}
+
+// Stats returns the number of objects and stored bytes of every active
+// bucket.
+func (s *Store) Stats() ([]BucketStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bkts, err := s.readBuckets()
+	if err != nil {
+		return nil, err
+	}
+	var stats []BucketStats
+	for _, bkt := range bkts {
+		if bkt.Status != StatusActive {
+			continue
+		}
+		objs, err := s.readObjects(bkt.Name)
+		if err != nil {
+			return nil, err
+		}
+		st := BucketStats{Name: bkt.Name, Objects: len(objs)}
+		for _, obj := range objs {
+			st.Bytes += obj.Size
+			st.StoredBytes += storedSize(obj)
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats, nil
+}
+
+// Check verifies that the bucket metadata is readable and that the
+// backend accepts writes.
+func (s *Store) Check() error {
+	s.mu.RLock()
+	_, err := s.readBuckets()
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	err = s.backend.Check()
+	if err != nil {
+		return wrap(ErrBucketAccess, "Storage is not writable", err)
+	}
+	return nil
+}
+
+// Sync commits written data to stable storage.
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.backend.Sync()
+	if err != nil {
+		return wrap(ErrMetadata, "Could not sync metadata", err)
+	}
+	return nil
+}
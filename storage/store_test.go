@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidateBucketName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"photos", true},
+		{"my-bucket.2024", true},
+		{"abc", true},
+		{strings.Repeat("a", 63), true},
+		{"ab", false},
+		{strings.Repeat("a", 64), false},
+		{"Photos", false},
+		{"my_bucket", false},
+		{"192.168.0.1", false},
+		{"-photos", false},
+		{"photos-", false},
+		{"my--bucket", false},
+		{"my..bucket", false},
+		{".triple-s", false},
+	}
+	for _, test := range tests {
+		err := ValidateBucketName(test.name)
+		if test.valid && err != nil {
+			t.Errorf("ValidateBucketName(%q) = %v, want nil", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidBucketName) {
+			t.Errorf("ValidateBucketName(%q) = %v, want ErrInvalidBucketName", test.name, err)
+		}
+	}
+}
+
+func TestValidateObjectKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want error
+	}{
+		{"cat.jpg", nil},
+		{"a/b/c.txt", nil},
+		{"with space", nil},
+		{strings.Repeat("k", MaxKeyLength), nil},
+		{"", ErrInvalidObjectKey},
+		{strings.Repeat("k", MaxKeyLength+1), ErrInvalidObjectKey},
+		{"a//b", ErrInvalidObjectKey},
+		{"/a", ErrInvalidObjectKey},
+		{"a/", ErrInvalidObjectKey},
+		{"a/./b", ErrInvalidObjectKey},
+		{"../a", ErrInvalidObjectKey},
+		{objectMetadataFile, ErrMetadataAccess},
+		{objectMetadataFile + ".tmp", ErrMetadataAccess},
+	}
+	for _, test := range tests {
+		err := validateObjectKey(test.key)
+		if test.want == nil && err != nil {
+			t.Errorf("validateObjectKey(%q) = %v, want nil", test.key, err)
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("validateObjectKey(%q) = %v, want %v", test.key, err, test.want)
+		}
+	}
+}
+
+func putString(t *testing.T, store *Store, bucketName string, objectKey string, content string) Object {
+	t.Helper()
+	obj, err := store.PutObject(bucketName, objectKey, strings.NewReader(content), PutOptions{})
+	if err != nil {
+		t.Fatalf("PutObject(%q, %q): %v", bucketName, objectKey, err)
+	}
+	return obj
+}
+
+func getString(t *testing.T, store *Store, bucketName string, objectKey string) string {
+	t.Helper()
+	_, content, err := store.GetObject(bucketName, objectKey, nil)
+	if err != nil {
+		t.Fatalf("GetObject(%q, %q): %v", bucketName, objectKey, err)
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBucketCRUD(t *testing.T) {
+	forEachBackend(t, testBucketCRUD)
+}
+
+func testBucketCRUD(t *testing.T, store *Store) {
+	bkt, err := store.CreateBucket("photos", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bkt.Name != "photos" || bkt.Status != StatusActive {
+		t.Errorf("CreateBucket = %+v", bkt)
+	}
+	_, err = store.CreateBucket("photos", false)
+	if !errors.Is(err, ErrBucketExists) {
+		t.Errorf("CreateBucket of an existing bucket = %v, want ErrBucketExists", err)
+	}
+	_, err = store.CreateBucket("No", false)
+	if !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("CreateBucket of an invalid name = %v, want ErrInvalidBucketName", err)
+	}
+	_, err = store.CreateBucket("music", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bkts, err := store.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bkts) != 2 || bkts[0].Name != "photos" || bkts[1].Name != "music" {
+		t.Errorf("ListBuckets = %+v, want photos and music", bkts)
+	}
+
+	putString(t, store, "photos", "cat.jpg", "meow")
+	err = store.DeleteBucket("photos")
+	if !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("DeleteBucket of a bucket with objects = %v, want ErrBucketNotEmpty", err)
+	}
+	err = store.DeleteObject("photos", "cat.jpg", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DeleteBucket("photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DeleteBucket("photos")
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("DeleteBucket of a deleted bucket = %v, want ErrBucketNotFound", err)
+	}
+	_, err = store.Bucket("photos")
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Bucket of a deleted bucket = %v, want ErrBucketNotFound", err)
+	}
+
+	// A deleted bucket's name can be used again.
+	_, err = store.CreateBucket("photos", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObjectCRUD(t *testing.T) {
+	forEachBackend(t, testObjectCRUD)
+}
+
+func testObjectCRUD(t *testing.T, store *Store) {
+	_, err := store.CreateBucket("photos", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj := putString(t, store, "photos", "cats/tom.jpg", "meow")
+	if obj.Size != 4 || obj.ETag != "4a4be40c96ac6314e91d93f38043a634" || obj.ContentType != "text/plain" {
+		t.Errorf("PutObject = %+v", obj)
+	}
+	if got := getString(t, store, "photos", "cats/tom.jpg"); got != "meow" {
+		t.Errorf("content = %q, want meow", got)
+	}
+
+	putString(t, store, "photos", "cats/tom.jpg", "purr!")
+	head, err := store.HeadObject("photos", "cats/tom.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Size != 5 {
+		t.Errorf("size after overwrite = %d, want 5", head.Size)
+	}
+	if got := getString(t, store, "photos", "cats/tom.jpg"); got != "purr!" {
+		t.Errorf("content after overwrite = %q, want purr!", got)
+	}
+
+	_, err = store.PutObject("nothing", "a", strings.NewReader("x"), PutOptions{})
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("PutObject to a missing bucket = %v, want ErrBucketNotFound", err)
+	}
+	_, err = store.PutObject("photos", "a/../b", strings.NewReader("x"), PutOptions{})
+	if !errors.Is(err, ErrInvalidObjectKey) {
+		t.Errorf("PutObject with an invalid key = %v, want ErrInvalidObjectKey", err)
+	}
+
+	err = store.DeleteObject("photos", "cats/tom.jpg", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = store.GetObject("photos", "cats/tom.jpg", nil)
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("GetObject of a deleted object = %v, want ErrObjectNotFound", err)
+	}
+	err = store.DeleteObject("photos", "cats/tom.jpg", false)
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("DeleteObject of a deleted object = %v, want ErrObjectNotFound", err)
+	}
+}
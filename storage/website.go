@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"strings"
+)
+
+// WebsiteConfig serves a bucket as a static website. Either
+// RedirectAllRequestsTo is set, or IndexDocument is, optionally with the
+// other fields.
+type WebsiteConfig struct {
+	// IndexDocument is the name of the object served for a request for a
+	// directory, such as index.html.
+	IndexDocument string `json:"indexDocument,omitempty"`
+	// ErrorDocument is the key of the object served for a missing object.
+	ErrorDocument         string        `json:"errorDocument,omitempty"`
+	RedirectAllRequestsTo *Redirect     `json:"redirectAllRequestsTo,omitempty"`
+	RoutingRules          []RoutingRule `json:"routingRules,omitempty"`
+}
+
+// RoutingRule redirects the requests for keys starting with
+// KeyPrefixEquals, or those which failed with HTTPErrorCode when it is not
+// 0.
+type RoutingRule struct {
+	KeyPrefixEquals string   `json:"keyPrefixEquals,omitempty"`
+	HTTPErrorCode   int      `json:"httpErrorCode,omitempty"`
+	Redirect        Redirect `json:"redirect"`
+}
+
+// Redirect is where a website request is redirected. Empty fields keep the
+// protocol, host and key of the request.
+type Redirect struct {
+	Protocol string `json:"protocol,omitempty"`
+	HostName string `json:"hostName,omitempty"`
+	// ReplaceKeyPrefixWith replaces the KeyPrefixEquals of the rule, and
+	// ReplaceKeyWith the whole key; at most one is set.
+	ReplaceKeyPrefixWith *string `json:"replaceKeyPrefixWith,omitempty"`
+	ReplaceKeyWith       string  `json:"replaceKeyWith,omitempty"`
+	// HTTPRedirectCode is the status of the redirect; 0 is 301.
+	HTTPRedirectCode int `json:"httpRedirectCode,omitempty"`
+}
+
+func validateRedirect(redirect Redirect) error {
+	if redirect.Protocol != "" && redirect.Protocol != "http" && redirect.Protocol != "https" {
+		return wrap(ErrInvalidArgument, "Redirect protocol must be http or https", nil)
+	}
+	if redirect.ReplaceKeyPrefixWith != nil && redirect.ReplaceKeyWith != "" {
+		return wrap(ErrInvalidArgument, "A redirect cannot replace both the key and its prefix", nil)
+	}
+	if redirect.HTTPRedirectCode != 0 && (redirect.HTTPRedirectCode < 300 || redirect.HTTPRedirectCode > 399) {
+		return wrap(ErrInvalidArgument, "HttpRedirectCode must be a 3xx status", nil)
+	}
+	return nil
+}
+
+func validateWebsite(cfg WebsiteConfig) error {
+	if cfg.RedirectAllRequestsTo != nil {
+		if cfg.IndexDocument != "" || cfg.ErrorDocument != "" || len(cfg.RoutingRules) > 0 {
+			return wrap(ErrInvalidArgument, "RedirectAllRequestsTo excludes all other website settings", nil)
+		}
+		redirect := *cfg.RedirectAllRequestsTo
+		if redirect.HostName == "" || redirect.ReplaceKeyPrefixWith != nil || redirect.ReplaceKeyWith != "" || redirect.HTTPRedirectCode != 0 {
+			return wrap(ErrInvalidArgument, "RedirectAllRequestsTo needs a host name and takes only a protocol besides", nil)
+		}
+		return validateRedirect(redirect)
+	}
+	if cfg.IndexDocument == "" || strings.Contains(cfg.IndexDocument, "/") {
+		return wrap(ErrInvalidArgument, "The index document must be a name without '/'", nil)
+	}
+	if cfg.ErrorDocument != "" {
+		err := validateObjectKey(cfg.ErrorDocument)
+		if err != nil {
+			return err
+		}
+	}
+	if len(cfg.RoutingRules) > 50 {
+		return wrap(ErrInvalidArgument, "A website configuration can have at most 50 routing rules", nil)
+	}
+	for _, rule := range cfg.RoutingRules {
+		if rule.HTTPErrorCode != 0 && (rule.HTTPErrorCode < 400 || rule.HTTPErrorCode > 599) {
+			return wrap(ErrInvalidArgument, "HttpErrorCodeReturnedEquals must be a 4xx or 5xx status", nil)
+		}
+		err := validateRedirect(rule.Redirect)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBucketWebsite serves a bucket as a static website, or stops serving
+// it when cfg is nil.
+func (s *Store) SetBucketWebsite(bucketName string, cfg *WebsiteConfig) error {
+	if cfg != nil {
+		err := validateWebsite(*cfg)
+		if err != nil {
+			return err
+		}
+	}
+	return s.UpdateBucketConfig(bucketName, func(bc *BucketConfig) error {
+		bc.Website = cfg
+		return nil
+	})
+}
+
+// MatchRoutingRule returns the first routing rule of cfg for a request for
+// key which failed with status, or which is about to be served when
+// status is 0.
+func (cfg WebsiteConfig) MatchRoutingRule(key string, status int) (RoutingRule, bool) {
+	for _, rule := range cfg.RoutingRules {
+		if rule.HTTPErrorCode == status && strings.HasPrefix(key, rule.KeyPrefixEquals) {
+			return rule, true
+		}
+	}
+	return RoutingRule{}, false
+}
+
+// RedirectKey returns the key a request for key is redirected to by rule.
+func (rule RoutingRule) RedirectKey(key string) string {
+	switch {
+	case rule.Redirect.ReplaceKeyWith != "":
+		return rule.Redirect.ReplaceKeyWith
+	case rule.Redirect.ReplaceKeyPrefixWith != nil:
+		return *rule.Redirect.ReplaceKeyPrefixWith + strings.TrimPrefix(key, rule.KeyPrefixEquals)
+	}
+	return key
+}